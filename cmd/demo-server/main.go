@@ -1,35 +1,18 @@
-/*
-Copyright AppsCode Inc. and Contributors
-
-Licensed under the AppsCode Community License 1.0.0 (the "License");
-you may not use this file except in compliance with the License.
-You may obtain a copy of the License at
-
-    https://github.com/appscode/licenses/raw/1.0.0/AppsCode-Community-1.0.0.md
-
-Unless required by applicable law or agreed to in writing, software
-distributed under the License is distributed on an "AS IS" BASIS,
-WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
-See the License for the specific language governing permissions and
-limitations under the License.
-*/
-
 package main
 
 import (
-	"flag"
+	"context"
 	"net/http"
 
+	"kubeops.dev/cluster-connector/pkg/hub"
+	"kubeops.dev/cluster-connector/pkg/inventory"
 	"kubeops.dev/cluster-connector/pkg/link"
 	"kubeops.dev/cluster-connector/pkg/shared"
+	"kubeops.dev/cluster-connector/pkg/transport"
 
-	"github.com/go-chi/chi/v5"
-	"github.com/go-chi/chi/v5/middleware"
 	"github.com/nats-io/nats.go"
-	"github.com/unrolled/render"
+	"github.com/spf13/pflag"
 	auditlib "go.bytebuilders.dev/audit/lib"
-	"go.wandrs.dev/binding"
-	"go.wandrs.dev/inject"
 	"gomodules.xyz/blobfs"
 	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
 	"k8s.io/client-go/rest"
@@ -41,8 +24,19 @@ import (
 	"sigs.k8s.io/controller-runtime/pkg/client/apiutil"
 )
 
+// demo-server runs the hub against the license bucket and the audit NATS server, for a fixed test user.
+// Use "cluster-connector hub" for real deployments.
 func main() {
-	fs := blobfs.New("gs://" + shared.LicenseBucket)
+	var (
+		linkLifetime = shared.ConnectorLinkLifetime
+		linkKeyFile  string
+		natsOpts     hub.ProvisionerOptions
+	)
+	pflag.DurationVar(&linkLifetime, "link-lifetime", linkLifetime, "Duration a generated link can be used to connect a cluster")
+	pflag.StringVar(&linkKeyFile, "link-signing-key-file", linkKeyFile, "Path to the key used to sign link tokens. If empty, a random key is used and links do not survive a restart.")
+	natsOpts.AddFlags(pflag.CommandLine)
+
+	store := link.NewBlobFSStore(blobfs.New("gs://"+shared.LicenseBucket, "cluster-connector"))
 	bs, err := link.NewBlobStore()
 	if err != nil {
 		panic(err)
@@ -52,47 +46,39 @@ func main() {
 	if err != nil {
 		panic(err)
 	}
+	signer, err := hub.NewTokenSigner(linkKeyFile, linkLifetime)
+	if err != nil {
+		panic(err)
+	}
+	sysConn := transport.NewConnectionOptions()
+	sysConn.Addr = natsOpts.ConnectorAddr
+	np, err := natsOpts.New(context.Background(), blobfs.New("gs://"+shared.LicenseBucket, "cluster-connector"), *shared.NewSubjectOptions(), *sysConn)
+	if err != nil {
+		panic(err)
+	}
 	testUser := shared.User{
 		Name:  "Tamal Saha",
 		Email: "tamal@appscode.com",
 	}
 
-	m := chi.NewRouter()
-
-	// A good base middleware stack
-	m.Use(middleware.RequestID)
-	m.Use(middleware.RealIP)
-	m.Use(middleware.Logger)
-	m.Use(middleware.Recoverer)
-	m.Use(binding.Injector(render.New()))
-
-	m.Route(shared.ConnectorAPIPathPrefix, func(r chi.Router) {
-		m.Use(binding.Inject(func(injector inject.Injector) error {
-			injector.Map(fs)
-			injector.Map(bs)
-			injector.MapTo(repo.NewDiskCacheRegistry(), (repo.IRegistry)(nil))
-
-			// WARNING: Must be detected from signed-in user and connect to NATS accordingly
-			injector.Map(testUser)
-			injector.Map(nc)
-			return nil
-		}))
-		m.
-			With(binding.JSON(shared.LinkRequest{})).
-			Post(shared.ConnectorLinkAPIPath, binding.HandlerFunc(genLink))
-
-		m.
-			With(binding.JSON(shared.CallbackRequest{})).
-			Post(shared.ConnectorCallbackAPIPath, binding.HandlerFunc(handleCallback))
-	})
-
-	_ = http.ListenAndServe(":3333", m)
+	srv := &hub.Server{
+		Store:       store,
+		Signer:      signer,
+		Provisioner: np,
+		Blobs:       bs,
+		Registry:    repo.NewDiskCacheRegistry(),
+		Inventory:   inventory.NewService(store, hub.DefaultStaleAfter),
+		Tenants:     hub.SharedTenants{Nats: nc, Subjects: *shared.NewSubjectOptions()},
+		Auth:        hub.StaticUser(testUser),
+		StaleAfter:  hub.DefaultStaleAfter,
+	}
+	_ = http.ListenAndServe(":3333", srv.Handler())
 }
 
 func getNatsClient() (*nats.Conn, error) {
 	var licenseFile string
-	flag.StringVar(&licenseFile, "license-file", licenseFile, "Path to license file")
-	flag.Parse()
+	pflag.StringVar(&licenseFile, "license-file", licenseFile, "Path to license file")
+	pflag.Parse()
 
 	ctrl.SetLogger(klog.NewKlogr())
 	config := ctrl.GetConfigOrDie()
@@ -0,0 +1,557 @@
+/*
+Copyright AppsCode Inc. and Contributors
+
+Licensed under the AppsCode Community License 1.0.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://github.com/appscode/licenses/raw/1.0.0/AppsCode-Community-1.0.0.md
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmds
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"net/url"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"kubeops.dev/cluster-connector/pkg/shared"
+
+	"github.com/go-logr/logr"
+	"github.com/spf13/pflag"
+	core "k8s.io/api/core/v1"
+	kerr "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const (
+	callbackStatePending    = "pending"
+	callbackStateRegistered = "registered"
+	callbackStateRejected   = "rejected"
+
+	registrationKeyLinkID       = "link-id"
+	registrationKeyClusterID    = "cluster-id"
+	registrationKeyPublicKey    = "public-key"
+	registrationKeyRegisteredAt = "registered-at"
+	registrationKeyNatsJWT      = "nats-jwt"
+)
+
+type callbackOptions struct {
+	BaseURL          string
+	CAFile           string
+	ProxyURL         string
+	Timeout          time.Duration
+	RetryInterval    time.Duration
+	RetryMaxInterval time.Duration
+	// ConnectInterval is the interval a registered connector presents its identity to the hub at.
+	ConnectInterval time.Duration
+	// SecretName stores the registration state in the namespace of the connector pod.
+	SecretName string
+	// IdentitySecretName stores the key pair the callback is signed with.
+	IdentitySecretName string
+}
+
+func newCallbackOptions() *callbackOptions {
+	return &callbackOptions{
+		Timeout:            30 * time.Second,
+		RetryInterval:      time.Second,
+		RetryMaxInterval:   5 * time.Minute,
+		ConnectInterval:    time.Hour,
+		SecretName:         "cluster-connector-registration",
+		IdentitySecretName: "cluster-connector-identity",
+	}
+}
+
+func (o *callbackOptions) AddFlags(fs *pflag.FlagSet) {
+	fs.StringVar(&o.BaseURL, "baseURL", o.BaseURL, "License server base url")
+	fs.StringVar(&o.CAFile, "callback-ca-file", o.CAFile, "PEM encoded CA bundle trusted for the link callback in addition to the system roots")
+	fs.StringVar(&o.ProxyURL, "callback-proxy", o.ProxyURL, "HTTP proxy url used for the link callback. If empty, HTTPS_PROXY and NO_PROXY environment variables are used.")
+	fs.DurationVar(&o.Timeout, "callback-timeout", o.Timeout, "Timeout of a link callback request")
+	fs.DurationVar(&o.RetryInterval, "callback-retry-interval", o.RetryInterval, "Initial interval between failed link callback attempts")
+	fs.DurationVar(&o.RetryMaxInterval, "callback-retry-max-interval", o.RetryMaxInterval, "Maximum interval between failed link callback attempts")
+	fs.DurationVar(&o.ConnectInterval, "connect-interval", o.ConnectInterval, "Interval at which a registered connector presents its identity to the hub, so that the hub notices clones of the connector in other clusters. If zero, the identity is only presented on start.")
+	fs.StringVar(&o.IdentitySecretName, "identity-secret", o.IdentitySecretName, "Name of the Secret in the connector namespace holding the identity and key pair the connector signs its requests to the hub with. It is generated on first start.")
+	fs.StringVar(&o.SecretName, "registration-secret", o.SecretName, "Name of the Secret in the connector namespace used to remember a successful link callback across restarts. If empty, the callback is made on every start.")
+}
+
+func (o *callbackOptions) Validate() error {
+	var errs []error
+	if o.ProxyURL != "" {
+		if _, err := url.Parse(o.ProxyURL); err != nil {
+			errs = append(errs, fmt.Errorf("invalid --callback-proxy: %w", err))
+		}
+	}
+	if o.CAFile != "" {
+		if _, err := os.Stat(o.CAFile); err != nil {
+			errs = append(errs, fmt.Errorf("invalid --callback-ca-file: %w", err))
+		}
+	}
+	if o.Timeout <= 0 {
+		errs = append(errs, errors.New("--callback-timeout must be positive"))
+	}
+	if o.RetryInterval <= 0 {
+		errs = append(errs, errors.New("--callback-retry-interval must be positive"))
+	}
+	if o.RetryMaxInterval < o.RetryInterval {
+		errs = append(errs, errors.New("--callback-retry-max-interval must not be less than --callback-retry-interval"))
+	}
+	if o.ConnectInterval < 0 {
+		errs = append(errs, errors.New("--connect-interval must not be negative"))
+	}
+	return errors.Join(errs...)
+}
+
+func (o *callbackOptions) HTTPClient() (*http.Client, error) {
+	tr := http.DefaultTransport.(*http.Transport).Clone()
+	if o.ProxyURL != "" {
+		u, err := url.Parse(o.ProxyURL)
+		if err != nil {
+			return nil, err
+		}
+		tr.Proxy = http.ProxyURL(u)
+	}
+	if o.CAFile != "" {
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		data, err := os.ReadFile(o.CAFile)
+		if err != nil {
+			return nil, err
+		}
+		if !pool.AppendCertsFromPEM(data) {
+			return nil, fmt.Errorf("no certificates found in %s", o.CAFile)
+		}
+		tr.TLSClientConfig = &tls.Config{
+			RootCAs:    pool,
+			MinVersion: tls.VersionTLS12,
+		}
+	}
+	return &http.Client{
+		Transport: tr,
+		Timeout:   o.Timeout,
+	}, nil
+}
+
+func (o *callbackOptions) backoff() wait.Backoff {
+	return wait.Backoff{
+		Duration: o.RetryInterval,
+		Factor:   2,
+		Jitter:   0.2,
+		Steps:    math.MaxInt32,
+		Cap:      o.RetryMaxInterval,
+	}
+}
+
+// registration is the link the hub accepted the callback for.
+type registration struct {
+	shared.CallbackRequest
+	// NatsJWT is the NATS user JWT the hub renewed for the connector, see shared.CallbackResponse.
+	NatsJWT string
+}
+
+// registrationStore remembers the link the hub accepted the callback for.
+type registrationStore interface {
+	// Get returns nil if no link was registered.
+	Get(ctx context.Context) (*registration, error)
+	Save(ctx context.Context, reg registration) error
+}
+
+// secretRegistrationStore stores the registration in a Secret. Secrets are read with
+// an uncached reader, so that the connector does not need to list secrets.
+type secretRegistrationStore struct {
+	reader client.Reader
+	writer client.Writer
+	key    client.ObjectKey
+}
+
+var _ registrationStore = &secretRegistrationStore{}
+
+func (s *secretRegistrationStore) Get(ctx context.Context) (*registration, error) {
+	var secret core.Secret
+	if err := s.reader.Get(ctx, s.key, &secret); kerr.IsNotFound(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	return &registration{
+		CallbackRequest: shared.CallbackRequest{
+			LinkID:    string(secret.Data[registrationKeyLinkID]),
+			ClusterID: string(secret.Data[registrationKeyClusterID]),
+			PublicKey: string(secret.Data[registrationKeyPublicKey]),
+		},
+		NatsJWT: string(secret.Data[registrationKeyNatsJWT]),
+	}, nil
+}
+
+func (s *secretRegistrationStore) Save(ctx context.Context, reg registration) error {
+	data := map[string][]byte{
+		registrationKeyLinkID:       []byte(reg.LinkID),
+		registrationKeyClusterID:    []byte(reg.ClusterID),
+		registrationKeyPublicKey:    []byte(reg.PublicKey),
+		registrationKeyRegisteredAt: []byte(time.Now().UTC().Format(time.RFC3339)),
+	}
+	if reg.NatsJWT != "" {
+		data[registrationKeyNatsJWT] = []byte(reg.NatsJWT)
+	}
+
+	var secret core.Secret
+	err := s.reader.Get(ctx, s.key, &secret)
+	if kerr.IsNotFound(err) {
+		return s.writer.Create(ctx, &core.Secret{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      s.key.Name,
+				Namespace: s.key.Namespace,
+			},
+			Data: data,
+		})
+	} else if err != nil {
+		return err
+	}
+	secret.Data = data
+	return s.writer.Update(ctx, &secret)
+}
+
+// rejectedError is returned if the hub rejected a request, so retrying will not help.
+type rejectedError struct {
+	status string
+	code   int
+	body   string
+}
+
+func (e *rejectedError) Error() string {
+	return fmt.Sprintf("hub rejected the request with status code %s: %s", e.status, e.body)
+}
+
+// callback registers the connector with the hub. Failed callbacks are retried with exponential
+// backoff until the hub accepts or rejects them, without stopping the manager.
+// Callbacks carry the public key and identity of the connector and are signed with its private key,
+// so that the hub can bind the link to exactly one connector. Once registered, the connector
+// presents its identity to the hub on start and every ConnectInterval. If the hub finds that
+// the link is bound to another connector, eg. because this one runs in a clone of the cluster,
+// the connector is reported unready until the hub accepts its identity again.
+// The NATS credentials of the install script expire with the link, so the NATS user JWT the hub
+// renews in its response is stored with the registration and used to connect from then on.
+type callback struct {
+	opts   *callbackOptions
+	client *http.Client
+	store  registrationStore
+	key    ed25519.PrivateKey
+	ready  <-chan struct{}
+	req    shared.CallbackRequest
+	log    logr.Logger
+	// natsJWT receives the NATS user JWT renewed by the hub, if set.
+	natsJWT *renewedJWT
+
+	mu       sync.RWMutex
+	state    string
+	attempts int
+	lastErr  error
+}
+
+func newCallback(opts *callbackOptions, store registrationStore, key ed25519.PrivateKey, ready <-chan struct{}, req shared.CallbackRequest, log logr.Logger) (*callback, error) {
+	hc, err := opts.HTTPClient()
+	if err != nil {
+		return nil, err
+	}
+	req.PublicKey = shared.EncodePublicKey(key.Public().(ed25519.PublicKey))
+	return &callback{
+		opts:   opts,
+		client: hc,
+		store:  store,
+		key:    key,
+		ready:  ready,
+		req:    req,
+		log:    log,
+		state:  callbackStatePending,
+	}, nil
+}
+
+// ReadyzCheck fails until the hub has accepted the link callback.
+func (cb *callback) ReadyzCheck(_ *http.Request) error {
+	cb.mu.RLock()
+	defer cb.mu.RUnlock()
+	switch {
+	case cb.state == callbackStateRegistered:
+		return nil
+	case cb.lastErr != nil:
+		return fmt.Errorf("link callback is %s after %d attempts: %w", cb.state, cb.attempts, cb.lastErr)
+	default:
+		return errors.New("link callback is pending")
+	}
+}
+
+func (cb *callback) setResult(err error) {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	var rejected *rejectedError
+	switch {
+	case err == nil:
+		cb.state = callbackStateRegistered
+	case errors.As(err, &rejected):
+		cb.state = callbackStateRejected
+	default:
+		cb.state = callbackStatePending
+	}
+	cb.lastErr = err
+	for _, state := range []string{callbackStatePending, callbackStateRegistered, callbackStateRejected} {
+		v := 0.0
+		if state == cb.state {
+			v = 1
+		}
+		callbackState.WithLabelValues(state).Set(v)
+	}
+}
+
+func (cb *callback) Start(ctx context.Context) error {
+	// hub verifies the cluster over nats, so wait until the proxy handlers are subscribed
+	select {
+	case <-cb.ready:
+	case <-ctx.Done():
+		return nil
+	}
+
+	if cb.registration(ctx) != nil {
+		cb.setResult(nil)
+		cb.log.Info("link already registered, skipping callback", "linkID", cb.req.LinkID)
+		return cb.connect(ctx, 0)
+	}
+	if !cb.register(ctx) {
+		return nil
+	}
+	if cb.opts.ConnectInterval <= 0 {
+		return nil
+	}
+	return cb.connect(ctx, cb.opts.ConnectInterval)
+}
+
+// register posts the callback until the hub accepts or rejects it. It returns true if the hub accepted it.
+func (cb *callback) register(ctx context.Context) bool {
+	backoff := cb.opts.backoff()
+	for {
+		cb.mu.Lock()
+		cb.attempts++
+		attempt := cb.attempts
+		cb.mu.Unlock()
+
+		var resp shared.CallbackResponse
+		err := cb.post(ctx, &resp)
+		cb.setResult(err)
+
+		var rejected *rejectedError
+		switch {
+		case err == nil:
+			callbackAttempts.WithLabelValues(callbackStateRegistered).Inc()
+			cb.log.Info("link callback successful", "attempts", attempt)
+			cb.renew(resp.NatsJWT)
+			cb.save(ctx)
+			return true
+		case errors.As(err, &rejected):
+			callbackAttempts.WithLabelValues(callbackStateRejected).Inc()
+			cb.log.Error(err, "link callback rejected by hub, giving up", "attempts", attempt)
+			return false
+		case ctx.Err() != nil:
+			return false
+		}
+
+		callbackAttempts.WithLabelValues(callbackStatePending).Inc()
+		delay := backoff.Step()
+		cb.log.Error(err, "link callback failed", "attempt", attempt, "retryAfter", delay)
+
+		if !sleep(ctx, delay) {
+			return false
+		}
+	}
+}
+
+// connect presents the identity of the registered connector to the hub after delay and then
+// every ConnectInterval. Failed requests are retried with exponential backoff and do not affect
+// readiness. A conflict with the connector the link is bound to is reported through readiness
+// and retried with backoff, so that restarting the connector does not hammer the hub. Other
+// rejections, eg. by hubs that do not know the connect endpoint, stop presenting.
+func (cb *callback) connect(ctx context.Context, delay time.Duration) error {
+	backoff := cb.opts.backoff()
+	for {
+		if !sleep(ctx, delay) {
+			return nil
+		}
+
+		var resp shared.CallbackResponse
+		err := postSigned(ctx, cb.client, shared.ConnectorConnectEndpoint(cb.opts.BaseURL), cb.key, cb.req, &resp)
+		var rejected *rejectedError
+		switch {
+		case err == nil:
+			cb.setResult(nil)
+			if cb.renew(resp.NatsJWT) {
+				cb.save(ctx)
+			}
+			if cb.opts.ConnectInterval <= 0 {
+				return nil
+			}
+			backoff = cb.opts.backoff()
+			delay = cb.opts.ConnectInterval
+			continue
+		case errors.As(err, &rejected) && rejected.code == http.StatusConflict:
+			cb.setResult(err)
+			delay = backoff.Step()
+			cb.log.Error(err, "hub rejected the identity of the connector, the link is bound to another connector", "retryAfter", delay)
+			continue
+		case errors.As(err, &rejected):
+			cb.log.Error(err, "hub rejected the identity of the connector, no longer presenting it")
+			return nil
+		case ctx.Err() != nil:
+			return nil
+		}
+
+		delay = backoff.Step()
+		cb.log.Error(err, "failed to present connector identity", "retryAfter", delay)
+	}
+}
+
+// sleep waits for d. It returns false if ctx is done first.
+func sleep(ctx context.Context, d time.Duration) bool {
+	if d <= 0 {
+		return ctx.Err() == nil
+	}
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// registration returns the registration if the hub accepted the callback for this link and cluster before.
+func (cb *callback) registration(ctx context.Context) *registration {
+	if cb.store == nil {
+		return nil
+	}
+	reg, err := cb.store.Get(ctx)
+	if err != nil {
+		cb.log.Error(err, "failed to read link registration")
+		return nil
+	}
+	if reg == nil || reg.LinkID != cb.req.LinkID || reg.ClusterID != cb.req.ClusterID || reg.PublicKey != cb.req.PublicKey {
+		return nil
+	}
+	return reg
+}
+
+// restoreNatsJWT uses the NATS user JWT stored with the registration. It must be called before
+// the connector connects to NATS, since the credentials of the install script may have expired.
+func (cb *callback) restoreNatsJWT(ctx context.Context) {
+	if reg := cb.registration(ctx); reg != nil {
+		cb.renew(reg.NatsJWT)
+	}
+}
+
+// renew uses the NATS user JWT renewed by the hub, unless the connector has one already.
+// Later renewals are ignored, so that the NATS connection is not reloaded on every connect.
+// It returns true if the JWT is used.
+func (cb *callback) renew(token string) bool {
+	if cb.natsJWT == nil || token == "" || cb.natsJWT.Get() != "" {
+		return false
+	}
+	cb.natsJWT.Set(token)
+	return true
+}
+
+// save stores the registration, so that a restarted connector skips the callback.
+func (cb *callback) save(ctx context.Context) {
+	if cb.store == nil {
+		return
+	}
+	reg := registration{CallbackRequest: cb.req}
+	if cb.natsJWT != nil {
+		reg.NatsJWT = cb.natsJWT.Get()
+	}
+	if err := cb.store.Save(ctx, reg); err != nil {
+		cb.log.Error(err, "failed to save link registration")
+	}
+}
+
+func (cb *callback) post(ctx context.Context, out any) error {
+	return postSigned(ctx, cb.client, shared.ConnectorCallbackEndpoint(cb.opts.BaseURL), cb.key, cb.req, out)
+}
+
+// postSigned sends in as json to the hub, signed with the key of the connector, and decodes
+// the response into out, unless out is nil or the response is empty.
+// A *rejectedError is returned if retrying the request will not help.
+func postSigned(ctx context.Context, hc *http.Client, url string, key ed25519.PrivateKey, in, out any) error {
+	data, err := json.Marshal(in)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	shared.SignRequest(req, data, key, time.Now())
+
+	resp, err := hc.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close() // nolint:errcheck
+
+	data, err = io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case resp.StatusCode == http.StatusOK:
+		if out == nil || len(bytes.TrimSpace(data)) == 0 {
+			return nil
+		}
+		return json.Unmarshal(data, out)
+	case resp.StatusCode >= 400 && resp.StatusCode < 500 &&
+		resp.StatusCode != http.StatusRequestTimeout && resp.StatusCode != http.StatusTooManyRequests:
+		return &rejectedError{status: resp.Status, code: resp.StatusCode, body: string(data)}
+	default:
+		return fmt.Errorf("hub responded with status code %s: %s", resp.Status, data)
+	}
+}
+
+// renewedJWT holds the NATS user JWT renewed by the hub, see transport.ConnectionOptions.UserJWT.
+type renewedJWT struct {
+	v atomic.Pointer[string]
+}
+
+// Get returns the renewed JWT, or an empty string if the hub did not renew it yet.
+func (r *renewedJWT) Get() string {
+	if token := r.v.Load(); token != nil {
+		return *token
+	}
+	return ""
+}
+
+func (r *renewedJWT) Set(token string) {
+	r.v.Store(&token)
+}
@@ -0,0 +1,275 @@
+/*
+Copyright AppsCode Inc. and Contributors
+
+Licensed under the AppsCode Community License 1.0.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://github.com/appscode/licenses/raw/1.0.0/AppsCode-Community-1.0.0.md
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmds
+
+import (
+	"context"
+	"crypto/ed25519"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"kubeops.dev/cluster-connector/pkg/shared"
+
+	"github.com/go-logr/logr"
+)
+
+type memRegistrationStore struct {
+	mu  sync.Mutex
+	reg *registration
+}
+
+func (s *memRegistrationStore) Get(context.Context) (*registration, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.reg, nil
+}
+
+func (s *memRegistrationStore) Save(_ context.Context, reg registration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.reg = &reg
+	return nil
+}
+
+var testKey = ed25519.NewKeyFromSeed(make([]byte, ed25519.SeedSize))
+
+func newTestCallback(t *testing.T, baseURL string, store registrationStore) *callback {
+	t.Helper()
+	opts := newCallbackOptions()
+	opts.BaseURL = baseURL
+	opts.RetryInterval = time.Millisecond
+	opts.RetryMaxInterval = 10 * time.Millisecond
+	opts.ConnectInterval = 0
+
+	ready := make(chan struct{})
+	close(ready)
+	cb, err := newCallback(opts, store, testKey, ready, shared.CallbackRequest{
+		LinkID:    "link1",
+		ClusterID: "cluster1",
+		Identity:  &shared.ConnectorIdentity{ID: "id1", ClusterID: "cluster1"},
+	}, logr.Discard())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cb
+}
+
+func TestCallbackRetries(t *testing.T) {
+	var calls, connects int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		in, pub, err := shared.VerifyCallback(r, body, time.Now())
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusUnauthorized)
+			return
+		}
+		if in.LinkID != "link1" || !pub.Equal(testKey.Public()) || in.Identity == nil || in.Identity.ID != "id1" {
+			http.Error(w, "unexpected callback", http.StatusBadRequest)
+			return
+		}
+		if strings.HasSuffix(r.URL.Path, shared.ConnectorConnectAPIPath) {
+			atomic.AddInt32(&connects, 1)
+			return
+		}
+		if atomic.AddInt32(&calls, 1) < 3 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer srv.Close()
+
+	store := &memRegistrationStore{}
+	cb := newTestCallback(t, srv.URL, store)
+	if err := cb.Start(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if n := atomic.LoadInt32(&calls); n != 3 {
+		t.Errorf("got %d callback requests, expected 3", n)
+	}
+	if err := cb.ReadyzCheck(nil); err != nil {
+		t.Errorf("expected registered callback, got %v", err)
+	}
+	if store.reg == nil || store.reg.CallbackRequest != cb.req {
+		t.Errorf("registration not saved: %+v", store.reg)
+	}
+
+	if n := atomic.LoadInt32(&connects); n != 0 {
+		t.Errorf("got %d connect requests, expected none", n)
+	}
+
+	// restarted connector does not register again, but presents its identity
+	cb = newTestCallback(t, srv.URL, store)
+	if err := cb.Start(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if n := atomic.LoadInt32(&calls); n != 3 {
+		t.Errorf("got %d callback requests after restart, expected 3", n)
+	}
+	if n := atomic.LoadInt32(&connects); n != 1 {
+		t.Errorf("got %d connect requests after restart, expected 1", n)
+	}
+	if err := cb.ReadyzCheck(nil); err != nil {
+		t.Errorf("expected registered callback after restart, got %v", err)
+	}
+}
+
+func TestCallbackRenewsNatsJWT(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token := "callback-jwt"
+		if strings.HasSuffix(r.URL.Path, shared.ConnectorConnectAPIPath) {
+			token = "connect-jwt"
+		}
+		_ = json.NewEncoder(w).Encode(shared.CallbackResponse{NatsJWT: token})
+	}))
+	defer srv.Close()
+
+	store := &memRegistrationStore{}
+	cb := newTestCallback(t, srv.URL, store)
+	cb.natsJWT = &renewedJWT{}
+	if err := cb.Start(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if got := cb.natsJWT.Get(); got != "callback-jwt" {
+		t.Errorf("got NATS JWT %q, expected callback-jwt", got)
+	}
+	if store.reg == nil || store.reg.NatsJWT != "callback-jwt" {
+		t.Errorf("renewed NATS JWT not saved: %+v", store.reg)
+	}
+
+	// restarted connector connects with the saved JWT, later renewals do not reload the connection
+	cb = newTestCallback(t, srv.URL, store)
+	cb.natsJWT = &renewedJWT{}
+	cb.restoreNatsJWT(context.Background())
+	if got := cb.natsJWT.Get(); got != "callback-jwt" {
+		t.Errorf("got restored NATS JWT %q, expected callback-jwt", got)
+	}
+	if err := cb.Start(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if got := cb.natsJWT.Get(); got != "callback-jwt" {
+		t.Errorf("got NATS JWT %q after connect, expected callback-jwt", got)
+	}
+
+	// a connector whose registration lost the JWT takes the one renewed on connect
+	store.reg.NatsJWT = ""
+	cb = newTestCallback(t, srv.URL, store)
+	cb.natsJWT = &renewedJWT{}
+	if err := cb.Start(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if got := cb.natsJWT.Get(); got != "connect-jwt" || store.reg.NatsJWT != "connect-jwt" {
+		t.Errorf("got NATS JWT %q and saved %q after connect, expected connect-jwt", got, store.reg.NatsJWT)
+	}
+}
+
+func TestCallbackRejected(t *testing.T) {
+	var calls int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		http.Error(w, "unknown link", http.StatusNotFound)
+	}))
+	defer srv.Close()
+
+	cb := newTestCallback(t, srv.URL, nil)
+	if err := cb.Start(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if n := atomic.LoadInt32(&calls); n != 1 {
+		t.Errorf("got %d callback requests, expected 1", n)
+	}
+	if cb.state != callbackStateRejected || cb.ReadyzCheck(nil) == nil {
+		t.Errorf("expected rejected callback, got state %s", cb.state)
+	}
+}
+
+func TestCallbackConnectConflict(t *testing.T) {
+	var (
+		cb       *callback
+		calls    int32
+		unready  int32
+		conflict = int32(3)
+	)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := atomic.AddInt32(&calls, 1)
+		if n > 1 && cb.ReadyzCheck(nil) != nil {
+			atomic.AddInt32(&unready, 1)
+		}
+		if n <= conflict {
+			http.Error(w, "connector identity was cloned into another cluster", http.StatusConflict)
+		}
+	}))
+	defer srv.Close()
+
+	store := &memRegistrationStore{}
+	cb = newTestCallback(t, srv.URL, store)
+	if err := store.Save(context.Background(), registration{CallbackRequest: cb.req}); err != nil {
+		t.Fatal(err)
+	}
+	if err := cb.Start(context.Background()); err != nil {
+		t.Errorf("expected a cloned connector to keep running, got %v", err)
+	}
+	if n := atomic.LoadInt32(&calls); n != conflict+1 {
+		t.Errorf("got %d connect requests, expected %d", n, conflict+1)
+	}
+	// readiness failed after each conflict
+	if n := atomic.LoadInt32(&unready); n != conflict {
+		t.Errorf("connector was unready for %d connect requests, expected %d", n, conflict)
+	}
+	if err := cb.ReadyzCheck(nil); err != nil {
+		t.Errorf("expected registered callback once the hub accepted the identity, got %v", err)
+	}
+}
+
+func TestCallbackConnectUnsupported(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.NotFound(w, r)
+	}))
+	defer srv.Close()
+
+	store := &memRegistrationStore{}
+	cb := newTestCallback(t, srv.URL, store)
+	cb.opts.ConnectInterval = time.Millisecond
+	if err := store.Save(context.Background(), registration{CallbackRequest: cb.req}); err != nil {
+		t.Fatal(err)
+	}
+	if err := cb.Start(context.Background()); err != nil {
+		t.Errorf("expected hubs without connect endpoint to be ignored, got %v", err)
+	}
+	if err := cb.ReadyzCheck(nil); err != nil {
+		t.Errorf("expected registered callback, got %v", err)
+	}
+}
+
+func TestCallbackStopsOnShutdown(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer srv.Close()
+
+	cb := newTestCallback(t, srv.URL, nil)
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := cb.Start(ctx); err != nil {
+		t.Errorf("expected failing callback not to stop the manager, got %v", err)
+	}
+}
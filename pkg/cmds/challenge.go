@@ -0,0 +1,75 @@
+/*
+Copyright AppsCode Inc. and Contributors
+
+Licensed under the AppsCode Community License 1.0.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://github.com/appscode/licenses/raw/1.0.0/AppsCode-Community-1.0.0.md
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmds
+
+import (
+	"crypto/ed25519"
+	"encoding/json"
+
+	"kubeops.dev/cluster-connector/pkg/shared"
+
+	"github.com/nats-io/nats.go"
+	"k8s.io/klog/v2"
+)
+
+// challengeResponder answers the ownership challenges the hub sends before it binds the link
+// to this cluster, see shared.HeaderKeyChallenge.
+type challengeResponder struct {
+	linkID string
+	key    ed25519.PrivateKey
+	// clusterID reads the cluster id from the api server.
+	clusterID func() (string, error)
+}
+
+// intercept answers challenges received on the proxy handler subject and passes proxy requests to next.
+func (r *challengeResponder) intercept(nc *nats.Conn, next nats.MsgHandler) nats.MsgHandler {
+	return func(msg *nats.Msg) {
+		nonce := msg.Header.Get(shared.HeaderKeyChallenge)
+		if nonce == "" {
+			next(msg)
+			return
+		}
+		// reading the cluster id must not block the subscription
+		go r.respond(nc, msg.Reply, nonce)
+	}
+}
+
+func (r *challengeResponder) respond(nc *nats.Conn, reply, nonce string) {
+	data, err := json.Marshal(r.answer(nonce))
+	if err != nil {
+		klog.ErrorS(err, "failed to encode challenge response")
+		return
+	}
+	if err := nc.Publish(reply, data); err != nil {
+		klog.ErrorS(err, "failed to answer challenge")
+	}
+}
+
+func (r *challengeResponder) answer(nonce string) shared.ChallengeResponse {
+	resp := shared.ChallengeResponse{
+		LinkID: r.linkID,
+		Nonce:  nonce,
+	}
+	cid, err := r.clusterID()
+	if err != nil {
+		resp.Error = err.Error()
+		return resp
+	}
+	resp.ClusterID = cid
+	shared.SignChallenge(&resp, r.key)
+	return resp
+}
@@ -0,0 +1,60 @@
+/*
+Copyright AppsCode Inc. and Contributors
+
+Licensed under the AppsCode Community License 1.0.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://github.com/appscode/licenses/raw/1.0.0/AppsCode-Community-1.0.0.md
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmds
+
+import (
+	"crypto/ed25519"
+	"errors"
+	"testing"
+
+	"kubeops.dev/cluster-connector/pkg/shared"
+
+	"github.com/nats-io/nats.go"
+)
+
+func TestChallengeResponder(t *testing.T) {
+	clusterID, clusterErr := "cluster1", error(nil)
+	r := &challengeResponder{
+		linkID: "link1",
+		key:    testKey,
+		clusterID: func() (string, error) {
+			return clusterID, clusterErr
+		},
+	}
+	pub := testKey.Public().(ed25519.PublicKey)
+
+	resp := r.answer("nonce1")
+	if err := shared.VerifyChallenge(resp, pub, "link1", "cluster1", "nonce1"); err != nil {
+		t.Errorf("expected valid challenge response, got %v", err)
+	}
+
+	clusterErr = errors.New("forbidden")
+	resp = r.answer("nonce2")
+	if err := shared.VerifyChallenge(resp, pub, "link1", "cluster1", "nonce2"); err == nil || resp.Signature != "" {
+		t.Errorf("expected unsigned failed challenge response, got %+v", resp)
+	}
+
+	// proxy requests are passed on
+	var proxied int
+	handler := r.intercept(nil, func(msg *nats.Msg) {
+		proxied++
+	})
+	handler(&nats.Msg{Subject: "k8s.proxy.handler"})
+	if proxied != 1 {
+		t.Errorf("got %d proxied requests, expected 1", proxied)
+	}
+}
@@ -0,0 +1,60 @@
+/*
+Copyright AppsCode Inc. and Contributors
+
+Licensed under the AppsCode Community License 1.0.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://github.com/appscode/licenses/raw/1.0.0/AppsCode-Community-1.0.0.md
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmds
+
+import (
+	"kubeops.dev/cluster-connector/pkg/shared"
+
+	"k8s.io/client-go/discovery"
+	clustermeta "kmodules.xyz/client-go/cluster"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+)
+
+// clusterInfo returns the metadata of the cluster reported to the hub. Metadata that can not be
+// detected is left empty, since it is informational only.
+func clusterInfo(mgr manager.Manager) *shared.ClusterInfo {
+	info := &shared.ClusterInfo{
+		Name: clustermeta.ClusterName(),
+	}
+	if md, err := clustermeta.ClusterMetadata(mgr.GetAPIReader()); err != nil {
+		setupLog.Error(err, "failed to detect cluster metadata")
+	} else {
+		if info.Name == "" {
+			info.Name = md.Name
+		}
+		info.DisplayName = md.DisplayName
+		info.Provider = string(md.Provider)
+	}
+	if ver, err := kubernetesVersion(mgr); err != nil {
+		setupLog.Error(err, "failed to detect kubernetes version")
+	} else {
+		info.KubernetesVersion = ver
+	}
+	return info
+}
+
+func kubernetesVersion(mgr manager.Manager) (string, error) {
+	dc, err := discovery.NewDiscoveryClientForConfig(mgr.GetConfig())
+	if err != nil {
+		return "", err
+	}
+	ver, err := dc.ServerVersion()
+	if err != nil {
+		return "", err
+	}
+	return ver.GitVersion, nil
+}
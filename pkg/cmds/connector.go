@@ -0,0 +1,279 @@
+/*
+Copyright AppsCode Inc. and Contributors
+
+Licensed under the AppsCode Community License 1.0.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://github.com/appscode/licenses/raw/1.0.0/AppsCode-Community-1.0.0.md
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmds
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"kubeops.dev/cluster-connector/pkg/shared"
+	"kubeops.dev/cluster-connector/pkg/transport"
+
+	"github.com/nats-io/nats.go"
+	"k8s.io/klog/v2"
+)
+
+// connector owns the NATS connection of the edge and the proxy handler subscriptions.
+// The connection is established in the background, so that a slow network at pod start
+// keeps the connector unready instead of crash looping it.
+//
+// If the credential files change, eg. because the mounted Secret was rotated, the connector
+// connects again with the new credentials and subscribes the proxy handlers on the new
+// connection before it stops receiving requests on the old one. Requests received on the
+// old connection are answered on it, so in-flight streams are not dropped.
+type connector struct {
+	opts    *transport.ConnectionOptions
+	names   shared.SubjectNames
+	workers *workerOptions
+	// challenge answers ownership challenges of the hub, if set.
+	challenge *challengeResponder
+	// disconnectGracePeriod is how long the connection may stay disconnected before the liveness probe fails.
+	disconnectGracePeriod time.Duration
+
+	mu                sync.RWMutex
+	nc                *nats.Conn
+	subs              []*nats.Subscription
+	connected         chan struct{}
+	disconnectedSince time.Time
+}
+
+// connection is a NATS connection with the proxy handlers subscribed to it.
+type connection struct {
+	nc   *nats.Conn
+	subs []*nats.Subscription
+	d    *dispatcher
+}
+
+func newConnector(opts *transport.ConnectionOptions, names shared.SubjectNames, workers *workerOptions, disconnectGracePeriod time.Duration) *connector {
+	return &connector{
+		opts:                  opts,
+		names:                 names,
+		workers:               workers,
+		disconnectGracePeriod: disconnectGracePeriod,
+		connected:             make(chan struct{}),
+	}
+}
+
+func (c *connector) Start(ctx context.Context) error {
+	checksum, err := c.opts.CredentialsChecksum()
+	if err != nil {
+		klog.ErrorS(err, "failed to read nats credentials")
+	}
+	cn, err := c.connect(ctx)
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil
+		}
+		return err
+	}
+	c.setConnection(cn)
+	close(c.connected)
+
+	var (
+		reload  <-chan time.Time
+		retired sync.WaitGroup
+	)
+	if c.opts.CredentialReloadInterval > 0 {
+		ticker := time.NewTicker(c.opts.CredentialReloadInterval)
+		defer ticker.Stop()
+		reload = ticker.C
+	}
+	// old connections are closed early if the connector shuts down
+	retireCtx, cancelRetire := context.WithCancel(context.Background())
+	defer cancelRetire()
+
+	for {
+		select {
+		case <-ctx.Done():
+			stopCtx, cancel := context.WithTimeout(context.Background(), nats.DefaultDrainTimeout)
+			defer cancel()
+			err := cn.close(stopCtx)
+			cancelRetire()
+			retired.Wait()
+			return err
+		case <-reload:
+			next, err := c.opts.CredentialsChecksum()
+			if err != nil {
+				klog.ErrorS(err, "failed to read nats credentials")
+				continue
+			}
+			if next == checksum {
+				continue
+			}
+
+			klog.InfoS("nats credentials changed, reconnecting")
+			// the initial connection may retry until shutdown, a reload must give up
+			// before the next tick, so that bad credentials do not block the loop
+			connectCtx, cancel := context.WithTimeout(ctx, c.opts.CredentialReloadInterval)
+			reloaded, err := c.connect(connectCtx)
+			cancel()
+			if err != nil {
+				if ctx.Err() != nil {
+					continue
+				}
+				// the old connection keeps serving, the reload is retried on the next tick
+				klog.ErrorS(err, "failed to connect to nats with the new credentials")
+				continue
+			}
+			checksum = next
+			old := cn
+			cn = reloaded
+			c.setConnection(cn)
+			credentialReloads.Inc()
+
+			retired.Add(1)
+			go func() {
+				defer retired.Done()
+				drainCtx, cancel := context.WithTimeout(retireCtx, c.opts.CredentialReloadDrainTimeout)
+				defer cancel()
+				if err := old.close(drainCtx); err != nil {
+					klog.ErrorS(err, "failed to close nats connection with the old credentials")
+				}
+			}()
+		}
+	}
+}
+
+// connect connects to NATS and subscribes the proxy handlers.
+func (c *connector) connect(ctx context.Context) (*connection, error) {
+	nc, err := c.opts.Connect(ctx)
+	if err != nil {
+		return nil, err
+	}
+	klog.InfoS("connected to nats", "url", nc.ConnectedUrl())
+
+	// hubs publish to the priority lanes if enabled, the handler subject is subscribed for the others
+	d := newDispatcher(nc, c.workers)
+	_, edgeSub := c.names.ProxyHandlerSubjects()
+	dispatch := nats.MsgHandler(d.Dispatch)
+	if c.challenge != nil {
+		// the hub challenges the connector on the handler subject, never on the lanes
+		dispatch = c.challenge.intercept(nc, dispatch)
+	}
+	handlers := map[string]nats.MsgHandler{
+		edgeSub: dispatch,
+	}
+	for _, lane := range shared.Lanes {
+		handlers[shared.LaneSubject(edgeSub, lane)] = d.DispatchLane(lane)
+	}
+	subs := make([]*nats.Subscription, 0, len(handlers))
+	for subject, cb := range handlers {
+		sub, err := subscribe(nc, subject, cb)
+		if err != nil {
+			nc.Close()
+			return nil, err
+		}
+		subs = append(subs, sub)
+	}
+	return &connection{nc: nc, subs: subs, d: d}, nil
+}
+
+func (c *connector) setConnection(cn *connection) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.nc = cn.nc
+	c.subs = cn.subs
+	c.disconnectedSince = time.Time{}
+}
+
+// close stops receiving requests, then lets the workers finish before closing the connection.
+func (cn *connection) close(ctx context.Context) error {
+	for _, sub := range cn.subs {
+		if err := sub.Unsubscribe(); err != nil {
+			klog.ErrorS(err, "failed to unsubscribe proxy handler", "subject", sub.Subject)
+		}
+	}
+	if err := cn.d.Stop(ctx); err != nil {
+		klog.ErrorS(err, "failed to stop workers")
+	}
+	return cn.nc.Drain()
+}
+
+// Connected returns a channel that is closed once the proxy handlers are subscribed.
+func (c *connector) Connected() <-chan struct{} {
+	return c.connected
+}
+
+func (c *connector) Conn() *nats.Conn {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.nc
+}
+
+// ReadyzCheck fails while the NATS connection is not established.
+func (c *connector) ReadyzCheck(_ *http.Request) error {
+	nc := c.Conn()
+	if nc == nil {
+		return errors.New("not connected to nats yet")
+	}
+	if !nc.IsConnected() {
+		return errors.New("nats connection status: " + nc.Status().String())
+	}
+	return nil
+}
+
+// SubscriptionsCheck fails unless all proxy handler subscriptions are active.
+func (c *connector) SubscriptionsCheck(_ *http.Request) error {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	if c.nc == nil {
+		return errors.New("proxy handlers are not subscribed yet")
+	}
+	invalid := 0
+	for _, sub := range c.subs {
+		if !sub.IsValid() {
+			invalid++
+		}
+	}
+	if invalid > 0 {
+		return fmt.Errorf("%d of %d proxy handler subscriptions are invalid", invalid, len(c.subs))
+	}
+	return nil
+}
+
+// LivezCheck fails if the NATS connection is closed or has been disconnected for longer than the grace period.
+// The client reconnects on its own, so a short disconnect is not a reason to restart the connector.
+// Failing to establish the initial connection is handled by Start.
+func (c *connector) LivezCheck(_ *http.Request) error {
+	return c.livez(time.Now())
+}
+
+func (c *connector) livez(now time.Time) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.nc == nil {
+		return nil
+	}
+	if c.nc.IsClosed() {
+		return errors.New("nats connection is closed")
+	}
+	if c.nc.IsConnected() {
+		c.disconnectedSince = time.Time{}
+		return nil
+	}
+	if c.disconnectedSince.IsZero() {
+		c.disconnectedSince = now
+	}
+	if d := now.Sub(c.disconnectedSince); c.disconnectGracePeriod > 0 && d > c.disconnectGracePeriod {
+		return fmt.Errorf("nats connection status is %s for %s", c.nc.Status(), d.Round(time.Second))
+	}
+	return nil
+}
@@ -0,0 +1,313 @@
+/*
+Copyright AppsCode Inc. and Contributors
+
+Licensed under the AppsCode Community License 1.0.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://github.com/appscode/licenses/raw/1.0.0/AppsCode-Community-1.0.0.md
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmds
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"kubeops.dev/cluster-connector/pkg/shared"
+	"kubeops.dev/cluster-connector/pkg/transport"
+
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nkeys"
+)
+
+// fakeBroker routes messages between its clients. It speaks enough of the NATS
+// protocol for the connector and a hub in the same account.
+type fakeBroker struct {
+	ln net.Listener
+	// reject closes new connections before the handshake, while set.
+	reject atomic.Bool
+
+	mu   sync.Mutex
+	subs map[*brokerConn]map[string]string // sid -> subject
+}
+
+type brokerConn struct {
+	mu sync.Mutex
+	c  net.Conn
+}
+
+func (bc *brokerConn) write(b []byte) {
+	bc.mu.Lock()
+	defer bc.mu.Unlock()
+	_, _ = bc.c.Write(b)
+}
+
+func newFakeBroker(t *testing.T) *fakeBroker {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := &fakeBroker{ln: ln, subs: map[*brokerConn]map[string]string{}}
+	t.Cleanup(func() { _ = ln.Close() })
+	go func() {
+		for {
+			c, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			if b.reject.Load() {
+				_ = c.Close()
+				continue
+			}
+			go b.serve(&brokerConn{c: c})
+		}
+	}()
+	return b
+}
+
+func (b *fakeBroker) addr() string {
+	return "nats://" + b.ln.Addr().String()
+}
+
+func (b *fakeBroker) serve(bc *brokerConn) {
+	b.mu.Lock()
+	b.subs[bc] = map[string]string{}
+	b.mu.Unlock()
+	defer func() {
+		b.mu.Lock()
+		delete(b.subs, bc)
+		b.mu.Unlock()
+		_ = bc.c.Close()
+	}()
+
+	bc.write([]byte("INFO {\"server_id\":\"fake\",\"version\":\"2.10.0\",\"proto\":1,\"headers\":true,\"max_payload\":1048576}\r\n"))
+	r := bufio.NewReader(bc.c)
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		args := strings.Fields(line)
+		if len(args) == 0 {
+			continue
+		}
+		switch strings.ToUpper(args[0]) {
+		case "PING":
+			bc.write([]byte("PONG\r\n"))
+		case "SUB":
+			// SUB <subject> [queue group] <sid>
+			b.mu.Lock()
+			b.subs[bc][args[len(args)-1]] = args[1]
+			b.mu.Unlock()
+		case "UNSUB":
+			b.mu.Lock()
+			delete(b.subs[bc], args[1])
+			b.mu.Unlock()
+		case "PUB", "HPUB":
+			// PUB <subject> [reply] <size>, HPUB <subject> [reply] <header size> <total size>
+			hpub := strings.ToUpper(args[0]) == "HPUB"
+			sizes := 1
+			if hpub {
+				sizes = 2
+			}
+			var reply string
+			if len(args) == 2+sizes+1 {
+				reply = args[2]
+			}
+			total, err := strconv.Atoi(args[len(args)-1])
+			if err != nil {
+				return
+			}
+			data := make([]byte, total+2)
+			if _, err := io.ReadFull(r, data); err != nil {
+				return
+			}
+			hdr := ""
+			if hpub {
+				hdr = args[len(args)-2]
+			}
+			b.route(args[1], reply, hdr, data)
+		}
+	}
+}
+
+func (b *fakeBroker) route(subject, reply, hdr string, data []byte) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for bc, subs := range b.subs {
+		for sid, pattern := range subs {
+			if !subjectMatches(pattern, subject) {
+				continue
+			}
+			args := []string{subject, sid}
+			if reply != "" {
+				args = append(args, reply)
+			}
+			op := "MSG"
+			if hdr != "" {
+				op = "HMSG"
+				args = append(args, hdr)
+			}
+			args = append(args, strconv.Itoa(len(data)-2))
+			bc.write(append([]byte(op+" "+strings.Join(args, " ")+"\r\n"), data...))
+		}
+	}
+}
+
+func subjectMatches(pattern, subject string) bool {
+	pt, st := strings.Split(pattern, "."), strings.Split(subject, ".")
+	for i, p := range pt {
+		if p == ">" {
+			return len(st) > i
+		}
+		if i >= len(st) || (p != "*" && p != st[i]) {
+			return false
+		}
+	}
+	return len(pt) == len(st)
+}
+
+// writeCreds writes a NATS credential file. The broker does not verify it,
+// a different jwt only changes the checksum of the file.
+func writeCreds(t *testing.T, filename, jwt string) {
+	t.Helper()
+	kp, err := nkeys.CreateUser()
+	if err != nil {
+		t.Fatal(err)
+	}
+	seed, err := kp.Seed()
+	if err != nil {
+		t.Fatal(err)
+	}
+	creds := fmt.Sprintf("-----BEGIN NATS USER JWT-----\n%s\n------END NATS USER JWT------\n\n-----BEGIN USER NKEY SEED-----\n%s\n------END USER NKEY SEED------\n", jwt, seed)
+	if err := os.WriteFile(filename, []byte(creds), 0o600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func startTestConnector(t *testing.T, broker *fakeBroker, names shared.SubjectNames) (*connector, string) {
+	t.Helper()
+	opts := transport.NewConnectionOptions()
+	opts.Addr = broker.addr()
+	opts.CredFile = filepath.Join(t.TempDir(), "user.creds")
+	opts.ConnectRetryInterval = 10 * time.Millisecond
+	opts.ConnectRetryMaxInterval = 10 * time.Millisecond
+	opts.CredentialReloadInterval = 50 * time.Millisecond
+	opts.CredentialReloadDrainTimeout = 5 * time.Second
+	writeCreds(t, opts.CredFile, "jwt.v1.0")
+
+	c := newConnector(opts, names, newWorkerOptions(), time.Minute)
+	ctx, cancel := context.WithCancel(context.Background())
+	stopped := make(chan error)
+	go func() {
+		stopped <- c.Start(ctx)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		if err := <-stopped; err != nil {
+			t.Errorf("connector stopped with error: %v", err)
+		}
+	})
+	select {
+	case <-c.Connected():
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for the connector to connect")
+	}
+	return c, opts.CredFile
+}
+
+func TestConnectorReloadWithRequestInFlight(t *testing.T) {
+	started := make(chan struct{})
+	release := make(chan struct{})
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-release
+		_, _ = io.WriteString(w, "ok")
+	}))
+	defer upstream.Close()
+
+	broker := newFakeBroker(t)
+	names := shared.SameAccountNames{LinkID: "test"}
+	c, credFile := startTestConnector(t, broker, names)
+	old := c.Conn()
+
+	hub, err := nats.Connect(broker.addr())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer hub.Close()
+	client := &http.Client{Transport: &transport.NatsTransport{Conn: hub, Names: names, Timeout: 10 * time.Second}}
+
+	type result struct {
+		body string
+		err  error
+	}
+	done := make(chan result, 1)
+	go func() {
+		resp, err := client.Get(upstream.URL)
+		if err != nil {
+			done <- result{err: err}
+			return
+		}
+		defer resp.Body.Close() // nolint:errcheck
+		body, err := io.ReadAll(resp.Body)
+		done <- result{body: string(body), err: err}
+	}()
+	<-started
+
+	writeCreds(t, credFile, "jwt.v2.0")
+	waitFor(t, func() bool { return c.Conn() != old })
+	if old.IsClosed() {
+		t.Error("old connection was closed with a request in flight")
+	}
+
+	close(release)
+	res := <-done
+	if res.err != nil {
+		t.Fatalf("request failed: %v", res.err)
+	}
+	if res.body != "ok" {
+		t.Errorf("got body %q, expected %q", res.body, "ok")
+	}
+	waitFor(t, old.IsClosed)
+}
+
+func TestConnectorReloadRetry(t *testing.T) {
+	broker := newFakeBroker(t)
+	c, credFile := startTestConnector(t, broker, shared.SameAccountNames{LinkID: "test"})
+	old := c.Conn()
+
+	// the reload attempts fail until the broker accepts connections again
+	broker.reject.Store(true)
+	writeCreds(t, credFile, "jwt.v2.0")
+	time.Sleep(300 * time.Millisecond)
+	if c.Conn() != old {
+		t.Fatal("connection was replaced although the reload failed")
+	}
+	if err := c.ReadyzCheck(nil); err != nil {
+		t.Errorf("old connection stopped serving: %v", err)
+	}
+
+	broker.reject.Store(false)
+	waitFor(t, func() bool { return c.Conn() != old })
+}
@@ -0,0 +1,82 @@
+/*
+Copyright AppsCode Inc. and Contributors
+
+Licensed under the AppsCode Community License 1.0.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://github.com/appscode/licenses/raw/1.0.0/AppsCode-Community-1.0.0.md
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmds
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	"k8s.io/client-go/discovery"
+	"k8s.io/client-go/rest"
+	"sigs.k8s.io/controller-runtime/pkg/healthz"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+)
+
+const apiserverCheckTimeout = 5 * time.Second
+
+// addHealthChecks registers the probes of the connector. Each check is served individually
+// under /healthz/<name> and /readyz/<name>, and all of them are listed by ?verbose.
+//
+// Liveness only fails for problems a restart can fix, ie. a closed or long disconnected NATS connection.
+// Readiness additionally requires active subscriptions, a reachable API server and a successful link callback.
+func addHealthChecks(mgr manager.Manager, conn *connector, cb *callback) error {
+	apiserver, err := apiserverCheck(mgr.GetConfig())
+	if err != nil {
+		return err
+	}
+
+	livez := map[string]healthz.Checker{
+		"ping": healthz.Ping,
+		"nats": conn.LivezCheck,
+	}
+	for name, check := range livez {
+		if err := mgr.AddHealthzCheck(name, check); err != nil {
+			return err
+		}
+	}
+
+	readyz := map[string]healthz.Checker{
+		"nats":          conn.ReadyzCheck,
+		"subscriptions": conn.SubscriptionsCheck,
+		"apiserver":     apiserver,
+		"callback":      cb.ReadyzCheck,
+	}
+	for name, check := range readyz {
+		if err := mgr.AddReadyzCheck(name, check); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// apiserverCheck returns a checker that fails if the /readyz endpoint of the API server can not be reached.
+func apiserverCheck(cfg *rest.Config) (healthz.Checker, error) {
+	dc, err := discovery.NewDiscoveryClientForConfig(cfg)
+	if err != nil {
+		return nil, err
+	}
+	return func(req *http.Request) error {
+		ctx, cancel := context.WithTimeout(req.Context(), apiserverCheckTimeout)
+		defer cancel()
+		if err := dc.RESTClient().Get().AbsPath("/readyz").Do(ctx).Error(); err != nil {
+			return fmt.Errorf("api server is not reachable: %w", err)
+		}
+		return nil
+	}, nil
+}
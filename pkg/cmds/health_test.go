@@ -0,0 +1,83 @@
+/*
+Copyright AppsCode Inc. and Contributors
+
+Licensed under the AppsCode Community License 1.0.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://github.com/appscode/licenses/raw/1.0.0/AppsCode-Community-1.0.0.md
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmds
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"k8s.io/client-go/rest"
+)
+
+func TestAPIServerCheck(t *testing.T) {
+	healthy := true
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/readyz" {
+			t.Errorf("unexpected path %s", r.URL.Path)
+		}
+		if !healthy {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+		_, _ = w.Write([]byte("ok"))
+	}))
+	defer srv.Close()
+
+	check, err := apiserverCheck(&rest.Config{Host: srv.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	req := httptest.NewRequest(http.MethodGet, "/readyz/apiserver", nil)
+	if err := check(req); err != nil {
+		t.Errorf("expected healthy api server, got %v", err)
+	}
+	healthy = false
+	if err := check(req); err == nil {
+		t.Error("expected unhealthy api server to fail the check")
+	}
+}
+
+func TestCallbackReadyzCheck(t *testing.T) {
+	cb := &callback{}
+	if err := cb.ReadyzCheck(nil); err == nil {
+		t.Error("expected pending callback to fail the check")
+	}
+	cb.setResult(errors.New("connection refused"))
+	if err := cb.ReadyzCheck(nil); err == nil {
+		t.Error("expected failed callback to fail the check")
+	}
+	cb.setResult(nil)
+	if err := cb.ReadyzCheck(nil); err != nil {
+		t.Errorf("expected successful callback to pass the check, got %v", err)
+	}
+}
+
+func TestConnectorChecksBeforeConnect(t *testing.T) {
+	c := newConnector(nil, nil, newWorkerOptions(), time.Minute)
+	if err := c.ReadyzCheck(nil); err == nil {
+		t.Error("expected readyz to fail before connecting")
+	}
+	if err := c.SubscriptionsCheck(nil); err == nil {
+		t.Error("expected subscriptions check to fail before connecting")
+	}
+	// connection is retried by Start, so liveness must not fail while connecting
+	if err := c.livez(time.Now()); err != nil {
+		t.Errorf("expected livez to pass before connecting, got %v", err)
+	}
+}
@@ -0,0 +1,372 @@
+/*
+Copyright AppsCode Inc. and Contributors
+
+Licensed under the AppsCode Community License 1.0.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://github.com/appscode/licenses/raw/1.0.0/AppsCode-Community-1.0.0.md
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmds
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"kubeops.dev/cluster-connector/pkg/hub"
+	"kubeops.dev/cluster-connector/pkg/inventory"
+	"kubeops.dev/cluster-connector/pkg/link"
+	"kubeops.dev/cluster-connector/pkg/shared"
+	"kubeops.dev/cluster-connector/pkg/transport"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+	"gomodules.xyz/blobfs"
+	v "gomodules.xyz/x/version"
+	"k8s.io/klog/v2"
+	"k8s.io/klog/v2/klogr"
+	"kubepack.dev/lib-helm/pkg/repo"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/yaml"
+)
+
+type hubOptions struct {
+	Addr            string
+	TLSCertFile     string
+	TLSKeyFile      string
+	ShutdownTimeout time.Duration
+
+	LinkLifetime time.Duration
+	LinkKeyFile  string
+	StoreURL     string
+	StorePrefix  string
+	StaleAfter   time.Duration
+
+	TenantsFile             string
+	NatsHealthCheckInterval time.Duration
+	NatsIdleTimeout         time.Duration
+}
+
+func newHubOptions() *hubOptions {
+	return &hubOptions{
+		Addr:            ":8443",
+		ShutdownTimeout: 30 * time.Second,
+		LinkLifetime:    shared.ConnectorLinkLifetime,
+		StorePrefix:     "cluster-connector",
+		StaleAfter:      hub.DefaultStaleAfter,
+
+		NatsHealthCheckInterval: 30 * time.Second,
+		NatsIdleTimeout:         time.Hour,
+	}
+}
+
+func (o *hubOptions) AddFlags(fs *pflag.FlagSet) {
+	fs.StringVar(&o.Addr, "address", o.Addr, "The address the hub APIs are served on")
+	fs.StringVar(&o.TLSCertFile, "tls-cert-file", o.TLSCertFile, "PEM encoded certificate the hub APIs are served with. If empty, the APIs are served over plain HTTP, eg. behind a TLS terminating load balancer.")
+	fs.StringVar(&o.TLSKeyFile, "tls-key-file", o.TLSKeyFile, "PEM encoded private key of --tls-cert-file")
+	fs.DurationVar(&o.ShutdownTimeout, "shutdown-timeout", o.ShutdownTimeout, "How long in-flight requests may run after a shutdown signal before they are aborted")
+	fs.DurationVar(&o.LinkLifetime, "link-lifetime", o.LinkLifetime, "Duration a generated link can be used to connect a cluster")
+	fs.StringVar(&o.LinkKeyFile, "link-signing-key-file", o.LinkKeyFile, "Path to the key used to sign link tokens. Required with --store-url. If empty, a random key is used and links do not survive a restart.")
+	fs.StringVar(&o.StoreURL, "store-url", o.StoreURL, "Blob storage url links and revoked NATS users are stored in, eg. gs://bucket or file:///var/lib/hub. If empty, they are kept in memory and lost on restart.")
+	fs.StringVar(&o.StorePrefix, "store-prefix", o.StorePrefix, "Prefix of the objects stored in --store-url")
+	fs.DurationVar(&o.StaleAfter, "connector-stale-after", o.StaleAfter, "How long a bound connector may not present its identity before its cluster is offline and another cluster may claim its link. Must exceed the --connect-interval of the connectors.")
+	fs.StringVar(&o.TenantsFile, "tenants-file", o.TenantsFile, "Path to a YAML file mapping tenants to the NATS accounts of their clusters. If empty, all tenants use the NATS account of the hub. Can not be used with --nats-operator-seed-file.")
+	fs.DurationVar(&o.NatsHealthCheckInterval, "nats-health-check-interval", o.NatsHealthCheckInterval, "Interval between health checks of the NATS connections of the tenant accounts")
+	fs.DurationVar(&o.NatsIdleTimeout, "nats-idle-timeout", o.NatsIdleTimeout, "How long the NATS connection of a tenant account may not be used before it is closed. Zero keeps idle connections open.")
+}
+
+func (o *hubOptions) Validate() error {
+	var errs []error
+	if o.Addr == "" {
+		errs = append(errs, errors.New("--address is required"))
+	}
+	if (o.TLSCertFile == "") != (o.TLSKeyFile == "") {
+		errs = append(errs, errors.New("--tls-cert-file and --tls-key-file must be set together"))
+	}
+	if o.ShutdownTimeout < 0 {
+		errs = append(errs, errors.New("--shutdown-timeout must not be negative"))
+	}
+	if o.LinkLifetime <= 0 {
+		errs = append(errs, errors.New("--link-lifetime must be positive"))
+	}
+	if o.StoreURL != "" && o.LinkKeyFile == "" {
+		// links in the store would fail verification after a restart and on other replicas
+		errs = append(errs, errors.New("--link-signing-key-file is required with --store-url"))
+	}
+	if o.StaleAfter <= 0 {
+		errs = append(errs, errors.New("--connector-stale-after must be positive"))
+	}
+	if o.NatsHealthCheckInterval <= 0 {
+		errs = append(errs, errors.New("--nats-health-check-interval must be positive"))
+	}
+	if o.NatsIdleTimeout < 0 {
+		errs = append(errs, errors.New("--nats-idle-timeout must not be negative"))
+	}
+	return errors.Join(errs...)
+}
+
+func NewCmdHub() *cobra.Command {
+	var (
+		configFile      string
+		hubOpts         = newHubOptions()
+		authOpts        = hub.NewAuthOptions()
+		provisionerOpts = hub.ProvisionerOptions{}
+		subjectOpts     = shared.NewSubjectOptions()
+		natsOpts        = transport.NewConnectionOptions()
+	)
+	cmd := &cobra.Command{
+		Use:               "hub",
+		Short:             "Serve the link, connector callback and cluster proxy APIs of the hub",
+		DisableAutoGenTag: true,
+		Run: func(cmd *cobra.Command, args []string) {
+			klog.Infof("Starting binary version %s+%s ...", v.Version.Version, v.Version.CommitHash)
+
+			if configFile != "" {
+				if err := loadConfigFile(cmd.Flags(), configFile); err != nil {
+					setupLog.Error(err, "invalid config file", "file", configFile)
+					os.Exit(1)
+				}
+			}
+			if err := natsOpts.Validate(); err != nil {
+				setupLog.Error(err, "invalid nats options")
+				os.Exit(1)
+			}
+			if err := provisionerOpts.Validate(); err != nil {
+				setupLog.Error(err, "invalid nats provisioner options")
+				os.Exit(1)
+			}
+			if err := hubOpts.Validate(); err != nil {
+				setupLog.Error(err, "invalid hub options")
+				os.Exit(1)
+			}
+			if err := authOpts.Validate(); err != nil {
+				setupLog.Error(err, "invalid authentication options")
+				os.Exit(1)
+			}
+			if provisionerOpts.OperatorSeedFile != "" {
+				// the users of all links share the account, so they may only use the subjects of their link
+				if err := subjectOpts.ValidateLinkScoped(); err != nil {
+					setupLog.Error(err, "invalid subject options, NATS users are provisioned with --nats-operator-seed-file")
+					os.Exit(1)
+				}
+			}
+			if provisionerOpts.OperatorSeedFile != "" && hubOpts.TenantsFile != "" {
+				// the users are provisioned in --nats-account-seed-file, the connectors of other accounts could not be reached
+				setupLog.Error(errors.New("--tenants-file can not be used with --nats-operator-seed-file"), "invalid hub options")
+				os.Exit(1)
+			}
+			if authOpts.ClientCAFile != "" && hubOpts.TLSCertFile == "" {
+				setupLog.Error(errors.New("--client-ca-file requires --tls-cert-file"), "invalid authentication options")
+				os.Exit(1)
+			}
+
+			ctrl.SetLogger(klogr.New()) // nolint:staticcheck
+
+			ctx := ctrl.SetupSignalHandler()
+
+			var (
+				store link.LinkStore
+				fs    blobfs.Interface
+			)
+			if hubOpts.StoreURL != "" {
+				fs = blobfs.New(hubOpts.StoreURL, hubOpts.StorePrefix)
+				store = link.NewBlobFSStore(fs)
+			} else {
+				setupLog.Info("--store-url is not set, links are lost on restart")
+				fs = blobfs.NewInMemoryFS()
+				store = link.NewMemoryStore()
+			}
+			if hubOpts.LinkKeyFile == "" {
+				setupLog.Info("--link-signing-key-file is not set, links do not survive a restart")
+			}
+			signer, err := hub.NewTokenSigner(hubOpts.LinkKeyFile, hubOpts.LinkLifetime)
+			if err != nil {
+				setupLog.Error(err, "failed to create link token signer")
+				os.Exit(1)
+			}
+			np, err := provisionerOpts.New(ctx, fs, *subjectOpts, *natsOpts)
+			if err != nil {
+				setupLog.Error(err, "failed to create nats provisioner")
+				os.Exit(1)
+			}
+			bs, err := link.NewBlobStore()
+			if err != nil {
+				setupLog.Error(err, "failed to create blob store")
+				os.Exit(1)
+			}
+			auth, err := authOpts.New(ctx)
+			if err != nil {
+				setupLog.Error(err, "failed to set up authentication")
+				os.Exit(1)
+			}
+			if auth == nil {
+				setupLog.Info("no authentication is configured, user requests are rejected")
+			} else if authOpts.StaticUserEmail != "" {
+				setupLog.Info("all requests are authenticated as a static user, do not use in production", "email", authOpts.StaticUserEmail)
+			}
+			clientCAs, err := authOpts.ClientCAs()
+			if err != nil {
+				setupLog.Error(err, "failed to load client CAs")
+				os.Exit(1)
+			}
+
+			var tenantsCfg hub.TenantsConfig
+			if hubOpts.TenantsFile != "" {
+				cfg, err := hub.LoadTenantsConfig(hubOpts.TenantsFile)
+				if err != nil {
+					setupLog.Error(err, "failed to load tenants")
+					os.Exit(1)
+				}
+				tenantsCfg = *cfg
+			}
+			tenants := hub.NewPooledTenants(tenantsCfg, *natsOpts, *subjectOpts)
+			tenants.Pool.HealthCheckInterval = hubOpts.NatsHealthCheckInterval
+			tenants.Pool.IdleTimeout = hubOpts.NatsIdleTimeout
+			// fail early if the account of the hub is not reachable, the other accounts are connected on first use
+			if _, err := tenants.Tenant(ctx, ""); err != nil {
+				setupLog.Error(err, "failed to connect to nats")
+				os.Exit(1)
+			}
+			go tenants.Pool.Start(ctx) // nolint:errcheck
+
+			srv := &hub.Server{
+				Store:       store,
+				Signer:      signer,
+				Provisioner: np,
+				Blobs:       bs,
+				Registry:    repo.NewDiskCacheRegistry(),
+				Inventory:   inventory.NewService(store, hubOpts.StaleAfter),
+				Tenants:     tenants,
+				Auth:        auth,
+				StaleAfter:  hubOpts.StaleAfter,
+			}
+			if err := serveHub(ctx, hubOpts, clientCAs, srv.Handler()); err != nil {
+				setupLog.Error(err, "problem running hub")
+				os.Exit(1)
+			}
+			if err := tenants.Pool.Close(); err != nil {
+				setupLog.Error(err, "failed to drain nats connections")
+			}
+		},
+	}
+
+	cmd.Flags().StringVar(&configFile, "config", configFile, "Path to a YAML file with flag values keyed by flag name. Flags set on the command line take precedence. Lists are given as YAML sequences.")
+	hubOpts.AddFlags(cmd.Flags())
+	authOpts.AddFlags(cmd.Flags())
+	provisionerOpts.AddFlags(cmd.Flags())
+	subjectOpts.AddFlags(cmd.Flags())
+	natsOpts.AddFlags(cmd.Flags())
+
+	return cmd
+}
+
+// serveHub serves h until ctx is done, then waits up to o.ShutdownTimeout for in-flight requests.
+// Client certificates are verified with clientCAs, if not nil.
+func serveHub(ctx context.Context, o *hubOptions, clientCAs *x509.CertPool, h http.Handler) error {
+	srv := &http.Server{
+		Addr:              o.Addr,
+		Handler:           h,
+		ReadHeaderTimeout: 30 * time.Second,
+		TLSConfig:         &tls.Config{MinVersion: tls.VersionTLS12},
+	}
+	if clientCAs != nil {
+		// requests without a certificate may use another authentication method
+		srv.TLSConfig.ClientCAs = clientCAs
+		srv.TLSConfig.ClientAuth = tls.VerifyClientCertIfGiven
+	}
+	errCh := make(chan error, 1)
+	go func() {
+		setupLog.Info("serving hub", "address", o.Addr, "tls", o.TLSCertFile != "")
+		if o.TLSCertFile != "" {
+			errCh <- srv.ListenAndServeTLS(o.TLSCertFile, o.TLSKeyFile)
+		} else {
+			errCh <- srv.ListenAndServe()
+		}
+	}()
+
+	select {
+	case err := <-errCh:
+		return err
+	case <-ctx.Done():
+	}
+	setupLog.Info("shutting down hub")
+	sctx, cancel := context.WithTimeout(context.Background(), o.ShutdownTimeout)
+	defer cancel()
+	if err := srv.Shutdown(sctx); err != nil {
+		return err
+	}
+	if err := <-errCh; !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
+
+// loadConfigFile sets the flags in fs from the YAML file keyed by flag name. Flags changed
+// on the command line are kept. Sequences are passed to list flags joined with commas.
+func loadConfigFile(fs *pflag.FlagSet, filename string) error {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return err
+	}
+	var values map[string]any
+	if err := yaml.Unmarshal(data, &values); err != nil {
+		return err
+	}
+
+	names := make([]string, 0, len(values))
+	for name := range values {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var errs []error
+	for _, name := range names {
+		f := fs.Lookup(name)
+		if f == nil || name == "config" {
+			errs = append(errs, fmt.Errorf("unknown flag %q", name))
+			continue
+		}
+		if f.Changed {
+			continue
+		}
+		var s string
+		switch val := values[name].(type) {
+		case nil:
+			continue
+		case []any:
+			items := make([]string, 0, len(val))
+			for _, item := range val {
+				items = append(items, fmt.Sprint(item))
+			}
+			s = strings.Join(items, ",")
+		case float64:
+			// YAML numbers are decoded as float64, format integers without an exponent
+			s = strconv.FormatFloat(val, 'f', -1, 64)
+		case map[string]any:
+			errs = append(errs, fmt.Errorf("flag %q does not take a map", name))
+			continue
+		default:
+			s = fmt.Sprint(val)
+		}
+		if err := fs.Set(name, s); err != nil {
+			errs = append(errs, fmt.Errorf("invalid value for flag %q: %w", name, err))
+		}
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,104 @@
+/*
+Copyright AppsCode Inc. and Contributors
+
+Licensed under the AppsCode Community License 1.0.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://github.com/appscode/licenses/raw/1.0.0/AppsCode-Community-1.0.0.md
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmds
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/spf13/pflag"
+)
+
+func TestLoadConfigFile(t *testing.T) {
+	cases := []struct {
+		name    string
+		config  string
+		args    []string
+		addr    string
+		timeout time.Duration
+		size    int
+		tags    []string
+		wantErr bool
+	}{
+		{
+			name:    "defaults",
+			config:  "",
+			addr:    ":8443",
+			timeout: 30 * time.Second,
+		},
+		{
+			name:    "values",
+			config:  "address: :9443\nshutdown-timeout: 1m\nsize: 8388608\ntags: [a, b]\n",
+			addr:    ":9443",
+			timeout: time.Minute,
+			size:    8388608,
+			tags:    []string{"a", "b"},
+		},
+		{
+			name:    "command line wins",
+			config:  "address: :9443\nshutdown-timeout: 1m\n",
+			args:    []string{"--address=:7443"},
+			addr:    ":7443",
+			timeout: time.Minute,
+		},
+		{
+			name:    "unknown flag",
+			config:  "adress: :9443\n",
+			wantErr: true,
+		},
+		{
+			name:    "invalid value",
+			config:  "shutdown-timeout: soon\n",
+			wantErr: true,
+		},
+	}
+	for _, c := range cases {
+		var (
+			addr    string
+			timeout time.Duration
+			size    int
+			tags    []string
+		)
+		fs := pflag.NewFlagSet(c.name, pflag.ContinueOnError)
+		fs.StringVar(&addr, "address", ":8443", "")
+		fs.DurationVar(&timeout, "shutdown-timeout", 30*time.Second, "")
+		fs.IntVar(&size, "size", 0, "")
+		fs.StringSliceVar(&tags, "tags", nil, "")
+		if err := fs.Parse(c.args); err != nil {
+			t.Fatal(err)
+		}
+
+		filename := filepath.Join(t.TempDir(), "config.yaml")
+		if err := os.WriteFile(filename, []byte(c.config), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		err := loadConfigFile(fs, filename)
+		if (err != nil) != c.wantErr {
+			t.Errorf("%s: got error %v, expected error %v", c.name, err, c.wantErr)
+			continue
+		}
+		if c.wantErr {
+			continue
+		}
+		if addr != c.addr || timeout != c.timeout || size != c.size || !reflect.DeepEqual(tags, c.tags) {
+			t.Errorf("%s: got %s %v %d %v, expected %s %v %d %v", c.name, addr, timeout, size, tags, c.addr, c.timeout, c.size, c.tags)
+		}
+	}
+}
@@ -0,0 +1,164 @@
+/*
+Copyright AppsCode Inc. and Contributors
+
+Licensed under the AppsCode Community License 1.0.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://github.com/appscode/licenses/raw/1.0.0/AppsCode-Community-1.0.0.md
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmds
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+
+	"kubeops.dev/cluster-connector/pkg/shared"
+
+	"github.com/rs/xid"
+	core "k8s.io/api/core/v1"
+	kerr "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"kmodules.xyz/client-go/meta"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+)
+
+const (
+	identityKeyPrivateKey = "private.key"
+	identityKeyID         = "identity.id"
+	identityKeyClusterID  = "cluster.id"
+)
+
+// identity of the connector. It is generated on first start and presented to the hub with
+// every callback, so that the hub can tell when it shows up in another cluster.
+type identity struct {
+	key ed25519.PrivateKey
+	shared.ConnectorIdentity
+}
+
+// loadOrCreateIdentity returns the identity of the connector stored in the Secret key.
+// The identity is generated on first start in the cluster clusterID. If replicas race to
+// create it, all of them use the identity stored by the winner. Secrets created before
+// identities had an id are completed with a new id and the current cluster.
+func loadOrCreateIdentity(ctx context.Context, reader client.Reader, writer client.Writer, key client.ObjectKey, clusterID string) (*identity, error) {
+	var secret core.Secret
+	err := reader.Get(ctx, key, &secret)
+	if err == nil {
+		if len(secret.Data[identityKeyID]) > 0 || len(secret.Data[identityKeyPrivateKey]) == 0 {
+			return decodeIdentity(secret.Data)
+		}
+		secret.Data[identityKeyID] = []byte(xid.New().String())
+		secret.Data[identityKeyClusterID] = []byte(clusterID)
+		if err := writer.Update(ctx, &secret); kerr.IsConflict(err) {
+			return loadOrCreateIdentity(ctx, reader, writer, key, clusterID)
+		} else if err != nil {
+			return nil, err
+		}
+		return decodeIdentity(secret.Data)
+	} else if !kerr.IsNotFound(err) {
+		return nil, err
+	}
+
+	id, err := newIdentity(clusterID)
+	if err != nil {
+		return nil, err
+	}
+	data, err := encodePrivateKey(id.key)
+	if err != nil {
+		return nil, err
+	}
+	err = writer.Create(ctx, &core.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      key.Name,
+			Namespace: key.Namespace,
+		},
+		Type: core.SecretTypeOpaque,
+		Data: map[string][]byte{
+			identityKeyPrivateKey: data,
+			identityKeyID:         []byte(id.ID),
+			identityKeyClusterID:  []byte(id.ClusterID),
+		},
+	})
+	if kerr.IsAlreadyExists(err) {
+		return loadOrCreateIdentity(ctx, reader, writer, key, clusterID)
+	}
+	return id, err
+}
+
+func newIdentity(clusterID string) (*identity, error) {
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	return &identity{
+		key: priv,
+		ConnectorIdentity: shared.ConnectorIdentity{
+			ID:        xid.New().String(),
+			ClusterID: clusterID,
+		},
+	}, nil
+}
+
+func decodeIdentity(data map[string][]byte) (*identity, error) {
+	key, err := decodePrivateKey(data[identityKeyPrivateKey])
+	if err != nil {
+		return nil, err
+	}
+	return &identity{
+		key: key,
+		ConnectorIdentity: shared.ConnectorIdentity{
+			ID:        string(data[identityKeyID]),
+			ClusterID: string(data[identityKeyClusterID]),
+		},
+	}, nil
+}
+
+func encodePrivateKey(key ed25519.PrivateKey) ([]byte, error) {
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), nil
+}
+
+func decodePrivateKey(data []byte) (ed25519.PrivateKey, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("connector identity secret does not contain a PEM encoded private key")
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	priv, ok := key.(ed25519.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("connector identity key is %T, expected ed25519", key)
+	}
+	return priv, nil
+}
+
+// connectorIdentity returns the persisted identity of the connector. Outside a cluster, eg. during development,
+// a new identity is generated on every start.
+func connectorIdentity(ctx context.Context, mgr manager.Manager, secretName, clusterID string) (*identity, error) {
+	if secretName == "" || !meta.PossiblyInCluster() {
+		setupLog.Info("connector identity is not persisted, a new identity is used on every start")
+		return newIdentity(clusterID)
+	}
+	return loadOrCreateIdentity(ctx, mgr.GetAPIReader(), mgr.GetClient(), client.ObjectKey{
+		Namespace: meta.PodNamespace(),
+		Name:      secretName,
+	}, clusterID)
+}
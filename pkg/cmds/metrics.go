@@ -0,0 +1,226 @@
+/*
+Copyright AppsCode Inc. and Contributors
+
+Licensed under the AppsCode Community License 1.0.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://github.com/appscode/licenses/raw/1.0.0/AppsCode-Community-1.0.0.md
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmds
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/prometheus/client_golang/prometheus"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/apiserver/pkg/endpoints/request"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+const (
+	metricsNamespace = "cluster_connector"
+	metricsSubsystem = "proxy"
+)
+
+var (
+	proxyRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Subsystem: metricsSubsystem,
+		Name:      "requests_total",
+		Help:      "Number of requests proxied to the upstream by method, resource and status code.",
+	}, []string{"method", "resource", "code"})
+
+	proxyUpstreamDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Subsystem: metricsSubsystem,
+		Name:      "upstream_duration_seconds",
+		Help:      "Time until the upstream returned response headers.",
+		Buckets:   []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60},
+	}, []string{"method", "resource"})
+
+	proxyRequestBytes = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Subsystem: metricsSubsystem,
+		Name:      "request_bytes_total",
+		Help:      "Bytes received from the hub.",
+	})
+
+	proxyResponseBytes = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Subsystem: metricsSubsystem,
+		Name:      "response_bytes_total",
+		Help:      "Bytes published to the hub.",
+	})
+
+	proxyResponseChunks = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Subsystem: metricsSubsystem,
+		Name:      "response_chunks_total",
+		Help:      "Response chunks published to the hub.",
+	})
+
+	proxyInFlight = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Subsystem: metricsSubsystem,
+		Name:      "requests_in_flight",
+		Help:      "Requests currently being handled.",
+	})
+
+	proxyWorkers = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Subsystem: metricsSubsystem,
+		Name:      "workers",
+		Help:      "Workers currently running by request class.",
+	}, []string{"class"})
+
+	proxyQueueDepth = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Subsystem: metricsSubsystem,
+		Name:      "queue_depth",
+		Help:      "Requests waiting for a worker by request class.",
+	}, []string{"class"})
+
+	proxyRejected = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Subsystem: metricsSubsystem,
+		Name:      "rejected_total",
+		Help:      "Requests rejected with 429 Too Many Requests because the worker pool was full, by request class.",
+	}, []string{"class"})
+
+	callbackAttempts = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Subsystem: "callback",
+		Name:      "attempts_total",
+		Help:      "Link callback attempts by resulting state.",
+	}, []string{"state"})
+
+	callbackState = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Subsystem: "callback",
+		Name:      "state",
+		Help:      "Current state of the link callback, one of pending, registered or rejected.",
+	}, []string{"state"})
+
+	proxyQueueDuration = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Subsystem: metricsSubsystem,
+		Name:      "queue_duration_seconds",
+		Help:      "Time between the hub publishing a request and a handler picking it up. Subject to clock skew between hub and edge.",
+		Buckets:   []float64{0.001, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10},
+	})
+
+	credentialReloads = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Subsystem: "nats",
+		Name:      "credential_reloads_total",
+		Help:      "Number of times the connector reconnected to NATS with changed credentials.",
+	})
+)
+
+func init() {
+	metrics.Registry.MustRegister(
+		proxyRequests,
+		proxyUpstreamDuration,
+		proxyRequestBytes,
+		proxyResponseBytes,
+		proxyResponseChunks,
+		proxyInFlight,
+		proxyWorkers,
+		proxyQueueDepth,
+		proxyRejected,
+		proxyQueueDuration,
+		callbackAttempts,
+		callbackState,
+		credentialReloads,
+	)
+}
+
+// registerConnectionMetrics registers the NATS connection state of the connector.
+func registerConnectionMetrics(c *connector) error {
+	connected := prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Subsystem: "nats",
+		Name:      "connected",
+		Help:      "Whether the connector is connected to NATS.",
+	}, func() float64 {
+		if nc := c.Conn(); nc != nil && nc.IsConnected() {
+			return 1
+		}
+		return 0
+	})
+	reconnects := prometheus.NewCounterFunc(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Subsystem: "nats",
+		Name:      "reconnects_total",
+		Help:      "Number of times the connector reconnected to NATS.",
+	}, func() float64 {
+		if nc := c.Conn(); nc != nil {
+			return float64(nc.Stats().Reconnects)
+		}
+		return 0
+	})
+	for _, col := range []prometheus.Collector{connected, reconnects} {
+		if err := metrics.Registry.Register(col); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+var requestInfoFactory = &request.RequestInfoFactory{
+	APIPrefixes:          sets.NewString("api", "apis"),
+	GrouplessAPIPrefixes: sets.NewString("api"),
+}
+
+// resourceLabel returns the Kubernetes resource targeted by the request.
+// Requests for non-resource urls or services proxied over NATS are reported as "".
+func resourceLabel(req *http.Request) string {
+	if req == nil || req.URL == nil {
+		return ""
+	}
+	info, err := requestInfoFactory.NewRequestInfo(req)
+	if err != nil || !info.IsResourceRequest {
+		return ""
+	}
+	if info.Subresource != "" {
+		return info.Resource + "/" + info.Subresource
+	}
+	return info.Resource
+}
+
+func observeProxyRequest(req *http.Request, resp *http.Response, upstreamDuration time.Duration) {
+	method, resource := "", ""
+	if req != nil {
+		method = req.Method
+		resource = resourceLabel(req)
+	}
+	code := ""
+	if resp != nil {
+		code = strconv.Itoa(resp.StatusCode)
+	}
+	proxyRequests.WithLabelValues(method, resource, code).Inc()
+	proxyUpstreamDuration.WithLabelValues(method, resource).Observe(upstreamDuration.Seconds())
+}
+
+func observeQueueDuration(msg *nats.Msg, now time.Time) {
+	publishedAt, ok := publishTime(msg)
+	if !ok {
+		return
+	}
+	d := now.Sub(publishedAt)
+	if d < 0 {
+		d = 0
+	}
+	proxyQueueDuration.Observe(d.Seconds())
+}
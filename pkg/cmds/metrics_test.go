@@ -0,0 +1,39 @@
+/*
+Copyright AppsCode Inc. and Contributors
+
+Licensed under the AppsCode Community License 1.0.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://github.com/appscode/licenses/raw/1.0.0/AppsCode-Community-1.0.0.md
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmds
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestResourceLabel(t *testing.T) {
+	testCases := map[string]string{
+		"/api/v1/namespaces/default/pods":                     "pods",
+		"/api/v1/namespaces/default/pods/nginx/log":           "pods/log",
+		"/apis/apps/v1/namespaces/default/deployments/nginx":  "deployments",
+		"/apis/apps/v1/deployments?watch=true":                "deployments",
+		"/version":                                            "",
+		"/api/v1/namespaces/kube-system/services/dns/proxy/x": "services/proxy",
+	}
+	for path, expected := range testCases {
+		req := httptest.NewRequest("GET", path, nil)
+		if got := resourceLabel(req); got != expected {
+			t.Errorf("%s: got resource %q, expected %q", path, got, expected)
+		}
+	}
+}
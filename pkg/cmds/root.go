@@ -30,6 +30,8 @@ func NewRootCmd() *cobra.Command {
 
 	rootCmd.AddCommand(v.NewCmdVersion())
 	rootCmd.AddCommand(NewCmdRun())
+	rootCmd.AddCommand(NewCmdUnlink())
+	rootCmd.AddCommand(NewCmdHub())
 
 	return rootCmd
 }
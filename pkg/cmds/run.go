@@ -21,11 +21,11 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
-	"fmt"
 	"io"
 	"net"
 	"net/http"
 	"os"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
@@ -33,9 +33,10 @@ import (
 	"kubeops.dev/cluster-connector/pkg/shared"
 	"kubeops.dev/cluster-connector/pkg/transport"
 
-	"github.com/go-logr/logr"
 	"github.com/nats-io/nats.go"
 	"github.com/spf13/cobra"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 	v "gomodules.xyz/x/version"
 	utilnet "k8s.io/apimachinery/pkg/util/net"
 	"k8s.io/apiserver/pkg/endpoints/handlers/responsewriters"
@@ -46,7 +47,7 @@ import (
 	clustermeta "kmodules.xyz/client-go/cluster"
 	"kmodules.xyz/client-go/meta"
 	ctrl "sigs.k8s.io/controller-runtime"
-	"sigs.k8s.io/controller-runtime/pkg/healthz"
+	"sigs.k8s.io/controller-runtime/pkg/client"
 	metricsserver "sigs.k8s.io/controller-runtime/pkg/metrics/server"
 )
 
@@ -57,13 +58,17 @@ var (
 
 func NewCmdRun() *cobra.Command {
 	var (
-		baseURL      string
 		linkID       string
 		metricsAddr  string
-		natsAddr     string
-		numThreads   = 5
-		natsCredFile string
-		probeAddr    string
+		numThreads   int
+		workerOpts   = newWorkerOptions()
+		callbackOpts = newCallbackOptions()
+		// nats client reconnects on its own, restart only if that does not recover the connection
+		disconnectGracePeriod = 2 * time.Minute
+		probeAddr             string
+		subjectOpts           = shared.NewSubjectOptions()
+		natsOpts              = transport.NewConnectionOptions()
+		tracingOpts           = tracingOptions{}
 	)
 	cmd := &cobra.Command{
 		Use:               "run",
@@ -72,8 +77,25 @@ func NewCmdRun() *cobra.Command {
 		Run: func(cmd *cobra.Command, args []string) {
 			klog.Infof("Starting binary version %s+%s ...", v.Version.Version, v.Version.CommitHash)
 
-			if natsAddr == "" || natsCredFile == "" {
-				setupLog.Info("set --nats-addr and --nats-credential-file flag")
+			if err := natsOpts.Validate(); err != nil {
+				setupLog.Error(err, "invalid nats options")
+				os.Exit(1)
+			}
+			if err := callbackOpts.Validate(); err != nil {
+				setupLog.Error(err, "invalid callback options")
+				os.Exit(1)
+			}
+			if cmd.Flags().Changed("nats-handler-count") {
+				workerOpts.MinShortWorkers = numThreads
+			}
+			if err := workerOpts.Validate(); err != nil {
+				setupLog.Error(err, "invalid worker options")
+				os.Exit(1)
+			}
+
+			names, err := subjectOpts.NewNames(linkID)
+			if err != nil {
+				setupLog.Error(err, "invalid subject options")
 				os.Exit(1)
 			}
 
@@ -81,6 +103,19 @@ func NewCmdRun() *cobra.Command {
 
 			ctx := ctrl.SetupSignalHandler()
 
+			tp, err := tracingOpts.newTracerProvider(ctx)
+			if err != nil {
+				setupLog.Error(err, "unable to set up tracing")
+				os.Exit(1)
+			}
+			defer func() {
+				ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+				defer cancel()
+				if err := tp.Shutdown(ctx); err != nil {
+					setupLog.Error(err, "failed to flush traces")
+				}
+			}()
+
 			mgr, err := ctrl.NewManager(ctrl.GetConfigOrDie(), ctrl.Options{
 				Scheme:                 scheme,
 				Metrics:                metricsserver.Options{BindAddress: metricsAddr},
@@ -99,37 +134,65 @@ func NewCmdRun() *cobra.Command {
 				os.Exit(1)
 			}
 
-			nc, err := transport.NewConnection(natsAddr, natsCredFile)
+			natsJWT := &renewedJWT{}
+			natsOpts.UserJWT = natsJWT.Get
+			conn := newConnector(natsOpts, names, workerOpts, disconnectGracePeriod)
+			if err := mgr.Add(conn); err != nil {
+				setupLog.Error(err, "failed to add nats connector")
+				os.Exit(1)
+			}
+			if err := registerConnectionMetrics(conn); err != nil {
+				setupLog.Error(err, "failed to register nats connection metrics")
+				os.Exit(1)
+			}
+
+			id, err := connectorIdentity(ctx, mgr, callbackOpts.IdentitySecretName, cid)
 			if err != nil {
-				setupLog.Error(err, "failed to connect to nats")
+				setupLog.Error(err, "failed to load connector identity")
 				os.Exit(1)
 			}
+			if id.ClusterID != cid {
+				// the hub decides whether this is a clone or a restored cluster, see link.CheckConnector
+				setupLog.Info("connector identity was created in a different cluster, the hub may reject it as a clone",
+					"identity", id.ID, "identityClusterID", id.ClusterID, "clusterID", cid)
+			}
 
-			for i := 0; i < numThreads; i++ {
-				err = addSubscribers(nc, shared.CrossAccountNames{LinkID: linkID})
-				if err != nil {
-					setupLog.Error(err, "failed to setup proxy handler subscribers")
-					os.Exit(1)
-				}
+			conn.challenge = &challengeResponder{
+				linkID: linkID,
+				key:    id.key,
+				clusterID: func() (string, error) {
+					return clustermeta.ClusterUID(mgr.GetAPIReader())
+				},
 			}
 
-			if err := mgr.AddHealthzCheck("healthz", healthz.Ping); err != nil {
-				setupLog.Error(err, "unable to set up health check")
+			var store registrationStore
+			if callbackOpts.SecretName != "" && meta.PossiblyInCluster() {
+				store = &secretRegistrationStore{
+					reader: mgr.GetAPIReader(),
+					writer: mgr.GetClient(),
+					key:    client.ObjectKey{Namespace: meta.PodNamespace(), Name: callbackOpts.SecretName},
+				}
+			}
+			cb, err := newCallback(callbackOpts, store, id.key, conn.Connected(), shared.CallbackRequest{
+				LinkID:    linkID,
+				ClusterID: cid,
+				Identity:  &id.ConnectorIdentity,
+				Version:   v.Version.Version,
+				Cluster:   clusterInfo(mgr),
+			}, ctrl.Log.WithName("callback"))
+			if err != nil {
+				setupLog.Error(err, "failed to create link callback")
 				os.Exit(1)
 			}
-			if err := mgr.AddReadyzCheck("readyz", healthz.Ping); err != nil {
-				setupLog.Error(err, "unable to set up ready check")
+			cb.natsJWT = natsJWT
+			cb.restoreNatsJWT(ctx)
+			if err := mgr.Add(cb); err != nil {
+				setupLog.Error(err, "failed to add link callback")
 				os.Exit(1)
 			}
 
-			if err := mgr.Add(&callback{
-				baseURL: baseURL,
-				req: shared.CallbackRequest{
-					LinkID:    linkID,
-					ClusterID: cid,
-				},
-			}); err != nil {
-				setupLog.Error(err, "failed to add link callback")
+			if err := addHealthChecks(mgr, conn, cb); err != nil {
+				setupLog.Error(err, "unable to set up health checks")
 				os.Exit(1)
 			}
 
@@ -138,21 +201,22 @@ func NewCmdRun() *cobra.Command {
 				setupLog.Error(err, "problem running manager")
 				os.Exit(1)
 			}
-
-			<-ctx.Done()
-			_ = nc.Drain()
 		},
 	}
 
 	meta.AddLabelBlacklistFlag(cmd.Flags())
 	clustermeta.AddFlags(cmd.Flags())
-	cmd.Flags().StringVar(&baseURL, "baseURL", baseURL, "License server base url")
 	cmd.Flags().StringVar(&linkID, "link-id", linkID, "Link id")
 	cmd.Flags().StringVar(&metricsAddr, "metrics-addr", ":8080", "The address the metric endpoint binds to.")
-	cmd.Flags().StringVar(&natsAddr, "nats-addr", "", "The NATS server address (only used for development).")
-	cmd.Flags().IntVar(&numThreads, "nats-handler-count", numThreads, "The number of handler threads used to respond to nats requests.")
-	cmd.Flags().StringVar(&natsCredFile, "nats-credential-file", natsCredFile, "PATH to NATS credential file")
+	cmd.Flags().IntVar(&numThreads, "nats-handler-count", 5, "The number of handler threads used to respond to nats requests.")
+	_ = cmd.Flags().MarkDeprecated("nats-handler-count", "requests are handled by autoscaling worker pools, the value is used as --min-short-workers")
 	cmd.Flags().StringVar(&probeAddr, "health-probe-bind-address", ":8081", "The address the probe endpoint binds to.")
+	cmd.Flags().DurationVar(&disconnectGracePeriod, "nats-disconnect-grace-period", disconnectGracePeriod, "How long the NATS connection may stay disconnected before the liveness probe fails. Zero disables the check.")
+	subjectOpts.AddFlags(cmd.Flags())
+	natsOpts.AddFlags(cmd.Flags())
+	workerOpts.AddFlags(cmd.Flags())
+	callbackOpts.AddFlags(cmd.Flags())
+	tracingOpts.AddFlags(cmd.Flags())
 
 	return cmd
 }
@@ -164,7 +228,9 @@ var pool = sync.Pool{
 	},
 }
 
-func addSubscribers(nc *nats.Conn, names shared.SubjectNames) error {
+// subscribe adds the queue subscription of the edge for proxy requests sent by the hub on subject.
+// Pods of the same deployment share the queue group, so each request is handled by one of them.
+func subscribe(nc *nats.Conn, subject string, cb nats.MsgHandler) (*nats.Subscription, error) {
 	queue := "cluster-connector"
 	if meta.PossiblyInCluster() {
 		ctrlName := meta.PodName()
@@ -173,76 +239,119 @@ func addSubscribers(nc *nats.Conn, names shared.SubjectNames) error {
 		}
 		queue = meta.PodNamespace() + "." + ctrlName
 	}
+	return nc.QueueSubscribe(subject, queue, cb)
+}
 
-	_, edgeSub := names.ProxyHandlerSubjects()
-	_, err := nc.QueueSubscribe(edgeSub, queue, func(msg *nats.Msg) {
-		r2, req, resp, err := respond(msg.Data)
-		if err != nil {
-			status := responsewriters.ErrorToAPIStatus(err)
-			data, _ := json.Marshal(status)
-
-			resp = &http.Response{
-				Status:           "", // status.Status,
-				StatusCode:       int(status.Code),
-				Proto:            "",
-				ProtoMajor:       0,
-				ProtoMinor:       0,
-				Header:           nil,
-				Body:             io.NopCloser(bytes.NewReader(data)),
-				ContentLength:    int64(len(data)),
-				TransferEncoding: nil,
-				Close:            true,
-				Uncompressed:     false,
-				Trailer:          nil,
-				Request:          nil,
-				TLS:              nil,
-			}
-			if req != nil {
-				resp.Proto = req.Proto
-				resp.ProtoMajor = req.ProtoMajor
-				resp.ProtoMinor = req.ProtoMinor
-
-				resp.TransferEncoding = req.TransferEncoding
-				resp.Request = req
-				resp.TLS = req.TLS
-			}
-			if r2 != nil {
-				resp.Uncompressed = r2.DisableCompression
-			}
-		}
+// handleRequest proxies a request received from the hub to the upstream and streams back the response.
+func handleRequest(nc *nats.Conn, msg *nats.Msg) {
+	now := time.Now()
+	observeQueueDuration(msg, now)
+	proxyInFlight.Inc()
+	defer proxyInFlight.Dec()
+	proxyRequestBytes.Add(float64(len(msg.Data)))
 
-		ncw := &natsWriter{
-			nc:   nc,
-			subj: msg.Reply,
-		}
+	ctx, span := startHandlerSpan(msg, now)
+	defer span.End()
 
-		w := pool.Get().(*bufio.Writer)
-		defer pool.Put(w)
-		w.Reset(ncw)
+	r2, req, resp, err := respond(ctx, msg.Data)
+	upstreamDuration := time.Since(now)
+	if err != nil {
+		span.RecordError(err)
+		resp = errorResponse(r2, req, err)
+	}
+	observeProxyRequest(req, resp, upstreamDuration)
+	span.SetAttributes(attribute.Int("http.response.status_code", resp.StatusCode))
 
-		err = resp.Write(w)
-		ncw.final = true
-		if err != nil {
-			_, _ = ncw.WriteError(err)
-			return
+	publishResponse(ctx, nc, msg.Reply, resp)
+}
+
+// errorResponse converts err into the Status response returned by the api server.
+func errorResponse(r2 *transport.R, req *http.Request, err error) *http.Response {
+	status := responsewriters.ErrorToAPIStatus(err)
+	data, _ := json.Marshal(status)
+
+	resp := &http.Response{
+		Status:           "", // status.Status,
+		StatusCode:       int(status.Code),
+		Proto:            "HTTP/1.1",
+		ProtoMajor:       1,
+		ProtoMinor:       1,
+		Header:           http.Header{},
+		Body:             io.NopCloser(bytes.NewReader(data)),
+		ContentLength:    int64(len(data)),
+		TransferEncoding: nil,
+		Close:            true,
+		Uncompressed:     false,
+		Trailer:          nil,
+		Request:          nil,
+		TLS:              nil,
+	}
+	if status.Details != nil && status.Details.RetryAfterSeconds > 0 {
+		// client-go retries 429 responses after the delay in this header
+		resp.Header.Set("Retry-After", strconv.Itoa(int(status.Details.RetryAfterSeconds)))
+	}
+	if req != nil {
+		resp.Proto = req.Proto
+		resp.ProtoMajor = req.ProtoMajor
+		resp.ProtoMinor = req.ProtoMinor
+
+		resp.TransferEncoding = req.TransferEncoding
+		resp.Request = req
+		resp.TLS = req.TLS
+	}
+	if r2 != nil {
+		resp.Uncompressed = r2.DisableCompression
+	}
+	return resp
+}
+
+// publishResponse streams resp to the reply subject of the hub in chunks.
+func publishResponse(ctx context.Context, nc *nats.Conn, subj string, resp *http.Response) {
+	ncw := &natsWriter{
+		nc:   nc,
+		subj: subj,
+	}
+
+	_, pubSpan := tracer().Start(ctx, "cluster-connector.publish", trace.WithSpanKind(trace.SpanKindProducer))
+	defer func() {
+		pubSpan.SetAttributes(
+			attribute.Int("cluster_connector.response.chunks", ncw.chunks),
+			attribute.Int("cluster_connector.response.bytes", ncw.bytes),
+		)
+		pubSpan.End()
+	}()
+
+	w := pool.Get().(*bufio.Writer)
+	defer pool.Put(w)
+	w.Reset(ncw)
+
+	err := resp.Write(w)
+	ncw.final = true
+	if err != nil {
+		pubSpan.RecordError(err)
+		_, _ = ncw.WriteError(err)
+		return
+	}
+	if w.Buffered() > 0 {
+		if e2 := w.Flush(); e2 != nil {
+			pubSpan.RecordError(e2)
+			klog.ErrorS(e2, "failed to flush buffer")
 		}
-		if w.Buffered() > 0 {
-			if e2 := w.Flush(); e2 != nil {
-				klog.ErrorS(e2, "failed to flush buffer")
-			}
-		} else {
-			if _, e2 := ncw.Write(nil); e2 != nil {
-				klog.ErrorS(e2, "failed to close buffer")
-			}
+	} else {
+		if _, e2 := ncw.Write(nil); e2 != nil {
+			pubSpan.RecordError(e2)
+			klog.ErrorS(e2, "failed to close buffer")
 		}
-	})
-	return err
+	}
 }
 
 type natsWriter struct {
 	nc    *nats.Conn
 	subj  string
 	final bool
+
+	chunks int
+	bytes  int
 }
 
 var _ io.Writer = &natsWriter{}
@@ -252,6 +361,10 @@ func (w *natsWriter) Write(data []byte) (int, error) {
 	if w.final {
 		h.Set(transport.HeaderKeyDone, "")
 	}
+	w.chunks++
+	w.bytes += len(data)
+	proxyResponseChunks.Inc()
+	proxyResponseBytes.Add(float64(len(data)))
 	return len(data), w.nc.PublishMsg(&nats.Msg{
 		Subject: w.subj,
 		Data:    data,
@@ -268,6 +381,8 @@ func (w *natsWriter) WriteError(err error) (int, error) {
 			h.Set(transport.HeaderKeyDone, err.Error())
 		}
 	}
+	w.chunks++
+	proxyResponseChunks.Inc()
 	return 0, w.nc.PublishMsg(&nats.Msg{
 		Subject: w.subj,
 		Data:    nil,
@@ -278,7 +393,7 @@ func (w *natsWriter) WriteError(err error) (int, error) {
 // k8s.io/client-go/transport/cache.go
 const idleConnsPerHost = 25
 
-func respond(in []byte) (*transport.R, *http.Request, *http.Response, error) {
+func respond(ctx context.Context, in []byte) (*transport.R, *http.Request, *http.Response, error) {
 	var r transport.R
 	err := json.Unmarshal(in, &r)
 	if err != nil {
@@ -323,43 +438,18 @@ func respond(in []byte) (*transport.R, *http.Request, *http.Response, error) {
 		Transport: rt,
 		Timeout:   timeout,
 	}
-	resp, err := httpClient.Do(req)
-	return &r, req, resp, err
-}
-
-type callback struct {
-	baseURL string
-	req     shared.CallbackRequest
-	log     logr.Logger
-}
-
-func (cb *callback) InjectLogger(l logr.Logger) error {
-	cb.log = l
-	return nil
-}
-
-func (cb *callback) Start(context.Context) error {
-	data, err := json.Marshal(cb.req)
-	if err != nil {
-		return err
-	}
 
-	resp, err := http.Post(shared.ConnectorCallbackEndpoint(cb.baseURL), "application/json", bytes.NewReader(data))
-	if err != nil {
-		return err
-	}
-	defer resp.Body.Close() // nolint:errcheck
-
-	data, err = io.ReadAll(resp.Body)
-	if err != nil {
-		return err
-	}
-
-	if resp.StatusCode != http.StatusOK {
-		cb.log.Error(fmt.Errorf("%s", data), "callback failed")
-		return fmt.Errorf("callback failed with status code %s", resp.Status)
+	ctx, span := tracer().Start(ctx, "cluster-connector.upstream",
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			attribute.String("http.request.method", req.Method),
+			attribute.String("url.path", req.URL.Path),
+		),
+	)
+	resp, err := httpClient.Do(traceUpstream(ctx, req))
+	if resp != nil {
+		span.SetAttributes(attribute.Int("http.response.status_code", resp.StatusCode))
 	}
-
-	cb.log.Info("link callback successful")
-	return nil
+	endSpan(span, err)
+	return &r, req, resp, err
 }
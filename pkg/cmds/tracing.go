@@ -0,0 +1,168 @@
+/*
+Copyright AppsCode Inc. and Contributors
+
+Licensed under the AppsCode Community License 1.0.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://github.com/appscode/licenses/raw/1.0.0/AppsCode-Community-1.0.0.md
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmds
+
+import (
+	"context"
+	"crypto/tls"
+	"net/http"
+	"net/http/httptrace"
+	"sync"
+	"time"
+
+	"kubeops.dev/cluster-connector/pkg/transport"
+
+	"github.com/nats-io/nats.go"
+	"github.com/spf13/pflag"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	semconv "go.opentelemetry.io/otel/semconv/v1.17.0"
+	"go.opentelemetry.io/otel/trace"
+	"k8s.io/component-base/tracing"
+	tracingapi "k8s.io/component-base/tracing/api/v1"
+)
+
+const tracerName = "kubeops.dev/cluster-connector/pkg/cmds"
+
+type tracingOptions struct {
+	// Endpoint of the OTLP gRPC collector. Tracing is disabled if empty.
+	Endpoint               string
+	SamplingRatePerMillion int32
+}
+
+func (o *tracingOptions) AddFlags(fs *pflag.FlagSet) {
+	fs.StringVar(&o.Endpoint, "tracing-endpoint", o.Endpoint, "OTLP gRPC endpoint (host:port) traces are exported to. Tracing is disabled if empty.")
+	fs.Int32Var(&o.SamplingRatePerMillion, "tracing-sampling-rate-per-million", o.SamplingRatePerMillion, "Number of samples to collect per million spans for requests without a sampled parent span.")
+}
+
+// newTracerProvider creates the TracerProvider of the edge and installs it globally.
+// If tracing is disabled, trace context sent by the hub is still forwarded to the upstream.
+func (o *tracingOptions) newTracerProvider(ctx context.Context) (tracing.TracerProvider, error) {
+	if o.Endpoint == "" {
+		return tracing.NewNoopTracerProvider(), nil
+	}
+	tp, err := tracing.NewProvider(ctx, &tracingapi.TracingConfiguration{
+		Endpoint:               &o.Endpoint,
+		SamplingRatePerMillion: &o.SamplingRatePerMillion,
+	}, nil, []resource.Option{
+		resource.WithAttributes(semconv.ServiceName("cluster-connector")),
+	})
+	if err != nil {
+		return nil, err
+	}
+	otel.SetTracerProvider(tp)
+	return tp, nil
+}
+
+func tracer() trace.Tracer {
+	return otel.Tracer(tracerName)
+}
+
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// startHandlerSpan starts the span of an edge handler for a request received from the hub.
+// The time spent in the NATS queue is recorded as a sibling span, using the publish time sent by the hub.
+func startHandlerSpan(msg *nats.Msg, now time.Time) (context.Context, trace.Span) {
+	ctx := transport.ExtractTraceContext(context.Background(), msg.Header)
+
+	if publishedAt, ok := publishTime(msg); ok {
+		_, queueSpan := tracer().Start(ctx, "cluster-connector.queue",
+			trace.WithSpanKind(trace.SpanKindConsumer),
+			trace.WithTimestamp(publishedAt),
+		)
+		queueSpan.End(trace.WithTimestamp(now))
+	}
+
+	return tracer().Start(ctx, "cluster-connector.handle",
+		trace.WithSpanKind(trace.SpanKindServer),
+		trace.WithTimestamp(now),
+		trace.WithAttributes(
+			attribute.String("messaging.system", "nats"),
+			attribute.String("messaging.destination.name", msg.Subject),
+			attribute.Int("messaging.message.body.size", len(msg.Data)),
+		),
+	)
+}
+
+// traceUpstream returns a copy of req that records the connection setup to the upstream
+// as child spans of ctx and forwards the trace context to the upstream.
+func traceUpstream(ctx context.Context, req *http.Request) *http.Request {
+	var (
+		mu        sync.Mutex
+		dialSpans = map[string]trace.Span{} // dual stack dials may race
+		tlsSpan   trace.Span
+	)
+	ct := &httptrace.ClientTrace{
+		GetConn: func(hostPort string) {
+			trace.SpanFromContext(ctx).AddEvent("get connection", trace.WithAttributes(attribute.String("net.peer.name", hostPort)))
+		},
+		GotConn: func(info httptrace.GotConnInfo) {
+			trace.SpanFromContext(ctx).AddEvent("got connection", trace.WithAttributes(attribute.Bool("net.conn.reused", info.Reused)))
+		},
+		ConnectStart: func(network, addr string) {
+			_, span := tracer().Start(ctx, "cluster-connector.upstream.dial", trace.WithAttributes(
+				attribute.String("net.transport", network),
+				attribute.String("net.peer.name", addr),
+			))
+			mu.Lock()
+			dialSpans[network+"/"+addr] = span
+			mu.Unlock()
+		},
+		ConnectDone: func(network, addr string, err error) {
+			mu.Lock()
+			span, ok := dialSpans[network+"/"+addr]
+			delete(dialSpans, network+"/"+addr)
+			mu.Unlock()
+			if ok {
+				endSpan(span, err)
+			}
+		},
+		TLSHandshakeStart: func() {
+			_, tlsSpan = tracer().Start(ctx, "cluster-connector.upstream.tls")
+		},
+		TLSHandshakeDone: func(_ tls.ConnectionState, err error) {
+			if tlsSpan != nil {
+				endSpan(tlsSpan, err)
+			}
+		},
+	}
+
+	req = req.WithContext(httptrace.WithClientTrace(ctx, ct))
+	tracing.Propagators().Inject(ctx, propagation.HeaderCarrier(req.Header))
+	return req
+}
+
+func publishTime(msg *nats.Msg) (time.Time, bool) {
+	v := msg.Header.Get(transport.HeaderKeyPublishedAt)
+	if v == "" {
+		return time.Time{}, false // older hubs do not send the publish time
+	}
+	t, err := time.Parse(time.RFC3339Nano, v)
+	if err != nil {
+		return time.Time{}, false
+	}
+	return t, true
+}
@@ -0,0 +1,128 @@
+/*
+Copyright AppsCode Inc. and Contributors
+
+Licensed under the AppsCode Community License 1.0.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://github.com/appscode/licenses/raw/1.0.0/AppsCode-Community-1.0.0.md
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmds
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"kubeops.dev/cluster-connector/pkg/transport"
+
+	"github.com/nats-io/nats.go"
+	"go.opentelemetry.io/otel"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace/noop"
+)
+
+// spanRecorder is an in-memory stand-in for the OTLP collector.
+type spanRecorder struct {
+	mu    sync.Mutex
+	spans []sdktrace.ReadOnlySpan
+}
+
+func (r *spanRecorder) ExportSpans(_ context.Context, spans []sdktrace.ReadOnlySpan) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.spans = append(r.spans, spans...)
+	return nil
+}
+
+func (r *spanRecorder) Shutdown(context.Context) error { return nil }
+
+func (r *spanRecorder) byName() map[string]sdktrace.ReadOnlySpan {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	out := map[string]sdktrace.ReadOnlySpan{}
+	for _, s := range r.spans {
+		out[s.Name()] = s
+	}
+	return out
+}
+
+func TestTracePropagation(t *testing.T) {
+	rec := &spanRecorder{}
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSyncer(rec))
+	otel.SetTracerProvider(tp)
+	defer otel.SetTracerProvider(noop.NewTracerProvider())
+
+	var traceparent string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		traceparent = r.Header.Get("traceparent")
+		_, _ = w.Write([]byte("{}"))
+	}))
+	defer srv.Close()
+
+	// hub side
+	hubCtx, hubSpan := tp.Tracer("hub").Start(context.Background(), "hub")
+	req, err := http.NewRequest(http.MethodGet, srv.URL+"/api/v1/namespaces", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if err := req.WriteProxy(&buf); err != nil {
+		t.Fatal(err)
+	}
+	data, err := json.Marshal(transport.R{Request: buf.Bytes()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := nats.Header{}
+	h.Set(transport.HeaderKeyPublishedAt, time.Now().Add(-time.Second).UTC().Format(time.RFC3339Nano))
+	transport.InjectTraceContext(hubCtx, h)
+	hubSpan.End()
+
+	// edge side
+	msg := &nats.Msg{Subject: "k8s.proxy.handler", Header: h, Data: data}
+	ctx, span := startHandlerSpan(msg, time.Now())
+	_, _, resp, err := respond(ctx, msg.Data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = resp.Body.Close()
+	span.End()
+
+	traceID := hubSpan.SpanContext().TraceID()
+	spans := rec.byName()
+	for _, name := range []string{"cluster-connector.queue", "cluster-connector.handle", "cluster-connector.upstream", "cluster-connector.upstream.dial"} {
+		s, ok := spans[name]
+		if !ok {
+			t.Errorf("span %s not recorded", name)
+			continue
+		}
+		if s.SpanContext().TraceID() != traceID {
+			t.Errorf("span %s has trace id %s, expected %s", name, s.SpanContext().TraceID(), traceID)
+		}
+	}
+	if s, ok := spans["cluster-connector.queue"]; ok && s.EndTime().Sub(s.StartTime()) < time.Second {
+		t.Errorf("queue span lasted %s, expected at least 1s", s.EndTime().Sub(s.StartTime()))
+	}
+
+	upstream, ok := spans["cluster-connector.upstream"]
+	if !ok {
+		t.FailNow()
+	}
+	expected := "00-" + traceID.String() + "-" + upstream.SpanContext().SpanID().String() + "-01"
+	if traceparent != expected {
+		t.Errorf("upstream received traceparent %q, expected %q", traceparent, expected)
+	}
+}
@@ -0,0 +1,148 @@
+/*
+Copyright AppsCode Inc. and Contributors
+
+Licensed under the AppsCode Community License 1.0.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://github.com/appscode/licenses/raw/1.0.0/AppsCode-Community-1.0.0.md
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmds
+
+import (
+	"context"
+	"crypto/ed25519"
+	"errors"
+	"net/http"
+	"os"
+
+	"kubeops.dev/cluster-connector/pkg/shared"
+
+	"github.com/go-logr/logr"
+	"github.com/spf13/cobra"
+	core "k8s.io/api/core/v1"
+	kerr "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/klog/v2/klogr"
+	clustermeta "kmodules.xyz/client-go/cluster"
+	"kmodules.xyz/client-go/meta"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+func NewCmdUnlink() *cobra.Command {
+	var (
+		linkID       string
+		namespace    = meta.PodNamespace()
+		force        bool
+		callbackOpts = newCallbackOptions()
+	)
+	cmd := &cobra.Command{
+		Use:               "unlink",
+		Short:             "Deregister the cluster from the hub and remove the connector identity",
+		Long:              "Deregister the cluster from the hub and remove the Secrets created by the connector. The connector itself is removed by uninstalling its chart afterwards.",
+		DisableAutoGenTag: true,
+		Run: func(cmd *cobra.Command, args []string) {
+			if err := callbackOpts.Validate(); err != nil {
+				setupLog.Error(err, "invalid callback options")
+				os.Exit(1)
+			}
+
+			ctrl.SetLogger(klogr.New()) // nolint:staticcheck
+			ctx := ctrl.SetupSignalHandler()
+
+			kc, err := client.New(ctrl.GetConfigOrDie(), client.Options{Scheme: scheme})
+			if err != nil {
+				setupLog.Error(err, "failed to create kubernetes client")
+				os.Exit(1)
+			}
+			hc, err := callbackOpts.HTTPClient()
+			if err != nil {
+				setupLog.Error(err, "failed to create http client")
+				os.Exit(1)
+			}
+
+			identityKey := client.ObjectKey{Namespace: namespace, Name: callbackOpts.IdentitySecretName}
+			registrationKey := client.ObjectKey{Namespace: namespace, Name: callbackOpts.SecretName}
+
+			err = func() error {
+				if linkID == "" {
+					store := &secretRegistrationStore{reader: kc, writer: kc, key: registrationKey}
+					reg, err := store.Get(ctx)
+					if err != nil {
+						return err
+					}
+					if reg == nil {
+						return errors.New("connector is not registered, set --link-id")
+					}
+					linkID = reg.LinkID
+				}
+				cid, err := clustermeta.ClusterUID(kc)
+				if err != nil {
+					return err
+				}
+
+				var secret core.Secret
+				if err := kc.Get(ctx, identityKey, &secret); err != nil {
+					return err
+				}
+				key, err := decodePrivateKey(secret.Data[identityKeyPrivateKey])
+				if err != nil {
+					return err
+				}
+				return unlink(ctx, hc, callbackOpts.BaseURL, key, shared.UnlinkRequest{
+					LinkID:    linkID,
+					ClusterID: cid,
+				}, ctrl.Log.WithName("unlink"))
+			}()
+			if err != nil {
+				if !force {
+					setupLog.Error(err, "failed to deregister from the hub")
+					os.Exit(1)
+				}
+				setupLog.Error(err, "failed to deregister from the hub, removing the connector identity anyway")
+			}
+
+			for _, key := range []client.ObjectKey{registrationKey, identityKey} {
+				if key.Name == "" {
+					continue
+				}
+				err := kc.Delete(ctx, &core.Secret{ObjectMeta: metav1.ObjectMeta{Namespace: key.Namespace, Name: key.Name}})
+				if err != nil && !kerr.IsNotFound(err) {
+					setupLog.Error(err, "failed to delete secret", "secret", key)
+					os.Exit(1)
+				}
+			}
+			setupLog.Info("cluster unlinked", "linkID", linkID)
+		},
+	}
+
+	cmd.Flags().StringVar(&linkID, "link-id", linkID, "Link id. If empty, the link the connector registered with is used.")
+	cmd.Flags().StringVar(&namespace, "namespace", namespace, "Namespace of the connector")
+	cmd.Flags().BoolVar(&force, "force", force, "Remove the connector identity even if the hub can not be reached or rejects the request")
+	callbackOpts.AddFlags(cmd.Flags())
+	_ = cmd.Flags().MarkHidden("callback-retry-interval")
+	_ = cmd.Flags().MarkHidden("callback-retry-max-interval")
+
+	return cmd
+}
+
+// unlink deregisters the connector from the hub. The hub verifies the request
+// against the key bound to the link, so it must be signed with the connector identity.
+// A link unknown to the hub is considered deregistered.
+func unlink(ctx context.Context, hc *http.Client, baseURL string, key ed25519.PrivateKey, req shared.UnlinkRequest, log logr.Logger) error {
+	err := postSigned(ctx, hc, shared.ConnectorUnlinkEndpoint(baseURL), key, req, nil)
+	var rejected *rejectedError
+	if errors.As(err, &rejected) && rejected.code == http.StatusNotFound {
+		log.Info("link is unknown to the hub", "linkID", req.LinkID)
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,76 @@
+/*
+Copyright AppsCode Inc. and Contributors
+
+Licensed under the AppsCode Community License 1.0.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://github.com/appscode/licenses/raw/1.0.0/AppsCode-Community-1.0.0.md
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmds
+
+import (
+	"context"
+	"crypto/ed25519"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"kubeops.dev/cluster-connector/pkg/shared"
+
+	"github.com/go-logr/logr"
+)
+
+func TestUnlink(t *testing.T) {
+	testCases := map[string]struct {
+		status   int
+		rejected bool
+		err      bool
+	}{
+		"unlinked":     {http.StatusOK, false, false},
+		"unknown link": {http.StatusNotFound, false, false},
+		"forbidden":    {http.StatusUnauthorized, true, true},
+		"unavailable":  {http.StatusServiceUnavailable, false, true},
+	}
+	for name, tc := range testCases {
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			body, _ := io.ReadAll(r.Body)
+			if !strings.HasSuffix(r.URL.Path, shared.ConnectorUnlinkAPIPath) {
+				http.Error(w, "unexpected path "+r.URL.Path, http.StatusBadRequest)
+				return
+			}
+			if err := shared.VerifyRequest(r, body, testKey.Public().(ed25519.PublicKey), time.Now()); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			var in shared.UnlinkRequest
+			if err := json.Unmarshal(body, &in); err != nil || in.LinkID != "link1" || in.ClusterID != "cluster1" {
+				http.Error(w, "unexpected request", http.StatusBadRequest)
+				return
+			}
+			w.WriteHeader(tc.status)
+		}))
+
+		err := unlink(context.Background(), srv.Client(), srv.URL, testKey, shared.UnlinkRequest{LinkID: "link1", ClusterID: "cluster1"}, logr.Discard())
+		srv.Close()
+		if (err != nil) != tc.err {
+			t.Errorf("%s: got error %v, expected error: %t", name, err, tc.err)
+		}
+		var rejected *rejectedError
+		if errors.As(err, &rejected) != tc.rejected {
+			t.Errorf("%s: got error %v, expected rejected: %t", name, err, tc.rejected)
+		}
+	}
+}
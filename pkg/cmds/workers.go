@@ -0,0 +1,289 @@
+/*
+Copyright AppsCode Inc. and Contributors
+
+Licensed under the AppsCode Community License 1.0.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://github.com/appscode/licenses/raw/1.0.0/AppsCode-Community-1.0.0.md
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmds
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"kubeops.dev/cluster-connector/pkg/transport"
+
+	"github.com/nats-io/nats.go"
+	"github.com/spf13/pflag"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+)
+
+// retryAfterSeconds is sent to the hub when a request is rejected because the worker pool is full
+const retryAfterSeconds = 1
+
+type workerOptions struct {
+	MinShortWorkers int
+	ShortWorkers    int
+	StreamWorkers   int
+	QueueSize       int
+	IdleTimeout     time.Duration
+}
+
+func newWorkerOptions() *workerOptions {
+	return &workerOptions{
+		ShortWorkers:  32,
+		StreamWorkers: 256,
+		QueueSize:     128,
+		IdleTimeout:   time.Minute,
+	}
+}
+
+func (o *workerOptions) AddFlags(fs *pflag.FlagSet) {
+	fs.IntVar(&o.MinShortWorkers, "min-short-workers", o.MinShortWorkers, "Number of workers for short requests that keep running while idle.")
+	fs.IntVar(&o.ShortWorkers, "max-short-workers", o.ShortWorkers, "Maximum number of concurrent short requests (get, list, create etc.) handled by the connector.")
+	fs.IntVar(&o.StreamWorkers, "max-stream-workers", o.StreamWorkers, "Maximum number of concurrent long running requests (watch, log follow, exec etc.) handled by the connector.")
+	fs.IntVar(&o.QueueSize, "worker-queue-size", o.QueueSize, "Number of requests per class waiting for a worker before new requests are rejected with 429 Too Many Requests.")
+	fs.DurationVar(&o.IdleTimeout, "worker-idle-timeout", o.IdleTimeout, "Idle workers exit after this duration.")
+}
+
+func (o *workerOptions) Validate() error {
+	var errs []error
+	if o.ShortWorkers < 1 {
+		errs = append(errs, errors.New("--max-short-workers must be positive"))
+	}
+	if o.MinShortWorkers < 0 || o.MinShortWorkers > o.ShortWorkers {
+		errs = append(errs, errors.New("--min-short-workers must be between 0 and --max-short-workers"))
+	}
+	if o.StreamWorkers < 1 {
+		errs = append(errs, errors.New("--max-stream-workers must be positive"))
+	}
+	if o.QueueSize < 1 {
+		errs = append(errs, errors.New("--worker-queue-size must be positive"))
+	}
+	if o.IdleTimeout <= 0 {
+		errs = append(errs, errors.New("--worker-idle-timeout must be positive"))
+	}
+	return errors.Join(errs...)
+}
+
+// workerPool runs handle for queued messages. Workers are started on demand up to maxWorkers
+// and exit once they have been idle for idleTimeout, except for minWorkers that keep running.
+type workerPool struct {
+	class       string
+	handle      func(*nats.Msg)
+	minWorkers  int
+	maxWorkers  int
+	queueSize   int
+	idleTimeout time.Duration
+	// queue holds the requests not yet picked up by a worker. Its capacity fits one request per
+	// free worker plus queueSize waiting requests, so that a burst is not rejected before the
+	// workers started for it are scheduled.
+	queue chan *nats.Msg
+
+	mu      sync.Mutex
+	workers int
+	idle    int
+	busy    int
+	stopped bool
+	wg      sync.WaitGroup
+}
+
+func newWorkerPool(class string, minWorkers, maxWorkers, queueSize int, idleTimeout time.Duration, handle func(*nats.Msg)) *workerPool {
+	p := &workerPool{
+		class:       class,
+		handle:      handle,
+		minWorkers:  minWorkers,
+		maxWorkers:  maxWorkers,
+		queueSize:   queueSize,
+		idleTimeout: idleTimeout,
+		queue:       make(chan *nats.Msg, maxWorkers+queueSize),
+	}
+	p.mu.Lock()
+	for p.workers < minWorkers {
+		p.workers++
+		p.wg.Add(1)
+		go p.work()
+	}
+	p.observe()
+	p.mu.Unlock()
+	return p
+}
+
+// Submit queues msg and returns false if the queue is full.
+func (p *workerPool) Submit(msg *nats.Msg) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.stopped {
+		return false
+	}
+	if len(p.queue) >= p.maxWorkers-p.busy+p.queueSize {
+		return false
+	}
+
+	p.queue <- msg
+	if len(p.queue) > p.idle && p.workers < p.maxWorkers {
+		p.workers++
+		p.wg.Add(1)
+		go p.work()
+	}
+	p.observe()
+	return true
+}
+
+func (p *workerPool) work() {
+	defer p.wg.Done()
+
+	for {
+		p.mu.Lock()
+		p.idle++
+		p.mu.Unlock()
+
+		timer := time.NewTimer(p.idleTimeout)
+		select {
+		case msg, ok := <-p.queue:
+			timer.Stop()
+			p.mu.Lock()
+			p.idle--
+			if !ok {
+				p.workers--
+				p.observe()
+				p.mu.Unlock()
+				return
+			}
+			p.busy++
+			p.observe()
+			p.mu.Unlock()
+
+			p.handle(msg)
+
+			p.mu.Lock()
+			p.busy--
+			p.mu.Unlock()
+		case <-timer.C:
+			p.mu.Lock()
+			p.idle--
+			if len(p.queue) > 0 || p.workers <= p.minWorkers {
+				// a request was queued after the timer fired or the worker is one of minWorkers
+				p.mu.Unlock()
+				continue
+			}
+			p.workers--
+			p.observe()
+			p.mu.Unlock()
+			return
+		}
+	}
+}
+
+// Stop rejects new requests and waits until the queued requests are handled or ctx is done.
+func (p *workerPool) Stop(ctx context.Context) error {
+	p.mu.Lock()
+	if !p.stopped {
+		p.stopped = true
+		close(p.queue)
+	}
+	p.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		p.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("%s workers did not finish: %w", p.class, ctx.Err())
+	}
+}
+
+// observe updates the pool metrics, must be called with mu held.
+func (p *workerPool) observe() {
+	proxyWorkers.WithLabelValues(p.class).Set(float64(p.workers))
+	proxyQueueDepth.WithLabelValues(p.class).Set(float64(len(p.queue)))
+}
+
+// dispatcher schedules requests received on the proxy handler subscription on
+// separate pools, so that long running requests can not starve short ones.
+type dispatcher struct {
+	nc    *nats.Conn
+	pools map[string]*workerPool
+}
+
+func newDispatcher(nc *nats.Conn, opts *workerOptions) *dispatcher {
+	handle := func(msg *nats.Msg) {
+		handleRequest(nc, msg)
+	}
+	return &dispatcher{
+		nc: nc,
+		pools: map[string]*workerPool{
+			transport.RequestClassShort:  newWorkerPool(transport.RequestClassShort, opts.MinShortWorkers, opts.ShortWorkers, opts.QueueSize, opts.IdleTimeout, handle),
+			transport.RequestClassStream: newWorkerPool(transport.RequestClassStream, 0, opts.StreamWorkers, opts.QueueSize, opts.IdleTimeout, handle),
+		},
+	}
+}
+
+// Dispatch is the nats.MsgHandler of the proxy handler subject, which receives requests of all classes.
+func (d *dispatcher) Dispatch(msg *nats.Msg) {
+	d.dispatch(requestClass(msg), msg)
+}
+
+// DispatchLane returns the nats.MsgHandler of a priority lane.
+func (d *dispatcher) DispatchLane(lane string) nats.MsgHandler {
+	return func(msg *nats.Msg) {
+		d.dispatch(lane, msg)
+	}
+}
+
+// dispatch must not block the subscription, so requests that do not fit into the pool
+// are rejected with 429 Too Many Requests.
+func (d *dispatcher) dispatch(class string, msg *nats.Msg) {
+	if d.pools[class].Submit(msg) {
+		return
+	}
+	proxyRejected.WithLabelValues(class).Inc()
+	err := apierrors.NewTooManyRequests(fmt.Sprintf("cluster connector is handling too many %s requests", class), retryAfterSeconds)
+	publishResponse(context.Background(), d.nc, msg.Reply, errorResponse(nil, nil, err))
+}
+
+func (d *dispatcher) Stop(ctx context.Context) error {
+	var errs []error
+	for _, p := range d.pools {
+		errs = append(errs, p.Stop(ctx))
+	}
+	return errors.Join(errs...)
+}
+
+// requestClass returns the class set by the hub. Requests from older hubs are classified by decoding them.
+func requestClass(msg *nats.Msg) string {
+	switch class := msg.Header.Get(transport.HeaderKeyRequestClass); class {
+	case transport.RequestClassShort, transport.RequestClassStream:
+		return class
+	}
+
+	var r transport.R
+	if err := json.Unmarshal(msg.Data, &r); err != nil {
+		return transport.RequestClassShort // decoding error is reported by the handler
+	}
+	req, err := http.ReadRequest(bufio.NewReader(bytes.NewReader(r.Request)))
+	if err != nil {
+		return transport.RequestClassShort
+	}
+	return transport.RequestClass(req)
+}
@@ -0,0 +1,109 @@
+/*
+Copyright AppsCode Inc. and Contributors
+
+Licensed under the AppsCode Community License 1.0.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://github.com/appscode/licenses/raw/1.0.0/AppsCode-Community-1.0.0.md
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmds
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"kubeops.dev/cluster-connector/pkg/transport"
+
+	"github.com/nats-io/nats.go"
+)
+
+func TestWorkerPool(t *testing.T) {
+	var running, maxRunning, handled int32
+	release := make(chan struct{})
+	p := newWorkerPool("test", 1, 2, 2, 50*time.Millisecond, func(*nats.Msg) {
+		n := atomic.AddInt32(&running, 1)
+		for {
+			m := atomic.LoadInt32(&maxRunning)
+			if n <= m || atomic.CompareAndSwapInt32(&maxRunning, m, n) {
+				break
+			}
+		}
+		<-release
+		atomic.AddInt32(&running, -1)
+		atomic.AddInt32(&handled, 1)
+	})
+
+	// 2 workers + 2 queued
+	for i := 0; i < 4; i++ {
+		if !p.Submit(&nats.Msg{}) {
+			t.Fatalf("request %d rejected", i)
+		}
+	}
+	waitFor(t, func() bool { return atomic.LoadInt32(&running) == 2 })
+	// both workers are busy and the queue holds 2 requests
+	if p.Submit(&nats.Msg{}) {
+		t.Error("expected full pool to reject the request")
+	}
+
+	close(release)
+	waitFor(t, func() bool { return atomic.LoadInt32(&handled) == 4 })
+	if m := atomic.LoadInt32(&maxRunning); m > 2 {
+		t.Errorf("%d requests ran concurrently, expected at most 2", m)
+	}
+
+	// idle workers exit, except for the minimum
+	waitFor(t, func() bool {
+		p.mu.Lock()
+		defer p.mu.Unlock()
+		return p.workers == 1
+	})
+	time.Sleep(100 * time.Millisecond)
+	p.mu.Lock()
+	if p.workers != 1 {
+		t.Errorf("got %d idle workers, expected 1", p.workers)
+	}
+	p.mu.Unlock()
+
+	if err := p.Stop(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if p.workers != 0 {
+		t.Errorf("got %d workers after stop, expected none", p.workers)
+	}
+	if p.Submit(&nats.Msg{}) {
+		t.Error("expected stopped pool to reject the request")
+	}
+}
+
+func TestRequestClassHeader(t *testing.T) {
+	msg := &nats.Msg{Header: nats.Header{}}
+	msg.Header.Set(transport.HeaderKeyRequestClass, transport.RequestClassStream)
+	if got := requestClass(msg); got != transport.RequestClassStream {
+		t.Errorf("got class %q, expected %q", got, transport.RequestClassStream)
+	}
+	// invalid requests are reported by the short request handler
+	if got := requestClass(&nats.Msg{Data: []byte("{")}); got != transport.RequestClassShort {
+		t.Errorf("got class %q, expected %q", got, transport.RequestClassShort)
+	}
+}
+
+func waitFor(t *testing.T, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("timed out waiting for condition")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
@@ -36,6 +36,15 @@ func NewClient(nc *nats.Conn, names shared.SubjectNames) (*http.Client, error) {
 	}, nil
 }
 
+// NewClientForLink returns a client using the subjects configured by opts for the given link.
+func NewClientForLink(nc *nats.Conn, linkID string, opts shared.SubjectOptions) (*http.Client, error) {
+	names, err := opts.NewNames(linkID)
+	if err != nil {
+		return nil, err
+	}
+	return NewClient(nc, names)
+}
+
 func NewClientForConfig(cfg *ktr.Config, nc *nats.Conn, names shared.SubjectNames) (*http.Client, error) {
 	tr, err := transport.New(cfg, nc, names, shared.Timeout)
 	if err != nil {
@@ -0,0 +1,106 @@
+/*
+Copyright AppsCode Inc. and Contributors
+
+Licensed under the AppsCode Community License 1.0.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://github.com/appscode/licenses/raw/1.0.0/AppsCode-Community-1.0.0.md
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package hub
+
+import (
+	"net/http"
+	"strings"
+
+	"kubeops.dev/cluster-connector/pkg/shared"
+
+	"go.wandrs.dev/binding"
+	"go.wandrs.dev/inject"
+)
+
+// Caller is an authenticated user of the hub.
+type Caller struct {
+	shared.User
+	// Tenant the user belongs to, see Tenants. Empty is the default tenant.
+	Tenant string
+}
+
+// owns returns true if the link was generated by the caller.
+func (c Caller) owns(l *shared.LinkData) bool {
+	return strings.EqualFold(l.User.Email, c.Email) && l.Tenant == c.Tenant
+}
+
+// Authenticator identifies the caller of a request to the hub.
+type Authenticator interface {
+	// Authenticate returns the caller of the request, or nil if the request carries no
+	// credentials the authenticator understands. Invalid credentials are an error.
+	Authenticate(r *http.Request) (*Caller, error)
+}
+
+// Authenticators tries each authenticator in order and returns the first caller found.
+type Authenticators []Authenticator
+
+var _ Authenticator = Authenticators{}
+
+func (a Authenticators) Authenticate(r *http.Request) (*Caller, error) {
+	for _, auth := range a {
+		c, err := auth.Authenticate(r)
+		if err != nil || c != nil {
+			return c, err
+		}
+	}
+	return nil, nil
+}
+
+// StaticUser authenticates every request as the same user. It is meant for development only.
+type StaticUser shared.User
+
+var _ Authenticator = StaticUser{}
+
+func (u StaticUser) Authenticate(*http.Request) (*Caller, error) {
+	return &Caller{User: shared.User(u)}, nil
+}
+
+// bearerToken returns the bearer token of the Authorization header of r, or "" if there is none.
+func bearerToken(r *http.Request) string {
+	scheme, token, found := strings.Cut(r.Header.Get("Authorization"), " ")
+	if !found || !strings.EqualFold(scheme, "Bearer") {
+		return ""
+	}
+	return strings.TrimSpace(token)
+}
+
+// authenticate rejects requests without a caller and injects the Caller and shared.User of the others.
+func authenticate(auth Authenticator) func(next http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			var c *Caller
+			if auth != nil {
+				var err error
+				if c, err = auth.Authenticate(r); err != nil {
+					w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+					http.Error(w, err.Error(), http.StatusUnauthorized)
+					return
+				}
+			}
+			if c == nil {
+				w.Header().Set("WWW-Authenticate", "Bearer")
+				http.Error(w, "authentication required", http.StatusUnauthorized)
+				return
+			}
+			binding.Inject(func(injector inject.Injector) error {
+				injector.Map(*c)
+				injector.Map(c.User)
+				return nil
+			})(next).ServeHTTP(w, r)
+		})
+	}
+}
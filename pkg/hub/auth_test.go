@@ -0,0 +1,237 @@
+/*
+Copyright AppsCode Inc. and Contributors
+
+Licensed under the AppsCode Community License 1.0.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://github.com/appscode/licenses/raw/1.0.0/AppsCode-Community-1.0.0.md
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package hub
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"kubeops.dev/cluster-connector/pkg/shared"
+
+	"github.com/go-jose/go-jose/v4"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+func bearerRequest(token string) *http.Request {
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	if token != "" {
+		r.Header.Set("Authorization", "Bearer "+token)
+	}
+	return r
+}
+
+func TestTokenAuthenticator(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "tokens.csv")
+	data := "# token, email, name, tenant\n" +
+		"t1,alice@example.com\n" +
+		"t2, bob@example.com, Bob, acme\n"
+	if err := os.WriteFile(filename, []byte(data), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	a, err := NewTokenAuthenticator(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		name     string
+		token    string
+		expected *Caller
+	}{
+		{name: "no token", token: "", expected: nil},
+		{name: "unknown token", token: "t3", expected: nil},
+		{name: "email only", token: "t1", expected: &Caller{User: shared.User{Email: "alice@example.com"}}},
+		{name: "tenant", token: "t2", expected: &Caller{User: shared.User{Name: "Bob", Email: "bob@example.com"}, Tenant: "acme"}},
+	}
+	for _, c := range cases {
+		got, err := a.Authenticate(bearerRequest(c.token))
+		if err != nil {
+			t.Errorf("%s: %v", c.name, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, c.expected) {
+			t.Errorf("%s: got %+v, expected %+v", c.name, got, c.expected)
+		}
+	}
+
+	if err := os.WriteFile(filename, []byte("t1,alice@example.com\nt1,bob@example.com\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewTokenAuthenticator(filename); err == nil {
+		t.Error("expected duplicate tokens to be rejected")
+	}
+}
+
+func TestCertAuthenticator(t *testing.T) {
+	cases := []struct {
+		name     string
+		cert     *x509.Certificate
+		expected *Caller
+		wantErr  bool
+	}{
+		{name: "no certificate", cert: nil, expected: nil},
+		{
+			name:     "common name",
+			cert:     &x509.Certificate{Subject: pkix.Name{CommonName: "alice@example.com"}},
+			expected: &Caller{User: shared.User{Name: "alice@example.com", Email: "alice@example.com"}},
+		},
+		{
+			name: "email and organization",
+			cert: &x509.Certificate{
+				Subject:        pkix.Name{CommonName: "Alice", Organization: []string{"acme", "other"}},
+				EmailAddresses: []string{"alice@example.com"},
+			},
+			expected: &Caller{User: shared.User{Name: "Alice", Email: "alice@example.com"}, Tenant: "acme"},
+		},
+		{name: "anonymous", cert: &x509.Certificate{}, wantErr: true},
+	}
+	for _, c := range cases {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		if c.cert != nil {
+			r.TLS = &tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{c.cert}}}
+		}
+		got, err := CertAuthenticator{}.Authenticate(r)
+		if (err != nil) != c.wantErr {
+			t.Errorf("%s: got error %v, expected error %v", c.name, err, c.wantErr)
+			continue
+		}
+		if !reflect.DeepEqual(got, c.expected) {
+			t.Errorf("%s: got %+v, expected %+v", c.name, got, c.expected)
+		}
+	}
+}
+
+func TestOIDCAuthenticator(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var issuer string
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewEncoder(w).Encode(map[string]string{"issuer": issuer, "jwks_uri": issuer + "/keys"})
+	})
+	mux.HandleFunc("/keys", func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewEncoder(w).Encode(jose.JSONWebKeySet{Keys: []jose.JSONWebKey{
+			{Key: &key.PublicKey, KeyID: "k1", Algorithm: "RS256", Use: "sig"},
+		}})
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+	issuer = srv.URL
+
+	a, err := NewOIDCAuthenticator(context.Background(), OIDCOptions{
+		IssuerURL:   issuer,
+		ClientID:    "hub",
+		NameClaim:   "name",
+		TenantClaim: "org",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sign := func(kid string, claims jwt.MapClaims) string {
+		token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+		token.Header["kid"] = kid
+		s, err := token.SignedString(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return s
+	}
+	valid := func(overrides jwt.MapClaims) jwt.MapClaims {
+		claims := jwt.MapClaims{
+			"iss":   issuer,
+			"aud":   "hub",
+			"exp":   time.Now().Add(time.Hour).Unix(),
+			"email": "alice@example.com",
+			"name":  "Alice",
+			"org":   "acme",
+		}
+		for k, v := range overrides {
+			if v == nil {
+				delete(claims, k)
+			} else {
+				claims[k] = v
+			}
+		}
+		return claims
+	}
+
+	cases := []struct {
+		name     string
+		token    string
+		expected *Caller
+		wantErr  bool
+	}{
+		{name: "static token", token: "t1", expected: nil},
+		{
+			name:     "valid",
+			token:    sign("k1", valid(nil)),
+			expected: &Caller{User: shared.User{Name: "Alice", Email: "alice@example.com"}, Tenant: "acme"},
+		},
+		{name: "expired", token: sign("k1", valid(jwt.MapClaims{"exp": time.Now().Add(-time.Hour).Unix()})), wantErr: true},
+		{name: "no expiry", token: sign("k1", valid(jwt.MapClaims{"exp": nil})), wantErr: true},
+		{name: "wrong audience", token: sign("k1", valid(jwt.MapClaims{"aud": "other"})), wantErr: true},
+		{name: "wrong issuer", token: sign("k1", valid(jwt.MapClaims{"iss": "https://example.com"})), wantErr: true},
+		{name: "unknown key", token: sign("k2", valid(nil)), wantErr: true},
+		{name: "unverified email", token: sign("k1", valid(jwt.MapClaims{"email_verified": false})), wantErr: true},
+		{name: "no tenant", token: sign("k1", valid(jwt.MapClaims{"org": nil})), wantErr: true},
+	}
+	for _, c := range cases {
+		got, err := a.Authenticate(bearerRequest(c.token))
+		if (err != nil) != c.wantErr {
+			t.Errorf("%s: got error %v, expected error %v", c.name, err, c.wantErr)
+			continue
+		}
+		if !reflect.DeepEqual(got, c.expected) {
+			t.Errorf("%s: got %+v, expected %+v", c.name, got, c.expected)
+		}
+	}
+}
+
+func TestAuthenticators(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "tokens.csv")
+	if err := os.WriteFile(filename, []byte("t1,alice@example.com\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	tokens, err := NewTokenAuthenticator(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	auth := Authenticators{CertAuthenticator{}, tokens}
+
+	if c, err := auth.Authenticate(bearerRequest("t2")); c != nil || err != nil {
+		t.Errorf("unknown token: got %+v, %v, expected no caller", c, err)
+	}
+	c, err := auth.Authenticate(bearerRequest("t1"))
+	if err != nil || c == nil || c.Email != "alice@example.com" {
+		t.Errorf("token: got %+v, %v, expected alice@example.com", c, err)
+	}
+}
@@ -0,0 +1,54 @@
+/*
+Copyright AppsCode Inc. and Contributors
+
+Licensed under the AppsCode Community License 1.0.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://github.com/appscode/licenses/raw/1.0.0/AppsCode-Community-1.0.0.md
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package hub
+
+import (
+	"errors"
+	"net/http"
+
+	"kubeops.dev/cluster-connector/pkg/shared"
+)
+
+// CertAuthenticator authenticates requests by TLS client certificates. The certificates must be
+// verified by the server, see AuthOptions.ClientCAFile. The user is the first email address of the
+// certificate, or its common name, and the tenant its first organization.
+type CertAuthenticator struct{}
+
+var _ Authenticator = CertAuthenticator{}
+
+func (CertAuthenticator) Authenticate(r *http.Request) (*Caller, error) {
+	if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 || len(r.TLS.VerifiedChains[0]) == 0 {
+		return nil, nil
+	}
+	cert := r.TLS.VerifiedChains[0][0]
+	c := &Caller{
+		User: shared.User{
+			Name:  cert.Subject.CommonName,
+			Email: cert.Subject.CommonName,
+		},
+	}
+	if len(cert.EmailAddresses) > 0 {
+		c.Email = cert.EmailAddresses[0]
+	}
+	if len(cert.Subject.Organization) > 0 {
+		c.Tenant = cert.Subject.Organization[0]
+	}
+	if c.Email == "" {
+		return nil, errors.New("client certificate has neither an email address nor a common name")
+	}
+	return c, nil
+}
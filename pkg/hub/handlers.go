@@ -0,0 +1,429 @@
+/*
+Copyright AppsCode Inc. and Contributors
+
+Licensed under the AppsCode Community License 1.0.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://github.com/appscode/licenses/raw/1.0.0/AppsCode-Community-1.0.0.md
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package hub
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"kubeops.dev/cluster-connector/pkg/inventory"
+	"kubeops.dev/cluster-connector/pkg/link"
+	"kubeops.dev/cluster-connector/pkg/shared"
+	"kubeops.dev/cluster-connector/pkg/transport"
+
+	"github.com/go-chi/chi/v5"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	utilnet "k8s.io/apimachinery/pkg/util/net"
+)
+
+func (s *Server) genLink(ctx context.Context, c Caller, req shared.LinkRequest) (*shared.Link, error) {
+	now := time.Now()
+
+	opts, err := link.NewInstallOptions(req)
+	if err != nil {
+		return nil, apierrors.NewBadRequest(err.Error())
+	}
+
+	// the signed token is used as the link id, so the callback can be verified before the lookup
+	token, claims, err := s.Signer.Issue(now)
+	if err != nil {
+		return nil, err
+	}
+
+	opts.Spec.LinkID = token
+	var natsUser string
+	if s.Provisioner != nil {
+		nu, err := s.Provisioner.Provision(ctx, token, claims.NotAfter())
+		if err != nil {
+			return nil, err
+		}
+		opts.Spec.Nats = nu.Values()
+		natsUser = nu.PublicKey
+	}
+	l, err := link.Generate(nil, s.Blobs, s.Registry, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	err = s.Store.Create(ctx, &shared.LinkData{
+		LinkID:    l.LinkID,
+		User:      c.User,
+		Tenant:    c.Tenant,
+		ClusterID: "", // unknown
+		CreatedAt: now,
+		NotAfter:  claims.NotAfter(),
+		NatsUser:  natsUser,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+// dryRunLink renders the objects a link would install. If the request has the current objects
+// of the cluster, they are compared with the rendered ones. The hub never connects to the
+// cluster of a dry run, since it is not linked yet.
+func (s *Server) dryRunLink(_ context.Context, req shared.LinkRequest) (*link.DryRunResult, error) {
+	opts, err := link.NewInstallOptions(req)
+	if err != nil {
+		return nil, apierrors.NewBadRequest(err.Error())
+	}
+	result, err := link.DryRun(nil, s.Registry, opts)
+	if err != nil {
+		return nil, err
+	}
+	if len(req.Current) == 0 {
+		return result, nil
+	}
+	result.Diff, err = link.Diff(result.Objects, req.Current)
+	if err != nil {
+		return nil, apierrors.NewBadRequest(err.Error())
+	}
+	return result, nil
+}
+
+// verifyCallback rejects callbacks that are not signed with the connector key they carry.
+func verifyCallback(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if _, _, err := shared.VerifyCallback(r, body, time.Now()); err != nil {
+			http.Error(w, err.Error(), http.StatusUnauthorized)
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (s *Server) handleCallback(ctx context.Context, in shared.CallbackRequest) (*shared.CallbackResponse, error) {
+	now := time.Now()
+	l, err := s.Store.Get(ctx, in.LinkID)
+	if errors.Is(err, link.ErrNotFound) {
+		return nil, apierrors.NewNotFound(schema.GroupResource{Resource: "links"}, in.LinkID)
+	} else if err != nil {
+		return nil, err
+	}
+	if l.UsedAt != nil {
+		// the connector retries the callback if the response to a successful one got lost,
+		// anyone else is a clone or reinstall of the connector that may use the link after it expired
+		err = s.checkConnector(ctx, l, in, now)
+	} else {
+		err = s.bindConnector(ctx, l, in, now)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return s.callbackResponse(ctx, l)
+}
+
+// bindConnector binds the unused link l to the connector of the callback in.
+func (s *Server) bindConnector(ctx context.Context, l *shared.LinkData, in shared.CallbackRequest, now time.Time) error {
+	// the connector only retries server errors, anything wrong with the link is final
+	if _, err := s.Signer.Verify(in.LinkID, now); err != nil {
+		return apierrors.NewBadRequest(fmt.Sprintf("invalid link %s, reason: %v", in.LinkID, err))
+	}
+	if l.RevokedAt != nil {
+		return apierrors.NewBadRequest(fmt.Sprintf("link %s was revoked", l.LinkID))
+	}
+	if now.After(l.NotAfter) {
+		return apierrors.NewBadRequest(fmt.Sprintf("link %s expired %v ago", l.LinkID, now.Sub(l.NotAfter)))
+	}
+
+	// the connector proves that it holds the link and runs in the cluster it claims
+	pub, err := shared.ParsePublicKey(in.PublicKey)
+	if err != nil {
+		return apierrors.NewBadRequest(err.Error())
+	}
+	nonce, err := shared.NewChallengeNonce()
+	if err != nil {
+		return err
+	}
+	tenant, err := s.Tenants.Tenant(ctx, l.Tenant)
+	if err != nil {
+		return err
+	}
+	names, err := tenant.Subjects.NewNames(in.LinkID)
+	if err != nil {
+		return err
+	}
+	resp, err := transport.Challenge(ctx, tenant.Nats, names, nonce, shared.ChallengeTimeout)
+	if err != nil {
+		return fmt.Errorf("failed to challenge the connector of link %s, reason: %v", in.LinkID, err)
+	}
+	if err := shared.VerifyChallenge(*resp, pub, in.LinkID, in.ClusterID, nonce); err != nil {
+		return apierrors.NewForbidden(schema.GroupResource{Resource: "links"}, in.LinkID,
+			fmt.Errorf("connector failed the challenge, reason: %v", err))
+	}
+
+	// bind the link to the connector that completed the callback first. Links are single use,
+	// since install scripts get shared by accident, but a repeated callback of the same connector is not.
+	if err := s.Store.BindConnector(ctx, in.LinkID, in.ClusterID, in.PublicKey, now); err != nil {
+		return linkError(in.LinkID, err)
+	}
+	return s.Store.MarkSeen(ctx, in, now)
+}
+
+// callbackResponse renews the NATS user of the link l, since the credentials of the install
+// script expire with the link.
+func (s *Server) callbackResponse(ctx context.Context, l *shared.LinkData) (*shared.CallbackResponse, error) {
+	if s.Provisioner == nil || l.NatsUser == "" {
+		return &shared.CallbackResponse{}, nil
+	}
+	token, err := s.Provisioner.Renew(ctx, l.LinkID, l.NatsUser)
+	if err != nil {
+		return nil, err
+	}
+	return &shared.CallbackResponse{NatsJWT: token}, nil
+}
+
+// linkError converts the errors of a link.LinkStore update into api errors, so that
+// the caller can tell a final rejection from a failure worth retrying.
+func linkError(linkID string, err error) error {
+	gr := schema.GroupResource{Resource: "links"}
+	switch {
+	case errors.Is(err, link.ErrNotFound):
+		return apierrors.NewNotFound(gr, linkID)
+	case errors.Is(err, link.ErrRevoked), errors.Is(err, link.ErrExpired):
+		return apierrors.NewBadRequest(err.Error())
+	case errors.Is(err, link.ErrUsed), errors.Is(err, link.ErrBound):
+		return apierrors.NewConflict(gr, linkID, err)
+	default:
+		return err
+	}
+}
+
+// handleConnect is called by a registered connector on every start and periodically after,
+// to detect clones of the connector and rebuilt clusters.
+func (s *Server) handleConnect(ctx context.Context, in shared.CallbackRequest) (*shared.CallbackResponse, error) {
+	l, err := s.Store.Get(ctx, in.LinkID)
+	if errors.Is(err, link.ErrNotFound) {
+		return nil, apierrors.NewNotFound(schema.GroupResource{Resource: "links"}, in.LinkID)
+	} else if err != nil {
+		return nil, err
+	}
+	if l.UsedAt == nil {
+		return nil, apierrors.NewBadRequest(fmt.Sprintf("link %s is not registered", in.LinkID))
+	}
+	if err := s.checkConnector(ctx, l, in, time.Now()); err != nil {
+		return nil, err
+	}
+	return s.callbackResponse(ctx, l)
+}
+
+// checkConnector accepts the connector bound to the used link l and records any other connector
+// claiming it, so that the user can tell a clone from a rebuilt cluster.
+func (s *Server) checkConnector(ctx context.Context, l *shared.LinkData, in shared.CallbackRequest, now time.Time) error {
+	if l.RevokedAt != nil {
+		return apierrors.NewBadRequest(fmt.Sprintf("link %s was revoked", l.LinkID))
+	}
+	if err := link.CheckConnector(l, in, now, s.StaleAfter); err != nil {
+		if rerr := s.Store.RecordConflict(ctx, l.LinkID, link.NewConflict(in, err, now)); rerr != nil {
+			return rerr
+		}
+		return apierrors.NewConflict(schema.GroupResource{Resource: "links"}, l.LinkID, err)
+	}
+	return s.Store.MarkSeen(ctx, in, now)
+}
+
+// verifyConnector rejects requests of a connector that are not signed with the key bound to its link.
+func verifyConnector(store link.LinkStore) func(next http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			body, err := io.ReadAll(r.Body)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			var in struct {
+				LinkID string `json:"linkID"`
+			}
+			if err := json.Unmarshal(body, &in); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			l, err := store.Get(r.Context(), in.LinkID)
+			if errors.Is(err, link.ErrNotFound) {
+				http.Error(w, err.Error(), http.StatusNotFound)
+				return
+			} else if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			if l.ConnectorPublicKey == "" {
+				http.Error(w, fmt.Sprintf("link %s is not bound to a connector", in.LinkID), http.StatusUnauthorized)
+				return
+			}
+			pub, err := shared.ParsePublicKey(l.ConnectorPublicKey)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			if err := shared.VerifyRequest(r, body, pub, time.Now()); err != nil {
+				http.Error(w, err.Error(), http.StatusUnauthorized)
+				return
+			}
+			r.Body = io.NopCloser(bytes.NewReader(body))
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// handleUnlink deregisters a connector that is being removed from its cluster.
+func (s *Server) handleUnlink(ctx context.Context, in shared.UnlinkRequest) error {
+	l, err := s.Store.Get(ctx, in.LinkID)
+	if err != nil {
+		return linkError(in.LinkID, err)
+	}
+	if l.ClusterID != in.ClusterID {
+		return apierrors.NewForbidden(schema.GroupResource{Resource: "links"}, in.LinkID,
+			fmt.Errorf("link is bound to a different cluster"))
+	}
+	if err := s.revokeLink(ctx, l); err != nil {
+		return linkError(in.LinkID, err)
+	}
+	return nil
+}
+
+// listClusters returns the linked clusters of the user, see inventory.ParseQuery for the query parameters.
+func (s *Server) listClusters(c Caller, r *http.Request) (*inventory.ClusterList, error) {
+	q, err := inventory.ParseQuery(r.URL.Query())
+	if err != nil {
+		return nil, apierrors.NewBadRequest(err.Error())
+	}
+	// WARNING: users only see their own clusters until admins can be told apart
+	q.Owner = c.Email
+	q.Tenant = &c.Tenant
+	return s.Inventory.List(r.Context(), q)
+}
+
+func (s *Server) getCluster(c Caller, r *http.Request) (*inventory.Cluster, error) {
+	linkID := chi.URLParam(r, "linkID")
+	cluster, err := s.Inventory.Get(r.Context(), linkID)
+	if errors.Is(err, link.ErrNotFound) {
+		return nil, apierrors.NewNotFound(schema.GroupResource{Resource: "clusters"}, linkID)
+	} else if err != nil {
+		return nil, err
+	}
+	if !strings.EqualFold(cluster.Owner.Email, c.Email) || cluster.Tenant != c.Tenant {
+		return nil, apierrors.NewNotFound(schema.GroupResource{Resource: "clusters"}, linkID)
+	}
+	return cluster, nil
+}
+
+// handleRevoke disconnects the cluster of a link generated by the user.
+func (s *Server) handleRevoke(ctx context.Context, c Caller, in shared.RevokeRequest) error {
+	l, err := s.Store.Get(ctx, in.LinkID)
+	// links of other users are not found, so that their ids can not be probed
+	if errors.Is(err, link.ErrNotFound) || (err == nil && !c.owns(l)) {
+		return apierrors.NewNotFound(schema.GroupResource{Resource: "links"}, in.LinkID)
+	} else if err != nil {
+		return err
+	}
+	return s.revokeLink(ctx, l)
+}
+
+// revokeLink rejects further callbacks for the link, stops proxying requests to its subjects
+// and revokes the NATS user of its connector.
+func (s *Server) revokeLink(ctx context.Context, l *shared.LinkData) error {
+	if err := s.Store.Revoke(ctx, l.LinkID, time.Now()); err != nil {
+		return err
+	}
+	transport.RevokeLink(l.LinkID)
+
+	if s.Provisioner == nil || l.NatsUser == "" {
+		return nil
+	}
+	return s.Provisioner.Revoke(ctx, l.NatsUser)
+}
+
+// proxyCluster forwards a request to a service of a cluster linked by the user. The service is
+// the name path parameter, eg. "svcname.namespace", "svcname.namespace:port" or "scheme:svcname.namespace:port".
+func (s *Server) proxyCluster(w http.ResponseWriter, r *http.Request, c Caller) error {
+	linkID := chi.URLParam(r, "linkID")
+	name := chi.URLParam(r, "name")
+
+	l, err := s.Store.Get(r.Context(), linkID)
+	if errors.Is(err, link.ErrNotFound) || (err == nil && !c.owns(l)) {
+		return apierrors.NewNotFound(schema.GroupResource{Resource: "clusters"}, linkID)
+	} else if err != nil {
+		return err
+	}
+	if l.RevokedAt != nil {
+		return apierrors.NewBadRequest(fmt.Sprintf("link %s was revoked", linkID))
+	}
+
+	svcScheme, svcName, portStr, valid := utilnet.SplitSchemeNamePort(name)
+	if !valid {
+		return apierrors.NewBadRequest(fmt.Sprintf("invalid service request %q", name))
+	}
+	if svcScheme == "" {
+		svcScheme = "http"
+	}
+	host := svcName
+	if portStr != "" {
+		host = net.JoinHostPort(svcName, portStr)
+	}
+
+	tenant, err := s.Tenants.Tenant(r.Context(), l.Tenant)
+	if err != nil {
+		return err
+	}
+	hc, err := tenant.HTTPClient(linkID)
+	if err != nil {
+		return err
+	}
+
+	req := r.Clone(r.Context())
+	req.URL = &url.URL{
+		Scheme:   svcScheme,
+		Host:     host,
+		Path:     "/" + chi.URLParam(r, "*"),
+		RawQuery: r.URL.RawQuery,
+	}
+	req.Host = ""
+	req.RequestURI = ""
+	// the credentials of the hub user are not meant for the cluster
+	req.Header.Del("Authorization")
+	req.Header.Del("Cookie")
+
+	resp, err := hc.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close() // nolint:errcheck
+
+	for k, v := range resp.Header {
+		w.Header()[k] = v
+	}
+	w.WriteHeader(resp.StatusCode)
+	_, err = io.Copy(w, resp.Body)
+	return err
+}
@@ -0,0 +1,245 @@
+/*
+Copyright AppsCode Inc. and Contributors
+
+Licensed under the AppsCode Community License 1.0.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://github.com/appscode/licenses/raw/1.0.0/AppsCode-Community-1.0.0.md
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package hub
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"kubeops.dev/cluster-connector/pkg/inventory"
+	"kubeops.dev/cluster-connector/pkg/link"
+	"kubeops.dev/cluster-connector/pkg/shared"
+
+	"github.com/nats-io/jwt/v2"
+	"github.com/nats-io/nkeys"
+)
+
+func newTestServer(t *testing.T) (*Server, link.LinkStore) {
+	t.Helper()
+	signer, err := link.NewTokenSigner("test", make([]byte, link.MinTokenKeySize), time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	store := link.NewMemoryStore()
+	return &Server{
+		Store:      store,
+		Signer:     signer,
+		Inventory:  inventory.NewService(store, DefaultStaleAfter),
+		Tenants:    SharedTenants{Subjects: *shared.NewSubjectOptions()},
+		Auth:       StaticUser{Email: "user@example.com"},
+		StaleAfter: DefaultStaleAfter,
+	}, store
+}
+
+// newTestLink stores a link of owner generated by the signer of srv.
+func newTestLink(t *testing.T, srv *Server, store link.LinkStore, owner string, mutate func(*shared.LinkData)) string {
+	t.Helper()
+	now := time.Now()
+	token, _, err := srv.Signer.Issue(now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	l := &shared.LinkData{
+		LinkID:    token,
+		User:      shared.User{Email: owner},
+		CreatedAt: now,
+		NotAfter:  now.Add(time.Hour),
+	}
+	if mutate != nil {
+		mutate(l)
+	}
+	if err := store.Create(context.Background(), l); err != nil {
+		t.Fatal(err)
+	}
+	return l.LinkID
+}
+
+func TestCallbackStatus(t *testing.T) {
+	srv, store := newTestServer(t)
+	past := time.Now().Add(-time.Minute)
+	key := ed25519.NewKeyFromSeed(make([]byte, ed25519.SeedSize))
+
+	cases := []struct {
+		name   string
+		linkID string
+		status int
+	}{
+		{name: "unknown", linkID: "unknown", status: http.StatusNotFound},
+		{name: "invalid token", linkID: newTestLink(t, srv, store, "user@example.com", func(l *shared.LinkData) {
+			l.LinkID = "not-a-token"
+		}), status: http.StatusBadRequest},
+		{name: "revoked", linkID: newTestLink(t, srv, store, "user@example.com", func(l *shared.LinkData) {
+			l.RevokedAt = &past
+		}), status: http.StatusBadRequest},
+		{name: "expired", linkID: newTestLink(t, srv, store, "user@example.com", func(l *shared.LinkData) {
+			l.NotAfter = past
+		}), status: http.StatusBadRequest},
+	}
+	for _, c := range cases {
+		data, err := json.Marshal(shared.CallbackRequest{
+			LinkID:    c.linkID,
+			ClusterID: "cluster1",
+			PublicKey: shared.EncodePublicKey(key.Public().(ed25519.PublicKey)),
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		r := httptest.NewRequest(http.MethodPost, shared.ConnectorAPIPathPrefix+shared.ConnectorCallbackAPIPath, bytes.NewReader(data))
+		r.Header.Set("Content-Type", "application/json")
+		shared.SignRequest(r, data, key, time.Now())
+		w := httptest.NewRecorder()
+		srv.Handler().ServeHTTP(w, r)
+		if w.Code != c.status {
+			t.Errorf("%s: got status %d, expected %d: %s", c.name, w.Code, c.status, w.Body.String())
+		}
+	}
+}
+
+func TestRevokeStatus(t *testing.T) {
+	srv, store := newTestServer(t)
+
+	cases := []struct {
+		name   string
+		linkID string
+		status int
+	}{
+		{name: "unknown", linkID: "unknown", status: http.StatusNotFound},
+		{name: "other user", linkID: newTestLink(t, srv, store, "other@example.com", nil), status: http.StatusNotFound},
+		{name: "other tenant", linkID: newTestLink(t, srv, store, "user@example.com", func(l *shared.LinkData) {
+			l.Tenant = "other"
+		}), status: http.StatusNotFound},
+		{name: "owner", linkID: newTestLink(t, srv, store, "user@example.com", nil), status: http.StatusOK},
+	}
+	for _, c := range cases {
+		data, err := json.Marshal(shared.RevokeRequest{LinkID: c.linkID})
+		if err != nil {
+			t.Fatal(err)
+		}
+		r := httptest.NewRequest(http.MethodPost, shared.ConnectorAPIPathPrefix+shared.ConnectorRevokeAPIPath, bytes.NewReader(data))
+		r.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		srv.Handler().ServeHTTP(w, r)
+		if w.Code != c.status {
+			t.Errorf("%s: got status %d, expected %d: %s", c.name, w.Code, c.status, w.Body.String())
+		}
+	}
+}
+
+func TestUnlinkStatus(t *testing.T) {
+	srv, store := newTestServer(t)
+	key := ed25519.NewKeyFromSeed(make([]byte, ed25519.SeedSize))
+	publicKey := shared.EncodePublicKey(key.Public().(ed25519.PublicKey))
+	bound := func(l *shared.LinkData) {
+		l.ClusterID = "cluster1"
+		l.ConnectorPublicKey = publicKey
+	}
+	linkID := newTestLink(t, srv, store, "user@example.com", bound)
+
+	cases := []struct {
+		name      string
+		linkID    string
+		clusterID string
+		status    int
+	}{
+		{name: "unknown", linkID: "unknown", clusterID: "cluster1", status: http.StatusNotFound},
+		{name: "other cluster", linkID: linkID, clusterID: "cluster2", status: http.StatusForbidden},
+		{name: "bound cluster", linkID: linkID, clusterID: "cluster1", status: http.StatusOK},
+		{name: "unlinked", linkID: linkID, clusterID: "cluster1", status: http.StatusOK},
+	}
+	for _, c := range cases {
+		data, err := json.Marshal(shared.UnlinkRequest{LinkID: c.linkID, ClusterID: c.clusterID})
+		if err != nil {
+			t.Fatal(err)
+		}
+		r := httptest.NewRequest(http.MethodPost, shared.ConnectorAPIPathPrefix+shared.ConnectorUnlinkAPIPath, bytes.NewReader(data))
+		r.Header.Set("Content-Type", "application/json")
+		shared.SignRequest(r, data, key, time.Now())
+		w := httptest.NewRecorder()
+		srv.Handler().ServeHTTP(w, r)
+		if w.Code != c.status {
+			t.Errorf("%s: got status %d, expected %d: %s", c.name, w.Code, c.status, w.Body.String())
+		}
+	}
+}
+
+func TestConnectRenewsNatsUser(t *testing.T) {
+	srv, store := newTestServer(t)
+	operator, _ := nkeys.CreateOperator()
+	account, _ := nkeys.CreateAccount()
+	operatorSeed, _ := operator.Seed()
+	accountSeed, _ := account.Seed()
+	accountPub, _ := account.PublicKey()
+	subjects := *shared.NewSubjectOptions()
+	subjects.ProxyHandlerEdgeSubject = "k8s.proxy.handler.{{ .LinkID }}"
+	subjects.ProxyResponseEdgeSubject = "k8s.proxy.resp.{{ .LinkID }}.{{ .RequestID }}"
+	p, err := link.NewOperatorProvisioner("nats://nats.example.com:4222", operatorSeed, accountSeed, subjects, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv.Provisioner = p
+
+	key := ed25519.NewKeyFromSeed(make([]byte, ed25519.SeedSize))
+	publicKey := shared.EncodePublicKey(key.Public().(ed25519.PublicKey))
+	now := time.Now()
+	var nu *link.NatsUser
+	linkID := newTestLink(t, srv, store, "user@example.com", func(l *shared.LinkData) {
+		if nu, err = p.Provision(context.Background(), l.LinkID, l.NotAfter); err != nil {
+			t.Fatal(err)
+		}
+		l.NatsUser = nu.PublicKey
+		l.ClusterID = "cluster1"
+		l.ConnectorPublicKey = publicKey
+		l.UsedAt = &now
+	})
+
+	data, err := json.Marshal(shared.CallbackRequest{
+		LinkID:    linkID,
+		ClusterID: "cluster1",
+		PublicKey: publicKey,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := httptest.NewRequest(http.MethodPost, shared.ConnectorAPIPathPrefix+shared.ConnectorConnectAPIPath, bytes.NewReader(data))
+	r.Header.Set("Content-Type", "application/json")
+	shared.SignRequest(r, data, key, time.Now())
+	w := httptest.NewRecorder()
+	srv.Handler().ServeHTTP(w, r)
+	if w.Code != http.StatusOK {
+		t.Fatalf("got status %d, expected %d: %s", w.Code, http.StatusOK, w.Body.String())
+	}
+
+	// the user of the install script expires with the link, the renewed one does not
+	var resp shared.CallbackResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	uc, err := jwt.DecodeUserClaims(resp.NatsJWT)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if uc.Subject != nu.PublicKey || uc.Issuer != accountPub || uc.Expires != 0 {
+		t.Errorf("got user %s issued by %s expiring at %d, expected %s issued by %s without expiry",
+			uc.Subject, uc.Issuer, uc.Expires, nu.PublicKey, accountPub)
+	}
+}
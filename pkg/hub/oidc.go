@@ -0,0 +1,246 @@
+/*
+Copyright AppsCode Inc. and Contributors
+
+Licensed under the AppsCode Community License 1.0.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://github.com/appscode/licenses/raw/1.0.0/AppsCode-Community-1.0.0.md
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package hub
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"kubeops.dev/cluster-connector/pkg/shared"
+
+	"github.com/go-jose/go-jose/v4"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const (
+	// oidcKeysMaxAge is how long the signing keys of the issuer are cached.
+	oidcKeysMaxAge = time.Hour
+	// oidcKeysMinRefresh limits how often tokens signed by unknown keys refresh the keys.
+	oidcKeysMinRefresh = time.Minute
+)
+
+// OIDCOptions configure the validation of OIDC id tokens.
+type OIDCOptions struct {
+	IssuerURL string
+	// ClientID is the audience the tokens must be issued for.
+	ClientID string
+	// CAFile is the CA bundle used to verify the issuer, the system roots are used if empty.
+	CAFile string
+	// UsernameClaim holds the email of the user. If it is "email", the
+	// email_verified claim must not be false.
+	UsernameClaim string
+	NameClaim     string
+	// TenantClaim holds the tenant of the user. If empty, all users belong to the default tenant.
+	TenantClaim string
+}
+
+// OIDCAuthenticator authenticates requests by OIDC id tokens sent as bearer tokens.
+type OIDCAuthenticator struct {
+	opts    OIDCOptions
+	client  *http.Client
+	jwksURL string
+	parser  *jwt.Parser
+
+	mu          sync.RWMutex
+	keys        jose.JSONWebKeySet
+	fetchedAt   time.Time
+	attemptedAt time.Time
+	// refresh is closed when a running refresh of the keys completes
+	refresh chan struct{}
+}
+
+var _ Authenticator = &OIDCAuthenticator{}
+
+// NewOIDCAuthenticator discovers the signing keys of the issuer.
+func NewOIDCAuthenticator(ctx context.Context, opts OIDCOptions) (*OIDCAuthenticator, error) {
+	if opts.UsernameClaim == "" {
+		opts.UsernameClaim = "email"
+	}
+	client := &http.Client{Timeout: 30 * time.Second}
+	if opts.CAFile != "" {
+		data, err := os.ReadFile(opts.CAFile)
+		if err != nil {
+			return nil, err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(data) {
+			return nil, fmt.Errorf("no certificates found in %s", opts.CAFile)
+		}
+		tr := http.DefaultTransport.(*http.Transport).Clone()
+		tr.TLSClientConfig = &tls.Config{RootCAs: pool, MinVersion: tls.VersionTLS12}
+		client.Transport = tr
+	}
+
+	var discovery struct {
+		Issuer  string `json:"issuer"`
+		JWKSURL string `json:"jwks_uri"`
+	}
+	if err := getJSON(ctx, client, strings.TrimSuffix(opts.IssuerURL, "/")+"/.well-known/openid-configuration", &discovery); err != nil {
+		return nil, fmt.Errorf("failed to discover oidc issuer %s: %w", opts.IssuerURL, err)
+	}
+	if discovery.Issuer != opts.IssuerURL {
+		return nil, fmt.Errorf("oidc issuer %s identifies as %s", opts.IssuerURL, discovery.Issuer)
+	}
+	if discovery.JWKSURL == "" {
+		return nil, fmt.Errorf("oidc issuer %s has no jwks_uri", opts.IssuerURL)
+	}
+
+	a := &OIDCAuthenticator{
+		opts:    opts,
+		client:  client,
+		jwksURL: discovery.JWKSURL,
+		parser: jwt.NewParser(
+			jwt.WithValidMethods([]string{"RS256", "RS384", "RS512", "PS256", "PS384", "PS512", "ES256", "ES384", "ES512", "EdDSA"}),
+			jwt.WithIssuer(opts.IssuerURL),
+			jwt.WithAudience(opts.ClientID),
+			jwt.WithExpirationRequired(),
+			jwt.WithLeeway(time.Minute),
+		),
+	}
+	if err := a.refreshKeys(ctx); err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+// Authenticate returns nil for bearer tokens that are not JWTs, so that they may be checked by other authenticators.
+func (a *OIDCAuthenticator) Authenticate(r *http.Request) (*Caller, error) {
+	token := bearerToken(r)
+	if strings.Count(token, ".") != 2 {
+		return nil, nil
+	}
+
+	claims := jwt.MapClaims{}
+	_, err := a.parser.ParseWithClaims(token, claims, func(t *jwt.Token) (any, error) {
+		kid, _ := t.Header["kid"].(string)
+		return a.verificationKeys(r.Context(), kid)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("invalid oidc token: %w", err)
+	}
+
+	email, _ := claims[a.opts.UsernameClaim].(string)
+	if email == "" {
+		return nil, fmt.Errorf("oidc token has no %s claim", a.opts.UsernameClaim)
+	}
+	if a.opts.UsernameClaim == "email" {
+		if verified, ok := claims["email_verified"].(bool); ok && !verified {
+			return nil, fmt.Errorf("email %s is not verified", email)
+		}
+	}
+	c := &Caller{User: shared.User{Email: email}}
+	if a.opts.NameClaim != "" {
+		c.Name, _ = claims[a.opts.NameClaim].(string)
+	}
+	if a.opts.TenantClaim != "" {
+		if c.Tenant, _ = claims[a.opts.TenantClaim].(string); c.Tenant == "" {
+			return nil, fmt.Errorf("oidc token has no %s claim", a.opts.TenantClaim)
+		}
+	}
+	return c, nil
+}
+
+// verificationKeys returns the signing keys with the kid, refreshing the cached keys if none is known.
+func (a *OIDCAuthenticator) verificationKeys(ctx context.Context, kid string) (jwt.VerificationKeySet, error) {
+	a.mu.RLock()
+	set := a.lookup(kid)
+	canRefresh := time.Since(a.attemptedAt) > oidcKeysMinRefresh
+	stale := time.Since(a.fetchedAt) > oidcKeysMaxAge
+	a.mu.RUnlock()
+
+	if canRefresh && (len(set.Keys) == 0 || stale) {
+		if err := a.refreshKeys(ctx); err != nil && len(set.Keys) == 0 {
+			return set, err
+		}
+		a.mu.RLock()
+		set = a.lookup(kid)
+		a.mu.RUnlock()
+	}
+	if len(set.Keys) == 0 {
+		return set, fmt.Errorf("unknown signing key %q", kid)
+	}
+	return set, nil
+}
+
+// lookup must be called with a.mu held.
+func (a *OIDCAuthenticator) lookup(kid string) jwt.VerificationKeySet {
+	var set jwt.VerificationKeySet
+	for _, k := range a.keys.Keys {
+		if (kid == "" || k.KeyID == kid) && k.Use != "enc" && k.IsPublic() {
+			set.Keys = append(set.Keys, k.Key)
+		}
+	}
+	return set
+}
+
+// refreshKeys fetches the signing keys of the issuer. Concurrent callers share one request.
+func (a *OIDCAuthenticator) refreshKeys(ctx context.Context) error {
+	a.mu.Lock()
+	if a.refresh != nil {
+		done := a.refresh
+		a.mu.Unlock()
+		select {
+		case <-done:
+			return nil
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	done := make(chan struct{})
+	a.refresh = done
+	a.attemptedAt = time.Now()
+	a.mu.Unlock()
+
+	var keys jose.JSONWebKeySet
+	err := getJSON(ctx, a.client, a.jwksURL, &keys)
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.refresh = nil
+	close(done)
+	if err != nil {
+		return fmt.Errorf("failed to fetch oidc signing keys: %w", err)
+	}
+	a.keys = keys
+	a.fetchedAt = time.Now()
+	return nil
+}
+
+func getJSON(ctx context.Context, client *http.Client, url string, v any) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close() // nolint:errcheck
+	if resp.StatusCode != http.StatusOK {
+		return errors.New(resp.Status)
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
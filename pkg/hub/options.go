@@ -0,0 +1,220 @@
+/*
+Copyright AppsCode Inc. and Contributors
+
+Licensed under the AppsCode Community License 1.0.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://github.com/appscode/licenses/raw/1.0.0/AppsCode-Community-1.0.0.md
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package hub
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"kubeops.dev/cluster-connector/pkg/link"
+	"kubeops.dev/cluster-connector/pkg/shared"
+	"kubeops.dev/cluster-connector/pkg/transport"
+
+	"github.com/spf13/pflag"
+	"gomodules.xyz/blobfs"
+)
+
+// NewTokenSigner returns the signer of link tokens with the key read from keyFile.
+// If keyFile is empty, a random key is used and links do not survive a restart.
+func NewTokenSigner(keyFile string, lifetime time.Duration) (*link.TokenSigner, error) {
+	var key []byte
+	if keyFile != "" {
+		data, err := os.ReadFile(keyFile)
+		if err != nil {
+			return nil, err
+		}
+		key = bytes.TrimSpace(data)
+	} else {
+		key = make([]byte, link.MinTokenKeySize)
+		if _, err := rand.Read(key); err != nil {
+			return nil, err
+		}
+	}
+	return link.NewTokenSigner(shared.ConnectorLinkHost, key, lifetime)
+}
+
+// ProvisionerOptions configure the NATS users provisioned for the connectors of links.
+type ProvisionerOptions struct {
+	// ConnectorAddr is the NATS address passed to the connectors.
+	ConnectorAddr    string
+	OperatorSeedFile string
+	AccountSeedFile  string
+	ClaimsFile       string
+	SystemCredsFile  string
+}
+
+func (o *ProvisionerOptions) AddFlags(fs *pflag.FlagSet) {
+	fs.StringVar(&o.ConnectorAddr, "connector-nats-addr", o.ConnectorAddr, "NATS address passed to the connectors of generated links")
+	fs.StringVar(&o.OperatorSeedFile, "nats-operator-seed-file", o.OperatorSeedFile, "Path to the NATS operator seed. If empty, NATS users are not provisioned for links. The users share an account, so --proxy-handler-edge-subject and --proxy-response-edge-subject must use {{ .LinkID }}.")
+	fs.StringVar(&o.AccountSeedFile, "nats-account-seed-file", o.AccountSeedFile, "Path to the seed of the NATS account of the connectors")
+	fs.StringVar(&o.ClaimsFile, "nats-account-claims-file", o.ClaimsFile, "Path to a json file with the NATS claims of the account of the connectors, eg. limits, exports and imports")
+	fs.StringVar(&o.SystemCredsFile, "nats-system-credential-file", o.SystemCredsFile, "Path to the credential file of a NATS system account user, used to push revocations to the NATS servers")
+}
+
+func (o *ProvisionerOptions) Validate() error {
+	if o.OperatorSeedFile == "" {
+		return nil
+	}
+	var errs []error
+	if o.ConnectorAddr == "" {
+		errs = append(errs, errors.New("--connector-nats-addr is required to provision NATS users"))
+	}
+	if o.AccountSeedFile == "" {
+		errs = append(errs, errors.New("--nats-account-seed-file is required to provision NATS users"))
+	}
+	return errors.Join(errs...)
+}
+
+// New returns the provisioner, or nil if NATS users are not provisioned. Revoked users are persisted in fs.
+// Revocations are pushed to the NATS servers with a system account user connected like conn.
+func (o ProvisionerOptions) New(ctx context.Context, fs blobfs.Interface, subjects shared.SubjectOptions, conn transport.ConnectionOptions) (*link.OperatorProvisioner, error) {
+	if o.OperatorSeedFile == "" {
+		return nil, nil
+	}
+	operatorSeed, err := os.ReadFile(o.OperatorSeedFile)
+	if err != nil {
+		return nil, err
+	}
+	accountSeed, err := os.ReadFile(o.AccountSeedFile)
+	if err != nil {
+		return nil, err
+	}
+	np, err := link.NewOperatorProvisioner(o.ConnectorAddr, bytes.TrimSpace(operatorSeed), bytes.TrimSpace(accountSeed), subjects, fs)
+	if err != nil {
+		return nil, err
+	}
+	if o.ClaimsFile != "" {
+		data, err := os.ReadFile(o.ClaimsFile)
+		if err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(data, &np.AccountClaims); err != nil {
+			return nil, err
+		}
+	}
+	if o.SystemCredsFile != "" {
+		// CredFile takes precedence over the other authentication methods
+		conn.CredFile = o.SystemCredsFile
+		nc, err := conn.Connect(ctx)
+		if err != nil {
+			return nil, err
+		}
+		np.PublishAccount = link.NatsAccountPublisher(nc, 10*time.Second)
+	}
+	return np, nil
+}
+
+// AuthOptions configure the authentication of users of the hub. Credentials are tried in the
+// order: client certificate, static token, OIDC token.
+type AuthOptions struct {
+	// ClientCAFile is the CA bundle client certificates are verified with, see CertAuthenticator.
+	ClientCAFile string
+	// TokenFile holds static API tokens, see NewTokenAuthenticator.
+	TokenFile string
+	OIDC      OIDCOptions
+	// StaticUserEmail authenticates every request as this user, for development only.
+	StaticUserEmail string
+	StaticUserName  string
+}
+
+func NewAuthOptions() *AuthOptions {
+	return &AuthOptions{
+		OIDC: OIDCOptions{
+			UsernameClaim: "email",
+			NameClaim:     "name",
+		},
+	}
+}
+
+func (o *AuthOptions) AddFlags(fs *pflag.FlagSet) {
+	fs.StringVar(&o.ClientCAFile, "client-ca-file", o.ClientCAFile, "PEM encoded CA bundle client certificates are verified with. The user is the first email address of a certificate, or its common name, and the tenant its first organization.")
+	fs.StringVar(&o.TokenFile, "token-auth-file", o.TokenFile, "Path to a csv file of static API tokens with the columns token, email, name and tenant. Name and tenant are optional.")
+	fs.StringVar(&o.OIDC.IssuerURL, "oidc-issuer-url", o.OIDC.IssuerURL, "URL of the OIDC issuer whose id tokens are accepted as bearer tokens")
+	fs.StringVar(&o.OIDC.ClientID, "oidc-client-id", o.OIDC.ClientID, "Client id the OIDC id tokens must be issued for")
+	fs.StringVar(&o.OIDC.CAFile, "oidc-ca-file", o.OIDC.CAFile, "PEM encoded CA bundle the OIDC issuer is verified with. If empty, the system roots are used.")
+	fs.StringVar(&o.OIDC.UsernameClaim, "oidc-username-claim", o.OIDC.UsernameClaim, "OIDC claim holding the email of the user")
+	fs.StringVar(&o.OIDC.NameClaim, "oidc-name-claim", o.OIDC.NameClaim, "OIDC claim holding the name of the user")
+	fs.StringVar(&o.OIDC.TenantClaim, "oidc-tenant-claim", o.OIDC.TenantClaim, "OIDC claim holding the tenant of the user. If empty, all OIDC users belong to the default tenant.")
+	fs.StringVar(&o.StaticUserEmail, "static-user-email", o.StaticUserEmail, "Authenticate every request as the user with this email. For development only.")
+	fs.StringVar(&o.StaticUserName, "static-user-name", o.StaticUserName, "Name of the user set with --static-user-email")
+}
+
+func (o *AuthOptions) Validate() error {
+	var errs []error
+	if (o.OIDC.IssuerURL == "") != (o.OIDC.ClientID == "") {
+		errs = append(errs, errors.New("--oidc-issuer-url and --oidc-client-id must be set together"))
+	}
+	if o.OIDC.IssuerURL != "" && o.OIDC.UsernameClaim == "" {
+		errs = append(errs, errors.New("--oidc-username-claim is required"))
+	}
+	if o.StaticUserEmail != "" && (o.ClientCAFile != "" || o.TokenFile != "" || o.OIDC.IssuerURL != "") {
+		errs = append(errs, errors.New("--static-user-email can not be combined with other authentication methods"))
+	}
+	return errors.Join(errs...)
+}
+
+// New returns the authenticator of users, or nil if no authentication method is configured.
+func (o AuthOptions) New(ctx context.Context) (Authenticator, error) {
+	if o.StaticUserEmail != "" {
+		return StaticUser{Name: o.StaticUserName, Email: o.StaticUserEmail}, nil
+	}
+	var auth Authenticators
+	if o.ClientCAFile != "" {
+		auth = append(auth, CertAuthenticator{})
+	}
+	if o.TokenFile != "" {
+		a, err := NewTokenAuthenticator(o.TokenFile)
+		if err != nil {
+			return nil, err
+		}
+		auth = append(auth, a)
+	}
+	if o.OIDC.IssuerURL != "" {
+		a, err := NewOIDCAuthenticator(ctx, o.OIDC)
+		if err != nil {
+			return nil, err
+		}
+		auth = append(auth, a)
+	}
+	if len(auth) == 0 {
+		return nil, nil
+	}
+	return auth, nil
+}
+
+// ClientCAs returns the pool client certificates are verified with, or nil if client certificates are not used.
+func (o AuthOptions) ClientCAs() (*x509.CertPool, error) {
+	if o.ClientCAFile == "" {
+		return nil, nil
+	}
+	data, err := os.ReadFile(o.ClientCAFile)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(data) {
+		return nil, fmt.Errorf("no certificates found in %s", o.ClientCAFile)
+	}
+	return pool, nil
+}
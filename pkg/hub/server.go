@@ -0,0 +1,100 @@
+/*
+Copyright AppsCode Inc. and Contributors
+
+Licensed under the AppsCode Community License 1.0.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://github.com/appscode/licenses/raw/1.0.0/AppsCode-Community-1.0.0.md
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package hub serves the APIs of the hub: links, connector callbacks, the cluster inventory
+// and the proxy to the services of linked clusters.
+package hub
+
+import (
+	"net/http"
+	"time"
+
+	"kubeops.dev/cluster-connector/pkg/inventory"
+	"kubeops.dev/cluster-connector/pkg/link"
+	"kubeops.dev/cluster-connector/pkg/shared"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/unrolled/render"
+	"go.wandrs.dev/binding"
+	"kubepack.dev/kubepack/pkg/lib"
+	"kubepack.dev/lib-helm/pkg/repo"
+)
+
+// ConnectorProxyAPIPath is the prefix of requests forwarded to a service of a linked cluster,
+// eg. /api/v1/connector/proxy/{linkID}/https:kubernetes.default:443/version.
+const ConnectorProxyAPIPath = "/proxy"
+
+// DefaultStaleAfter is how long a bound connector may not present its identity before another
+// cluster may claim its link. Connectors present themselves hourly by default.
+const DefaultStaleAfter = 3 * time.Hour
+
+// Server serves the hub APIs. Requests of users are authenticated by Auth, requests of
+// connectors by the signature of their key, see shared.SignRequest.
+type Server struct {
+	Store  link.LinkStore
+	Signer *link.TokenSigner
+	// Provisioner is nil if NATS users are not provisioned for links.
+	Provisioner *link.OperatorProvisioner
+	Blobs       *lib.BlobStore
+	Registry    repo.IRegistry
+	Inventory   *inventory.Service
+	// Tenants scope the NATS connection and subjects used for the cluster of a link to the tenant of its user.
+	Tenants Tenants
+	Auth    Authenticator
+	// StaleAfter is how long a bound connector may not present its identity before the hub considers it gone.
+	StaleAfter time.Duration
+}
+
+// Handler returns the routes of the hub.
+func (s *Server) Handler() http.Handler {
+	m := chi.NewRouter()
+
+	// A good base middleware stack
+	m.Use(middleware.RequestID)
+	m.Use(middleware.RealIP)
+	m.Use(middleware.Logger)
+	m.Use(middleware.Recoverer)
+	m.Use(binding.Injector(render.New()))
+
+	m.Route(shared.ConnectorAPIPathPrefix, func(r chi.Router) {
+		// connectors
+		r.With(verifyCallback, binding.JSON(shared.CallbackRequest{})).
+			Post(shared.ConnectorCallbackAPIPath, binding.HandlerFunc(s.handleCallback))
+		r.With(verifyCallback, binding.JSON(shared.CallbackRequest{})).
+			Post(shared.ConnectorConnectAPIPath, binding.HandlerFunc(s.handleConnect))
+		r.With(verifyConnector(s.Store), binding.JSON(shared.UnlinkRequest{})).
+			Post(shared.ConnectorUnlinkAPIPath, binding.HandlerFunc(s.handleUnlink))
+
+		// users
+		r.Group(func(r chi.Router) {
+			r.Use(authenticate(s.Auth))
+
+			r.With(binding.JSON(shared.LinkRequest{})).
+				Post(shared.ConnectorLinkAPIPath, binding.HandlerFunc(s.genLink))
+			r.With(binding.JSON(shared.LinkRequest{})).
+				Post(shared.ConnectorDryRunAPIPath, binding.HandlerFunc(s.dryRunLink))
+			r.With(binding.JSON(shared.RevokeRequest{})).
+				Post(shared.ConnectorRevokeAPIPath, binding.HandlerFunc(s.handleRevoke))
+
+			r.Get(shared.ConnectorClustersAPIPath, binding.HandlerFunc(s.listClusters))
+			r.Get(shared.ConnectorClustersAPIPath+"/{linkID}", binding.HandlerFunc(s.getCluster))
+
+			r.HandleFunc(ConnectorProxyAPIPath+"/{linkID}/{name}/*", binding.HandlerFunc(s.proxyCluster))
+		})
+	})
+	return m
+}
@@ -0,0 +1,63 @@
+/*
+Copyright AppsCode Inc. and Contributors
+
+Licensed under the AppsCode Community License 1.0.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://github.com/appscode/licenses/raw/1.0.0/AppsCode-Community-1.0.0.md
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package hub
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"kubeops.dev/cluster-connector/pkg/inventory"
+	"kubeops.dev/cluster-connector/pkg/link"
+	"kubeops.dev/cluster-connector/pkg/shared"
+)
+
+func TestAuthentication(t *testing.T) {
+	cases := []struct {
+		name   string
+		auth   Authenticator
+		status int
+	}{
+		{name: "none", auth: nil, status: http.StatusUnauthorized},
+		{name: "static", auth: StaticUser{Email: "user@example.com"}, status: http.StatusOK},
+	}
+	for _, c := range cases {
+		store := link.NewMemoryStore()
+		srv := &Server{
+			Store:      store,
+			Inventory:  inventory.NewService(store, DefaultStaleAfter),
+			Tenants:    SharedTenants{Subjects: *shared.NewSubjectOptions()},
+			Auth:       c.auth,
+			StaleAfter: DefaultStaleAfter,
+		}
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodGet, shared.ConnectorAPIPathPrefix+shared.ConnectorClustersAPIPath, nil)
+		srv.Handler().ServeHTTP(w, r)
+		if w.Code != c.status {
+			t.Errorf("%s: got status %d, expected %d", c.name, w.Code, c.status)
+			continue
+		}
+		if c.status != http.StatusOK {
+			continue
+		}
+		var list inventory.ClusterList
+		if err := json.Unmarshal(w.Body.Bytes(), &list); err != nil {
+			t.Errorf("%s: %v", c.name, err)
+		}
+	}
+}
@@ -0,0 +1,190 @@
+/*
+Copyright AppsCode Inc. and Contributors
+
+Licensed under the AppsCode Community License 1.0.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://github.com/appscode/licenses/raw/1.0.0/AppsCode-Community-1.0.0.md
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package hub
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"time"
+
+	httpproxy "kubeops.dev/cluster-connector/pkg/http"
+	restproxy "kubeops.dev/cluster-connector/pkg/rest"
+	"kubeops.dev/cluster-connector/pkg/shared"
+	"kubeops.dev/cluster-connector/pkg/transport"
+
+	"github.com/nats-io/nats.go"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/rest"
+	"sigs.k8s.io/yaml"
+)
+
+// tenantConnectTimeout limits connection attempts to the account of a tenant, if the hub connection options do not.
+const tenantConnectTimeout = 30 * time.Second
+
+// Tenant is the NATS scope of the clusters linked by the users of a tenant.
+type Tenant struct {
+	Name     string
+	Nats     *nats.Conn
+	Subjects shared.SubjectOptions
+}
+
+// HTTPClient returns a client for the services of the cluster of the link.
+func (t *Tenant) HTTPClient(linkID string) (*http.Client, error) {
+	return httpproxy.NewClientForLink(t.Nats, linkID, t.Subjects)
+}
+
+// RestConfig proxies config to the kube-apiserver of the cluster of the link.
+func (t *Tenant) RestConfig(config *rest.Config, linkID string) (*rest.Config, error) {
+	return restproxy.GetForLink(config, t.Nats, linkID, t.Subjects)
+}
+
+// Tenants returns the NATS scope of a tenant, see Caller.Tenant.
+type Tenants interface {
+	Tenant(ctx context.Context, name string) (*Tenant, error)
+}
+
+// SharedTenants scopes all tenants to the same NATS connection and subjects.
+type SharedTenants struct {
+	Nats     *nats.Conn
+	Subjects shared.SubjectOptions
+}
+
+var _ Tenants = SharedTenants{}
+
+func (t SharedTenants) Tenant(_ context.Context, name string) (*Tenant, error) {
+	return &Tenant{Name: name, Nats: t.Nats, Subjects: tenantSubjects(t.Subjects, name)}, nil
+}
+
+// tenantSubjects makes {{ .Tenant }} in the subject templates the name of the tenant.
+// The default tenant keeps the tenant of the hub, see SubjectOptions.Tenant.
+func tenantSubjects(subjects shared.SubjectOptions, name string) shared.SubjectOptions {
+	if name != "" {
+		subjects.Tenant = name
+	}
+	return subjects
+}
+
+// TenantsConfig maps tenants to the NATS accounts their connectors connect to.
+type TenantsConfig struct {
+	// Accounts are the credentials of the NATS accounts by name.
+	Accounts map[string]AccountConfig `json:"accounts,omitempty"`
+	// Tenants by name. If nil, all tenants use the account of the hub.
+	Tenants map[string]TenantConfig `json:"tenants,omitempty"`
+}
+
+// AccountConfig holds the credentials of a NATS account. The other connection options are those of the hub.
+type AccountConfig struct {
+	// Addr overrides the NATS server address of the hub.
+	Addr         string `json:"addr,omitempty"`
+	CredFile     string `json:"credentialFile,omitempty"`
+	JWTFile      string `json:"jwtFile,omitempty"`
+	SeedFile     string `json:"seedFile,omitempty"`
+	NkeySeedFile string `json:"nkeySeedFile,omitempty"`
+}
+
+type TenantConfig struct {
+	// Account of the tenant. Empty is the account of the hub.
+	Account string `json:"account,omitempty"`
+}
+
+// LoadTenantsConfig reads a TenantsConfig from a YAML file.
+func LoadTenantsConfig(filename string) (*TenantsConfig, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	var cfg TenantsConfig
+	if err := yaml.UnmarshalStrict(data, &cfg); err != nil {
+		return nil, fmt.Errorf("invalid tenants config %s: %w", filename, err)
+	}
+	if cfg.Tenants == nil {
+		cfg.Tenants = map[string]TenantConfig{}
+	}
+	var errs []error
+	for name, a := range cfg.Accounts {
+		if a.CredFile == "" && a.NkeySeedFile == "" && (a.JWTFile == "" || a.SeedFile == "") {
+			errs = append(errs, fmt.Errorf("account %s has no credentials", name))
+		}
+	}
+	for name, t := range cfg.Tenants {
+		if _, found := cfg.Accounts[t.Account]; t.Account != "" && !found {
+			errs = append(errs, fmt.Errorf("tenant %s uses unknown account %s", name, t.Account))
+		}
+	}
+	return &cfg, errors.Join(errs...)
+}
+
+// PooledTenants scopes tenants to their NATS accounts, with one connection per account.
+type PooledTenants struct {
+	config   TenantsConfig
+	subjects shared.SubjectOptions
+	// Pool holds the connections by account name, the account of the hub is "".
+	Pool *transport.ConnectionPool
+}
+
+var _ Tenants = &PooledTenants{}
+
+// NewPooledTenants connects to the accounts with the options of the hub connection and the credentials of the account.
+func NewPooledTenants(config TenantsConfig, hub transport.ConnectionOptions, subjects shared.SubjectOptions) *PooledTenants {
+	if hub.ConnectTimeout == 0 {
+		hub.ConnectTimeout = tenantConnectTimeout
+	}
+	return &PooledTenants{
+		config:   config,
+		subjects: subjects,
+		Pool: transport.NewConnectionPool(func(account string) (*transport.ConnectionOptions, error) {
+			o := hub
+			if account == "" {
+				return &o, nil
+			}
+			a, found := config.Accounts[account]
+			if !found {
+				return nil, fmt.Errorf("unknown NATS account %s", account)
+			}
+			if a.Addr != "" {
+				o.Addr = a.Addr
+			}
+			if o.Name != "" {
+				o.Name += "-" + account
+			}
+			o.CredFile, o.JWTFile, o.SeedFile, o.NkeySeedFile = a.CredFile, a.JWTFile, a.SeedFile, a.NkeySeedFile
+			o.Token, o.Username, o.Password = "", "", ""
+			return &o, o.Validate()
+		}),
+	}
+}
+
+// Tenant returns the connection of the account of the tenant. Tenants missing from
+// the config are forbidden, unless no tenants are configured.
+func (t *PooledTenants) Tenant(ctx context.Context, name string) (*Tenant, error) {
+	var tc TenantConfig
+	if t.config.Tenants != nil && name != "" {
+		var found bool
+		if tc, found = t.config.Tenants[name]; !found {
+			return nil, apierrors.NewForbidden(schema.GroupResource{Resource: "tenants"}, name, errors.New("tenant is not configured"))
+		}
+	}
+	nc, err := t.Pool.Get(ctx, tc.Account)
+	if err != nil {
+		return nil, err
+	}
+	return &Tenant{Name: name, Nats: nc, Subjects: tenantSubjects(t.subjects, name)}, nil
+}
@@ -0,0 +1,88 @@
+/*
+Copyright AppsCode Inc. and Contributors
+
+Licensed under the AppsCode Community License 1.0.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://github.com/appscode/licenses/raw/1.0.0/AppsCode-Community-1.0.0.md
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package hub
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"kubeops.dev/cluster-connector/pkg/shared"
+	"kubeops.dev/cluster-connector/pkg/transport"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+)
+
+func TestLoadTenantsConfig(t *testing.T) {
+	cases := []struct {
+		name    string
+		config  string
+		wantErr bool
+	}{
+		{
+			name: "valid",
+			config: `
+accounts:
+  acme:
+    credentialFile: /etc/hub/acme.creds
+tenants:
+  acme:
+    account: acme
+  beta: {}
+`,
+		},
+		{name: "unknown account", config: "tenants:\n  acme:\n    account: acme\n", wantErr: true},
+		{name: "no credentials", config: "accounts:\n  acme:\n    addr: nats://acme:4222\n", wantErr: true},
+		{name: "unknown field", config: "tenants:\n  acme:\n    acount: acme\n", wantErr: true},
+	}
+	for _, c := range cases {
+		filename := filepath.Join(t.TempDir(), "tenants.yaml")
+		if err := os.WriteFile(filename, []byte(c.config), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		_, err := LoadTenantsConfig(filename)
+		if (err != nil) != c.wantErr {
+			t.Errorf("%s: got error %v, expected error %v", c.name, err, c.wantErr)
+		}
+	}
+}
+
+func TestPooledTenantsUnknownTenant(t *testing.T) {
+	tenants := NewPooledTenants(TenantsConfig{Tenants: map[string]TenantConfig{"acme": {}}}, *transport.NewConnectionOptions(), *shared.NewSubjectOptions())
+	defer tenants.Pool.Close() // nolint:errcheck
+
+	if _, err := tenants.Tenant(context.Background(), "beta"); !apierrors.IsForbidden(err) {
+		t.Errorf("got %v, expected forbidden", err)
+	}
+}
+
+func TestTenantSubjects(t *testing.T) {
+	subjects := *shared.NewSubjectOptions()
+	subjects.Tenant = "hub"
+	tenants := SharedTenants{Subjects: subjects}
+
+	for name, expected := range map[string]string{"": "hub", "acme": "acme"} {
+		tenant, err := tenants.Tenant(context.Background(), name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if tenant.Subjects.Tenant != expected {
+			t.Errorf("%q: got subject tenant %q, expected %q", name, tenant.Subjects.Tenant, expected)
+		}
+	}
+}
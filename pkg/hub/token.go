@@ -0,0 +1,99 @@
+/*
+Copyright AppsCode Inc. and Contributors
+
+Licensed under the AppsCode Community License 1.0.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://github.com/appscode/licenses/raw/1.0.0/AppsCode-Community-1.0.0.md
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package hub
+
+import (
+	"crypto/sha256"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+
+	"kubeops.dev/cluster-connector/pkg/shared"
+)
+
+// TokenAuthenticator authenticates requests by static API tokens sent as bearer tokens.
+type TokenAuthenticator struct {
+	// callers by the sha256 of their token, so that lookups do not leak the tokens by timing
+	callers map[[sha256.Size]byte]Caller
+}
+
+var _ Authenticator = &TokenAuthenticator{}
+
+// NewTokenAuthenticator reads the tokens from a csv file with the columns token, email, name and tenant.
+// Name and tenant are optional. Lines starting with # are ignored.
+func NewTokenAuthenticator(filename string) (*TokenAuthenticator, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close() // nolint:errcheck
+
+	cr := csv.NewReader(f)
+	cr.Comment = '#'
+	cr.FieldsPerRecord = -1
+	cr.TrimLeadingSpace = true
+
+	a := &TokenAuthenticator{callers: map[[sha256.Size]byte]Caller{}}
+	for {
+		record, err := cr.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+		line, _ := cr.FieldPos(0)
+		if len(record) < 2 || len(record) > 4 {
+			return nil, fmt.Errorf("%s:%d: expected token, email, name and tenant", filename, line)
+		}
+		for len(record) < 4 {
+			record = append(record, "")
+		}
+		token, email := strings.TrimSpace(record[0]), strings.TrimSpace(record[1])
+		if token == "" || email == "" {
+			return nil, fmt.Errorf("%s:%d: token and email are required", filename, line)
+		}
+		key := sha256.Sum256([]byte(token))
+		if _, found := a.callers[key]; found {
+			return nil, fmt.Errorf("%s:%d: duplicate token", filename, line)
+		}
+		a.callers[key] = Caller{
+			User: shared.User{
+				Name:  strings.TrimSpace(record[2]),
+				Email: email,
+			},
+			Tenant: strings.TrimSpace(record[3]),
+		}
+	}
+	return a, nil
+}
+
+// Authenticate returns nil for unknown tokens, so that they may be checked by other authenticators.
+func (a *TokenAuthenticator) Authenticate(r *http.Request) (*Caller, error) {
+	token := bearerToken(r)
+	if token == "" {
+		return nil, nil
+	}
+	c, found := a.callers[sha256.Sum256([]byte(token))]
+	if !found {
+		return nil, nil
+	}
+	return &c, nil
+}
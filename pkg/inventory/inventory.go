@@ -0,0 +1,260 @@
+/*
+Copyright AppsCode Inc. and Contributors
+
+Licensed under the AppsCode Community License 1.0.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://github.com/appscode/licenses/raw/1.0.0/AppsCode-Community-1.0.0.md
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package inventory lists the clusters linked to the hub, for consoles and billing.
+package inventory
+
+import (
+	"context"
+	"encoding/base64"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"kubeops.dev/cluster-connector/pkg/link"
+	"kubeops.dev/cluster-connector/pkg/shared"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	DefaultLimit = 100
+	MaxLimit     = 1000
+)
+
+type State string
+
+const (
+	// StateOnline clusters presented their connector identity recently, see Service.StaleAfter.
+	StateOnline  State = "Online"
+	StateOffline State = "Offline"
+	StateRevoked State = "Revoked"
+)
+
+// Cluster is a cluster linked to the hub.
+type Cluster struct {
+	LinkID           string              `json:"linkID"`
+	ClusterID        string              `json:"clusterID"`
+	Owner            shared.User         `json:"owner"`
+	Tenant           string              `json:"tenant,omitempty"`
+	Metadata         *shared.ClusterInfo `json:"metadata,omitempty"`
+	State            State               `json:"state"`
+	ConnectorVersion string              `json:"connectorVersion,omitempty"`
+	LinkedAt         *time.Time          `json:"linkedAt,omitempty"`
+	LastSeenAt       *time.Time          `json:"lastSeenAt,omitempty"`
+	// LastConflict is the last connector rejected for the link, see link.CheckConnector.
+	LastConflict *shared.LinkConflict `json:"lastConflict,omitempty"`
+}
+
+// ClusterList is a page of clusters. Continue is empty on the last page.
+type ClusterList struct {
+	Items    []Cluster `json:"items"`
+	Continue string    `json:"continue,omitempty"`
+}
+
+// Query selects the clusters to list. Empty fields match all clusters.
+type Query struct {
+	// Owner is the email of the user who generated the link, compared case-insensitively.
+	Owner string
+	// Tenant of the owner, if not nil. It can only be set by the hub, not by ParseQuery.
+	Tenant *string
+	State  State
+	// Search matches clusters whose link id, cluster id, name, display name, provider
+	// or owner contain it, compared case-insensitively.
+	Search string
+	// Limit is the maximum number of clusters returned, DefaultLimit if zero.
+	Limit int
+	// Continue is the token returned with the previous page.
+	Continue string
+}
+
+// ParseQuery reads a query from the url parameters owner, state, search, limit and continue.
+func ParseQuery(values url.Values) (Query, error) {
+	q := Query{
+		Owner:    values.Get("owner"),
+		State:    State(values.Get("state")),
+		Search:   values.Get("search"),
+		Continue: values.Get("continue"),
+	}
+	switch q.State {
+	case "", StateOnline, StateOffline, StateRevoked:
+	default:
+		return Query{}, errors.Errorf("invalid state %q, expected one of %s, %s or %s", q.State, StateOnline, StateOffline, StateRevoked)
+	}
+	if s := values.Get("limit"); s != "" {
+		limit, err := strconv.Atoi(s)
+		if err != nil || limit < 1 || limit > MaxLimit {
+			return Query{}, errors.Errorf("invalid limit %q, expected a number between 1 and %d", s, MaxLimit)
+		}
+		q.Limit = limit
+	}
+	if _, err := decodeContinue(q.Continue); err != nil {
+		return Query{}, err
+	}
+	return q, nil
+}
+
+// Service lists the linked clusters recorded in a link store.
+type Service struct {
+	store link.LinkStore
+	// StaleAfter is how long a connector may not present its identity before its cluster is offline.
+	StaleAfter time.Duration
+	now        func() time.Time
+}
+
+func NewService(store link.LinkStore, staleAfter time.Duration) *Service {
+	return &Service{
+		store:      store,
+		StaleAfter: staleAfter,
+		now:        time.Now,
+	}
+}
+
+// List returns the clusters matching q, ordered by link creation time and link id.
+func (s *Service) List(ctx context.Context, q Query) (*ClusterList, error) {
+	after, err := decodeContinue(q.Continue)
+	if err != nil {
+		return nil, err
+	}
+	limit := q.Limit
+	if limit <= 0 || limit > MaxLimit {
+		limit = DefaultLimit
+	}
+
+	links, err := s.store.ListClusters(ctx)
+	if err != nil {
+		return nil, err
+	}
+	sort.SliceStable(links, func(i, j int) bool {
+		return less(links[i], links[j])
+	})
+
+	now := s.now()
+	result := &ClusterList{Items: []Cluster{}}
+	var last shared.LinkData
+	for _, l := range links {
+		if after != nil && !less(*after, l) {
+			continue
+		}
+		c := s.toCluster(l, now)
+		if !q.matches(c) {
+			continue
+		}
+		if len(result.Items) == limit {
+			result.Continue = encodeContinue(last)
+			break
+		}
+		result.Items = append(result.Items, c)
+		last = l
+	}
+	return result, nil
+}
+
+// Get returns the cluster of a link. link.ErrNotFound is returned if no connector was seen for the link.
+func (s *Service) Get(ctx context.Context, linkID string) (*Cluster, error) {
+	l, err := s.store.Get(ctx, linkID)
+	if err != nil {
+		return nil, err
+	}
+	if l.LastSeenAt == nil {
+		return nil, errors.Wrapf(link.ErrNotFound, "no cluster is linked with %s", linkID)
+	}
+	c := s.toCluster(*l, s.now())
+	return &c, nil
+}
+
+func (s *Service) toCluster(l shared.LinkData, now time.Time) Cluster {
+	c := Cluster{
+		LinkID:           l.LinkID,
+		ClusterID:        l.ClusterID,
+		Owner:            l.User,
+		Tenant:           l.Tenant,
+		Metadata:         l.Cluster,
+		ConnectorVersion: l.ConnectorVersion,
+		LinkedAt:         l.UsedAt,
+		LastSeenAt:       l.LastSeenAt,
+		LastConflict:     l.LastConflict,
+	}
+	switch {
+	case l.RevokedAt != nil:
+		c.State = StateRevoked
+	case l.LastSeenAt != nil && now.Sub(*l.LastSeenAt) <= s.StaleAfter:
+		c.State = StateOnline
+	default:
+		c.State = StateOffline
+	}
+	return c
+}
+
+func (q Query) matches(c Cluster) bool {
+	if q.Owner != "" && !strings.EqualFold(q.Owner, c.Owner.Email) {
+		return false
+	}
+	if q.Tenant != nil && *q.Tenant != c.Tenant {
+		return false
+	}
+	if q.State != "" && q.State != c.State {
+		return false
+	}
+	if q.Search == "" {
+		return true
+	}
+	fields := []string{c.LinkID, c.ClusterID, c.Owner.Name, c.Owner.Email}
+	if c.Metadata != nil {
+		fields = append(fields, c.Metadata.Name, c.Metadata.DisplayName, c.Metadata.Provider)
+	}
+	search := strings.ToLower(q.Search)
+	for _, f := range fields {
+		if strings.Contains(strings.ToLower(f), search) {
+			return true
+		}
+	}
+	return false
+}
+
+// less orders links by creation time and link id, so that pages stay stable while links are added.
+func less(a, b shared.LinkData) bool {
+	if !a.CreatedAt.Equal(b.CreatedAt) {
+		return a.CreatedAt.Before(b.CreatedAt)
+	}
+	return a.LinkID < b.LinkID
+}
+
+// The continue token is the creation time and id of the last link of the previous page.
+func encodeContinue(l shared.LinkData) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(strconv.FormatInt(l.CreatedAt.UnixNano(), 10) + "/" + l.LinkID))
+}
+
+func decodeContinue(token string) (*shared.LinkData, error) {
+	if token == "" {
+		return nil, nil
+	}
+	data, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return nil, errors.New("invalid continue token")
+	}
+	ts, id, found := strings.Cut(string(data), "/")
+	if !found {
+		return nil, errors.New("invalid continue token")
+	}
+	nanos, err := strconv.ParseInt(ts, 10, 64)
+	if err != nil {
+		return nil, errors.New("invalid continue token")
+	}
+	return &shared.LinkData{LinkID: id, CreatedAt: time.Unix(0, nanos).UTC()}, nil
+}
@@ -0,0 +1,172 @@
+/*
+Copyright AppsCode Inc. and Contributors
+
+Licensed under the AppsCode Community License 1.0.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://github.com/appscode/licenses/raw/1.0.0/AppsCode-Community-1.0.0.md
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package inventory
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"reflect"
+	"testing"
+	"time"
+
+	"kubeops.dev/cluster-connector/pkg/link"
+	"kubeops.dev/cluster-connector/pkg/shared"
+)
+
+func TestService(t *testing.T) {
+	ctx := context.Background()
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	alice := shared.User{Name: "alice", Email: "alice@example.com"}
+	bob := shared.User{Name: "bob", Email: "bob@example.com"}
+
+	store := link.NewMemoryStore()
+	for i, c := range []struct {
+		owner    shared.User
+		tenant   string
+		name     string
+		lastSeen time.Duration
+		revoked  bool
+	}{
+		{alice, "", "prod", time.Minute, false},
+		{alice, "", "staging", 2 * time.Hour, false},
+		{bob, "acme", "prod-eu", time.Minute, false},
+		{bob, "", "dev", time.Minute, true},
+	} {
+		id := fmt.Sprintf("l%d", i)
+		if err := store.Create(ctx, &shared.LinkData{
+			LinkID:    id,
+			User:      c.owner,
+			Tenant:    c.tenant,
+			CreatedAt: now.Add(time.Duration(i) * time.Second),
+			NotAfter:  now.Add(time.Hour),
+		}); err != nil {
+			t.Fatal(err)
+		}
+		if err := store.BindCluster(ctx, id, "cluster-"+c.name, "key"); err != nil {
+			t.Fatal(err)
+		}
+		if err := store.MarkSeen(ctx, shared.CallbackRequest{
+			LinkID:  id,
+			Version: "v0.1.0",
+			Cluster: &shared.ClusterInfo{Name: c.name, Provider: "AWS"},
+		}, now.Add(-c.lastSeen)); err != nil {
+			t.Fatal(err)
+		}
+		if c.revoked {
+			if err := store.Revoke(ctx, id, now); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	// links without a connector are not in the inventory
+	if err := store.Create(ctx, &shared.LinkData{LinkID: "unused", User: alice, CreatedAt: now}); err != nil {
+		t.Fatal(err)
+	}
+
+	svc := NewService(store, time.Hour)
+	svc.now = func() time.Time { return now }
+
+	cases := []struct {
+		name     string
+		query    string
+		expected []string
+	}{
+		{"all", "", []string{"l0", "l1", "l2", "l3"}},
+		{"owner", "owner=Alice@example.com", []string{"l0", "l1"}},
+		{"online", "state=Online", []string{"l0", "l2"}},
+		{"offline", "state=Offline", []string{"l1"}},
+		{"revoked", "state=Revoked", []string{"l3"}},
+		{"search", "search=PROD", []string{"l0", "l2"}},
+		{"search owner", "search=bob", []string{"l2", "l3"}},
+		{"owner and search", "owner=bob@example.com&search=prod", []string{"l2"}},
+	}
+	for _, c := range cases {
+		values, _ := url.ParseQuery(c.query)
+		q, err := ParseQuery(values)
+		if err != nil {
+			t.Errorf("%s: %v", c.name, err)
+			continue
+		}
+		list, err := svc.List(ctx, q)
+		if err != nil {
+			t.Errorf("%s: %v", c.name, err)
+			continue
+		}
+		if ids := linkIDs(list); !reflect.DeepEqual(ids, c.expected) {
+			t.Errorf("%s: got %v, expected %v", c.name, ids, c.expected)
+		}
+	}
+
+	// tenants are set by the hub, not by the query parameters
+	for tenant, expected := range map[string][]string{"": {"l0", "l1", "l3"}, "acme": {"l2"}} {
+		list, err := svc.List(ctx, Query{Tenant: &tenant})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ids := linkIDs(list); !reflect.DeepEqual(ids, expected) {
+			t.Errorf("tenant %q: got %v, expected %v", tenant, ids, expected)
+		}
+	}
+
+	// pages
+	var ids []string
+	q := Query{Limit: 3}
+	for i := 0; i < 3; i++ {
+		list, err := svc.List(ctx, q)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, linkIDs(list)...)
+		if list.Continue == "" {
+			break
+		}
+		q.Continue = list.Continue
+	}
+	if expected := []string{"l0", "l1", "l2", "l3"}; !reflect.DeepEqual(ids, expected) {
+		t.Errorf("pages: got %v, expected %v", ids, expected)
+	}
+
+	cl, err := svc.Get(ctx, "l0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cl.ClusterID != "cluster-prod" || cl.State != StateOnline || cl.ConnectorVersion != "v0.1.0" || cl.Metadata.Name != "prod" {
+		t.Errorf("got cluster %+v, expected prod online", cl)
+	}
+	if _, err := svc.Get(ctx, "unused"); !errors.Is(err, link.ErrNotFound) {
+		t.Errorf("get unused link: got %v, expected %v", err, link.ErrNotFound)
+	}
+}
+
+func TestParseQuery(t *testing.T) {
+	for _, query := range []string{"state=Unknown", "limit=0", "limit=1001", "limit=x", "continue=%25"} {
+		values, _ := url.ParseQuery(query)
+		if _, err := ParseQuery(values); err == nil {
+			t.Errorf("%s: expected error", query)
+		}
+	}
+}
+
+func linkIDs(list *ClusterList) []string {
+	var ids []string
+	for _, c := range list.Items {
+		ids = append(ids, c.LinkID)
+	}
+	return ids
+}
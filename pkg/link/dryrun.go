@@ -0,0 +1,293 @@
+/*
+Copyright AppsCode Inc. and Contributors
+
+Licensed under the AppsCode Community License 1.0.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://github.com/appscode/licenses/raw/1.0.0/AppsCode-Community-1.0.0.md
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package link
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"sort"
+
+	"github.com/pkg/errors"
+	"github.com/pmezard/go-difflib/difflib"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/types"
+	utilyaml "k8s.io/apimachinery/pkg/util/yaml"
+	"kubepack.dev/lib-helm/pkg/repo"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/yaml"
+	releasesapi "x-helm.dev/apimachinery/apis/releases/v1alpha1"
+)
+
+// DryRunResult is what the install scripts of a link would create.
+type DryRunResult struct {
+	LinkID       string `json:"linkID"`
+	ChartVersion string `json:"chartVersion"`
+	// Manifest is the rendered manifest bundle, see FormatManifest.
+	Manifest string                      `json:"manifest"`
+	Objects  []unstructured.Unstructured `json:"objects"`
+	// Diff against the objects currently in the target cluster, if any were given.
+	Diff []ObjectDiff `json:"diff,omitempty"`
+}
+
+// DryRun renders the objects the install scripts of a link would create, without
+// uploading anything to the blob store or recording the link.
+func DryRun(kc client.Client, reg repo.IRegistry, opts InstallOptions) (*DryRunResult, error) {
+	order, err := NewOrder(kc, opts)
+	if err != nil {
+		return nil, err
+	}
+	return DryRunOrder(reg, order)
+}
+
+// DryRunOrder renders the objects of an order, see DryRun.
+func DryRunOrder(reg repo.IRegistry, order *releasesapi.Order) (*DryRunResult, error) {
+	pkg, err := orderChart(order)
+	if err != nil {
+		return nil, err
+	}
+	manifest, version, err := RenderManifests(reg, pkg)
+	if err != nil {
+		return nil, err
+	}
+	objs, err := ParseObjects([]byte(manifest))
+	if err != nil {
+		return nil, err
+	}
+	return &DryRunResult{
+		LinkID:       string(order.UID),
+		ChartVersion: version,
+		Manifest:     manifest,
+		Objects:      objs,
+	}, nil
+}
+
+// ParseObjects decodes the objects of a multi document yaml or json manifest. Empty documents are skipped.
+func ParseObjects(manifest []byte) ([]unstructured.Unstructured, error) {
+	var result []unstructured.Unstructured
+	decoder := utilyaml.NewYAMLOrJSONDecoder(bytes.NewReader(manifest), 4096)
+	for {
+		var obj map[string]any
+		if err := decoder.Decode(&obj); err == io.EOF {
+			return result, nil
+		} else if err != nil {
+			return nil, errors.Wrap(err, "failed to decode manifest")
+		}
+		if len(obj) == 0 {
+			continue
+		}
+		u := unstructured.Unstructured{Object: obj}
+		if u.GetKind() == "" || u.GetName() == "" {
+			return nil, errors.Errorf("manifest contains an object without kind or name: %v", obj)
+		}
+		result = append(result, u)
+	}
+}
+
+// CurrentObjects returns the objects of the target cluster with the same kind and key as the
+// desired objects. Objects that do not exist in the cluster are skipped. Clients read them
+// with their own credentials and send them to the hub as shared.LinkRequest.Current.
+func CurrentObjects(ctx context.Context, kc client.Client, desired []unstructured.Unstructured) ([]unstructured.Unstructured, error) {
+	var result []unstructured.Unstructured
+	for _, obj := range desired {
+		var cur unstructured.Unstructured
+		cur.SetGroupVersionKind(obj.GroupVersionKind())
+		err := kc.Get(ctx, types.NamespacedName{Namespace: obj.GetNamespace(), Name: obj.GetName()}, &cur)
+		if apierrors.IsNotFound(err) {
+			continue
+		} else if err != nil {
+			return nil, errors.Wrapf(err, "failed to get %s %s", obj.GetKind(), client.ObjectKeyFromObject(&obj))
+		}
+		result = append(result, cur)
+	}
+	return result, nil
+}
+
+type DiffAction string
+
+const (
+	DiffCreate    DiffAction = "Create"
+	DiffUpdate    DiffAction = "Update"
+	DiffDelete    DiffAction = "Delete"
+	DiffUnchanged DiffAction = "Unchanged"
+)
+
+// ObjectDiff is the change of one object needed to upgrade a cluster.
+type ObjectDiff struct {
+	APIVersion string     `json:"apiVersion"`
+	Kind       string     `json:"kind"`
+	Namespace  string     `json:"namespace,omitempty"`
+	Name       string     `json:"name"`
+	Action     DiffAction `json:"action"`
+	// Diff is the unified diff of the yaml of the object, empty for unchanged objects.
+	Diff string `json:"diff,omitempty"`
+}
+
+type objectKey struct {
+	group     string
+	kind      string
+	namespace string
+	name      string
+}
+
+func keyOf(obj unstructured.Unstructured) objectKey {
+	return objectKey{
+		group:     obj.GroupVersionKind().Group,
+		kind:      obj.GetKind(),
+		namespace: obj.GetNamespace(),
+		name:      obj.GetName(),
+	}
+}
+
+// Diff compares the desired objects with the current objects of a cluster. Current objects
+// that are not desired are deleted. Fields that are not set in the desired object, like
+// status, server side defaults and most of the metadata, are ignored.
+// The result is sorted by kind, namespace and name.
+func Diff(desired, current []unstructured.Unstructured) ([]ObjectDiff, error) {
+	cur := make(map[objectKey]unstructured.Unstructured, len(current))
+	for _, obj := range current {
+		cur[keyOf(obj)] = obj
+	}
+
+	result := make([]ObjectDiff, 0, len(desired))
+	seen := map[objectKey]bool{}
+	for _, obj := range desired {
+		key := keyOf(obj)
+		seen[key] = true
+		d := ObjectDiff{
+			APIVersion: obj.GetAPIVersion(),
+			Kind:       obj.GetKind(),
+			Namespace:  obj.GetNamespace(),
+			Name:       obj.GetName(),
+		}
+
+		old, found := cur[key]
+		var from map[string]any
+		if found {
+			from = prune(old.Object, obj.Object).(map[string]any)
+		}
+		text, err := diffYAML(d, from, obj.Object)
+		if err != nil {
+			return nil, err
+		}
+		switch {
+		case !found:
+			d.Action = DiffCreate
+		case text == "":
+			d.Action = DiffUnchanged
+		default:
+			d.Action = DiffUpdate
+		}
+		d.Diff = text
+		result = append(result, d)
+	}
+	for _, obj := range current {
+		if seen[keyOf(obj)] {
+			continue
+		}
+		d := ObjectDiff{
+			APIVersion: obj.GetAPIVersion(),
+			Kind:       obj.GetKind(),
+			Namespace:  obj.GetNamespace(),
+			Name:       obj.GetName(),
+			Action:     DiffDelete,
+		}
+		text, err := diffYAML(d, obj.Object, nil)
+		if err != nil {
+			return nil, err
+		}
+		d.Diff = text
+		result = append(result, d)
+	}
+
+	sort.SliceStable(result, func(i, j int) bool {
+		a, b := result[i], result[j]
+		if a.Kind != b.Kind {
+			return a.Kind < b.Kind
+		}
+		if a.Namespace != b.Namespace {
+			return a.Namespace < b.Namespace
+		}
+		return a.Name < b.Name
+	})
+	return result, nil
+}
+
+// prune returns the parts of cur that are set in desired. Lists are compared as a whole,
+// except lists of the same length, whose items are pruned one by one.
+func prune(cur, desired any) any {
+	switch d := desired.(type) {
+	case map[string]any:
+		c, ok := cur.(map[string]any)
+		if !ok {
+			return cur
+		}
+		result := make(map[string]any, len(d))
+		for k, v := range d {
+			if cv, found := c[k]; found {
+				result[k] = prune(cv, v)
+			}
+		}
+		return result
+	case []any:
+		c, ok := cur.([]any)
+		if !ok || len(c) != len(d) {
+			return cur
+		}
+		result := make([]any, len(c))
+		for i := range c {
+			result[i] = prune(c[i], d[i])
+		}
+		return result
+	default:
+		return cur
+	}
+}
+
+func diffYAML(d ObjectDiff, from, to map[string]any) (string, error) {
+	name := d.Kind + " " + d.Name
+	if d.Namespace != "" {
+		name = d.Kind + " " + d.Namespace + "/" + d.Name
+	}
+	a, err := toDiffLines(from)
+	if err != nil {
+		return "", err
+	}
+	b, err := toDiffLines(to)
+	if err != nil {
+		return "", err
+	}
+	return difflib.GetUnifiedDiffString(difflib.UnifiedDiff{
+		A:        a,
+		B:        b,
+		FromFile: "current/" + name,
+		ToFile:   "desired/" + name,
+		Context:  3,
+	})
+}
+
+func toDiffLines(obj map[string]any) ([]string, error) {
+	if obj == nil {
+		return nil, nil
+	}
+	data, err := yaml.Marshal(obj)
+	if err != nil {
+		return nil, err
+	}
+	return difflib.SplitLines(string(data)), nil
+}
@@ -0,0 +1,131 @@
+/*
+Copyright AppsCode Inc. and Contributors
+
+Licensed under the AppsCode Community License 1.0.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://github.com/appscode/licenses/raw/1.0.0/AppsCode-Community-1.0.0.md
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package link
+
+import (
+	"strings"
+	"testing"
+
+	kubeops "kubeops.dev/installer/apis/installer/v1alpha1"
+)
+
+func TestDryRun(t *testing.T) {
+	result, err := DryRun(nil, testRegistry{}, InstallOptions{
+		Namespace: "connector",
+		Spec:      kubeops.ClusterConnectorSpec{LinkID: "link1", ReplicaCount: 2},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.LinkID != "link1" || result.ChartVersion != "v2024.1.31" {
+		t.Errorf("got link %s and chart version %s, expected link1 and v2024.1.31", result.LinkID, result.ChartVersion)
+	}
+	var kinds []string
+	for _, obj := range result.Objects {
+		kinds = append(kinds, obj.GetKind()+"/"+obj.GetNamespace()+"/"+obj.GetName())
+	}
+	if strings.Join(kinds, ",") != "Namespace//connector,Deployment/connector/cluster-connector" {
+		t.Fatalf("got objects %v, expected namespace and deployment", kinds)
+	}
+
+	current, err := ParseObjects([]byte(`
+apiVersion: v1
+kind: Namespace
+metadata:
+  name: connector
+  uid: 5f1c7a9e
+  resourceVersion: "42"
+status:
+  phase: Active
+---
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: cluster-connector
+  namespace: connector
+  generation: 3
+spec:
+  replicas: 1
+  revisionHistoryLimit: 10
+  template:
+    spec:
+      containers:
+      - name: connector
+        args:
+        - --link-id=link0
+        - --nats-addr=
+        imagePullPolicy: IfNotPresent
+---
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: obsolete
+  namespace: connector
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	diff, err := Diff(result.Objects, current)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []struct {
+		kind   string
+		action DiffAction
+	}{
+		{"ConfigMap", DiffDelete},
+		{"Deployment", DiffUpdate},
+		{"Namespace", DiffUnchanged},
+	}
+	if len(diff) != len(expected) {
+		t.Fatalf("got %d diffs, expected %d", len(diff), len(expected))
+	}
+	for i, e := range expected {
+		if diff[i].Kind != e.kind || diff[i].Action != e.action {
+			t.Errorf("diff %d: got %s %s, expected %s %s", i, diff[i].Action, diff[i].Kind, e.action, e.kind)
+		}
+	}
+
+	update := diff[1].Diff
+	for _, s := range []string{
+		"--- current/Deployment connector/cluster-connector",
+		"-  replicas: 1",
+		"+  replicas: 2",
+		"-        - --link-id=link0",
+		"+        - --link-id=link1",
+	} {
+		if !strings.Contains(update, s) {
+			t.Errorf("update diff does not contain %q:\n%s", s, update)
+		}
+	}
+	for _, s := range []string{"revisionHistoryLimit", "imagePullPolicy", "generation"} {
+		if strings.Contains(update, s) {
+			t.Errorf("update diff contains field %s not set by the chart:\n%s", s, update)
+		}
+	}
+
+	diff, err = Diff(result.Objects, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, d := range diff {
+		if d.Action != DiffCreate || !strings.Contains(d.Diff, "+kind: "+d.Kind) {
+			t.Errorf("got %s %s with diff %q, expected it created", d.Action, d.Kind, d.Diff)
+		}
+	}
+}
@@ -0,0 +1,457 @@
+/*
+Copyright AppsCode Inc. and Contributors
+
+Licensed under the AppsCode Community License 1.0.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://github.com/appscode/licenses/raw/1.0.0/AppsCode-Community-1.0.0.md
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package link
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/Masterminds/semver/v3"
+	jsonpatch "github.com/evanphx/json-patch/v5"
+	"helm.sh/helm/v3/pkg/chartutil"
+	"helm.sh/helm/v3/pkg/release"
+	"k8s.io/apimachinery/pkg/runtime"
+	"kubepack.dev/kubepack/pkg/lib"
+	libchart "kubepack.dev/lib-helm/pkg/chart"
+	"kubepack.dev/lib-helm/pkg/repo"
+	"sigs.k8s.io/yaml"
+	"x-helm.dev/apimachinery/apis"
+	releasesapi "x-helm.dev/apimachinery/apis/releases/v1alpha1"
+)
+
+// Install formats offered for a link, keys of shared.Link.Scripts.
+const (
+	FormatYAML  = "yaml"
+	FormatHelm3 = "helm3"
+	// FormatManifest is the rendered manifest bundle that can be applied or committed as is.
+	FormatManifest = "manifest"
+	// FormatKustomize is a kustomization that uses the manifest bundle as its resource.
+	FormatKustomize = "kustomize"
+	// FormatFluxHelmRelease is a Flux HelmRepository and HelmRelease installing the chart.
+	FormatFluxHelmRelease = "flux"
+	// FormatArgoCDApplication is an Argo CD Application installing the chart.
+	FormatArgoCDApplication = "argocd"
+	// FormatFluxCredentials is the Secret with the NATS credentials the Flux HelmRelease
+	// reads its values from. It must be applied to the cluster, not committed to git.
+	FormatFluxCredentials = "flux-credentials"
+)
+
+const (
+	fluxNamespace   = "flux-system"
+	argoCDNamespace = "argocd"
+)
+
+// natsCredsUnavailable is the reason the formats that can only carry the NATS credentials
+// in their chart values are not available for orders with credentials.
+const natsCredsUnavailable = "the NATS credentials of the connector would end up in git or the public blob store, use the flux and flux-credentials formats or an install script"
+
+// generateGitOps returns the install formats that do not need to run a script, and the reason
+// for each format that is not available. All of them are built from the chart and values patch
+// of the order. GitOps formats end up in git repositories and the manifest bundle is uploaded
+// to the public blob store, so none of them carry the NATS credentials of the connector.
+// If the order has credentials, Flux reads them from the Secret of FormatFluxCredentials.
+// The manifest bundle, kustomization and Argo CD Application can not reference a Secret,
+// so they are not available then and the bundle is never uploaded.
+func generateGitOps(bs *lib.BlobStore, reg repo.IRegistry, order *releasesapi.Order) (map[string]string, map[string]string, error) {
+	pkg, err := orderChart(order)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	manifest, version, err := RenderManifests(reg, pkg)
+	if err != nil {
+		return nil, nil, err
+	}
+	values, err := valuesFromPatch(pkg.ValuesPatch)
+	if err != nil {
+		return nil, nil, err
+	}
+	creds := popNatsCreds(values)
+	repoURL, err := chartRepositoryURL(reg, pkg)
+	if err != nil {
+		return nil, nil, err
+	}
+	if creds != "" {
+		secret := pkg.ReleaseName + "-nats-credentials"
+		flux, err := fluxHelmRelease(pkg, repoURL, version, values, secret)
+		if err != nil {
+			return nil, nil, err
+		}
+		credentials, err := fluxCredentialsSecret(secret, creds)
+		if err != nil {
+			return nil, nil, err
+		}
+		unavailable := map[string]string{
+			FormatManifest:          natsCredsUnavailable,
+			FormatKustomize:         natsCredsUnavailable,
+			FormatArgoCDApplication: natsCredsUnavailable,
+		}
+		return map[string]string{
+			FormatFluxHelmRelease: flux,
+			FormatFluxCredentials: credentials,
+		}, unavailable, nil
+	}
+
+	manifestPath := path.Join(string(order.UID), "manifests", pkg.ReleaseName+"-bundle.yaml")
+	if err := bs.WriteFile(context.TODO(), manifestPath, []byte(manifest)); err != nil {
+		return nil, nil, err
+	}
+	kustomization, err := toYAML(map[string]any{
+		"apiVersion": "kustomize.config.k8s.io/v1beta1",
+		"kind":       "Kustomization",
+		"resources":  []string{bs.Host + "/" + manifestPath},
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	flux, err := fluxHelmRelease(pkg, repoURL, version, values, "")
+	if err != nil {
+		return nil, nil, err
+	}
+	argocd, err := argoCDApplication(pkg, repoURL, version, values)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return map[string]string{
+		FormatManifest:          manifest,
+		FormatKustomize:         kustomization,
+		FormatFluxHelmRelease:   flux,
+		FormatArgoCDApplication: argocd,
+	}, nil, nil
+}
+
+// popNatsCreds removes the NATS credentials from the chart values and returns them.
+func popNatsCreds(values map[string]any) string {
+	nats, ok := values["nats"].(map[string]any)
+	if !ok {
+		return ""
+	}
+	creds, _ := nats["encodedCreds"].(string)
+	delete(nats, "encodedCreds")
+	if len(nats) == 0 {
+		delete(values, "nats")
+	}
+	return creds
+}
+
+func orderChart(order *releasesapi.Order) (*releasesapi.ChartSelection, error) {
+	for _, pkg := range order.Spec.Packages {
+		if pkg.Chart != nil {
+			return pkg.Chart, nil
+		}
+	}
+	return nil, fmt.Errorf("order %s has no chart", order.Name)
+}
+
+// RenderManifests renders the chart with its values patch applied, like `helm template` would.
+// It returns the manifest bundle and the version of the rendered chart.
+func RenderManifests(reg repo.IRegistry, pkg *releasesapi.ChartSelection) (string, string, error) {
+	chrt, err := reg.GetChart(releasesapi.ChartSourceRef{
+		Name:      pkg.Name,
+		Version:   pkg.Version,
+		SourceRef: pkg.SourceRef,
+	})
+	if err != nil {
+		return "", "", err
+	}
+	if ok, err := libchart.IsChartInstallable(chrt.Chart); !ok {
+		return "", "", err
+	}
+
+	vals := chrt.Values
+	if pkg.ValuesFile != "" && pkg.ValuesFile != chartutil.ValuesfileName {
+		for _, f := range chrt.Raw {
+			if f.Name == pkg.ValuesFile {
+				if err := yaml.Unmarshal(f.Data, &vals); err != nil {
+					return "", "", fmt.Errorf("cannot load %s. Reason: %v", f.Name, err)
+				}
+				break
+			}
+		}
+	}
+	vals, err = applyValuesPatch(vals, pkg.ValuesPatch)
+	if err != nil {
+		return "", "", err
+	}
+	if err := chartutil.ProcessDependencies(chrt.Chart, vals); err != nil {
+		return "", "", err
+	}
+
+	caps := chartutil.DefaultCapabilities.Copy()
+	kv, err := semver.NewVersion(apis.DefaultKubernetesVersion)
+	if err != nil {
+		return "", "", err
+	}
+	caps.KubeVersion = chartutil.KubeVersion{
+		Version: "v" + kv.String(),
+		Major:   strconv.FormatUint(kv.Major(), 10),
+		Minor:   strconv.FormatUint(kv.Minor(), 10),
+	}
+	renderVals, err := chartutil.ToRenderValues(chrt.Chart, vals, chartutil.ReleaseOptions{
+		Name:      pkg.ReleaseName,
+		Namespace: pkg.Namespace,
+		Revision:  1,
+		IsInstall: true,
+	}, caps)
+	if err != nil {
+		return "", "", err
+	}
+	hooks, manifests, err := libchart.RenderResources(chrt.Chart, caps, renderVals)
+	if err != nil {
+		return "", "", err
+	}
+
+	var buf bytes.Buffer
+	for _, crd := range chrt.CRDObjects() {
+		_, _ = fmt.Fprintf(&buf, "---\n# Source: %s\n%s\n", crd.Filename, bytes.TrimSpace(crd.File.Data))
+	}
+	if !apis.BuiltinNamespaces.Has(pkg.Namespace) {
+		_, _ = fmt.Fprintf(&buf, "---\napiVersion: v1\nkind: Namespace\nmetadata:\n  name: %s\n", pkg.Namespace)
+	}
+	for _, hook := range hooks {
+		if libchart.IsEvent(hook.Events, release.HookPreInstall) {
+			_, _ = fmt.Fprintf(&buf, "---\n# Source: %s\n%s\n", hook.Path, hook.Manifest)
+		}
+	}
+	for _, m := range manifests {
+		_, _ = fmt.Fprintf(&buf, "---\n# Source: %s\n%s\n", m.Name, m.Content)
+	}
+	for _, hook := range hooks {
+		if libchart.IsEvent(hook.Events, release.HookPostInstall) {
+			_, _ = fmt.Fprintf(&buf, "---\n# Source: %s\n%s\n", hook.Path, hook.Manifest)
+		}
+	}
+	return buf.String(), chrt.Metadata.Version, nil
+}
+
+func applyValuesPatch(vals map[string]any, patch *runtime.RawExtension) (map[string]any, error) {
+	if patch == nil || len(patch.Raw) == 0 {
+		return vals, nil
+	}
+	data, err := json.Marshal(vals)
+	if err != nil {
+		return nil, err
+	}
+	p, err := jsonpatch.DecodePatch(patch.Raw)
+	if err != nil {
+		return nil, err
+	}
+	data, err = p.Apply(data)
+	if err != nil {
+		return nil, err
+	}
+	var result map[string]any
+	if err := json.Unmarshal(data, &result); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// valuesFromPatch returns the values set by the patch, so that GitOps tools merge them
+// with the chart defaults like the values patch is applied to them by the scripts.
+// The patch is generated against an empty spec, so it only adds or replaces whole fields.
+func valuesFromPatch(patch *runtime.RawExtension) (map[string]any, error) {
+	values := map[string]any{}
+	if patch == nil || len(patch.Raw) == 0 {
+		return values, nil
+	}
+
+	var ops []struct {
+		Op    string `json:"op"`
+		Path  string `json:"path"`
+		Value any    `json:"value"`
+	}
+	if err := json.Unmarshal(patch.Raw, &ops); err != nil {
+		return nil, err
+	}
+	for _, op := range ops {
+		if op.Op != "add" && op.Op != "replace" {
+			continue
+		}
+		keys := strings.Split(strings.TrimPrefix(op.Path, "/"), "/")
+		m := values
+		for _, key := range keys[:len(keys)-1] {
+			key = unescapePointer(key)
+			next, ok := m[key].(map[string]any)
+			if !ok {
+				next = map[string]any{}
+				m[key] = next
+			}
+			m = next
+		}
+		m[unescapePointer(keys[len(keys)-1])] = op.Value
+	}
+	return values, nil
+}
+
+func unescapePointer(s string) string {
+	return strings.ReplaceAll(strings.ReplaceAll(s, "~1", "/"), "~0", "~")
+}
+
+// chartRepositoryURL returns the url of the Helm repository the chart is installed from.
+func chartRepositoryURL(reg repo.IRegistry, pkg *releasesapi.ChartSelection) (string, error) {
+	switch pkg.SourceRef.Kind {
+	case releasesapi.SourceKindLegacy:
+		return pkg.SourceRef.Name, nil
+	case releasesapi.SourceKindHelmRepository:
+		r, err := reg.GetHelmRepository(releasesapi.ChartSourceRef{
+			Name:      pkg.Name,
+			Version:   pkg.Version,
+			SourceRef: pkg.SourceRef,
+		})
+		if err != nil {
+			return "", err
+		}
+		return r.Spec.URL, nil
+	}
+	return "", fmt.Errorf("chart %s is installed from a %s, expected a Helm repository", pkg.Name, pkg.SourceRef.Kind)
+}
+
+// fluxHelmRelease returns the HelmRepository and HelmRelease of the chart. If credsSecret is set,
+// the NATS credentials are read from that Secret, see fluxCredentialsSecret.
+func fluxHelmRelease(pkg *releasesapi.ChartSelection, repoURL, version string, values map[string]any, credsSecret string) (string, error) {
+	repoType := "default"
+	if strings.HasPrefix(repoURL, "oci://") {
+		repoType = "oci"
+	}
+	helmRepo, err := toYAML(map[string]any{
+		"apiVersion": "source.toolkit.fluxcd.io/v1",
+		"kind":       "HelmRepository",
+		"metadata": map[string]any{
+			"name":      pkg.Name,
+			"namespace": fluxNamespace,
+		},
+		"spec": map[string]any{
+			"type":     repoType,
+			"url":      repoURL,
+			"interval": "30m",
+		},
+	})
+	if err != nil {
+		return "", err
+	}
+	spec := map[string]any{
+		"interval":         "10m",
+		"releaseName":      pkg.ReleaseName,
+		"targetNamespace":  pkg.Namespace,
+		"storageNamespace": pkg.Namespace,
+		"install": map[string]any{
+			"createNamespace": true,
+		},
+		"chart": map[string]any{
+			"spec": map[string]any{
+				"chart":   pkg.Name,
+				"version": version,
+				"sourceRef": map[string]any{
+					"kind":      "HelmRepository",
+					"name":      pkg.Name,
+					"namespace": fluxNamespace,
+				},
+			},
+		},
+		"values": values,
+	}
+	if credsSecret != "" {
+		spec["valuesFrom"] = []map[string]any{{
+			"kind":       "Secret",
+			"name":       credsSecret,
+			"valuesKey":  natsCredsKey,
+			"targetPath": "nats.encodedCreds",
+		}}
+	}
+	helmRelease, err := toYAML(map[string]any{
+		"apiVersion": "helm.toolkit.fluxcd.io/v2",
+		"kind":       "HelmRelease",
+		"metadata": map[string]any{
+			"name":      pkg.ReleaseName,
+			"namespace": fluxNamespace,
+		},
+		"spec": spec,
+	})
+	if err != nil {
+		return "", err
+	}
+	return helmRepo + "---\n" + helmRelease, nil
+}
+
+// natsCredsKey is the key of the NATS credentials in the Secret of FormatFluxCredentials.
+const natsCredsKey = "encodedCreds"
+
+// fluxCredentialsSecret returns the Secret the HelmRelease reads the NATS credentials from.
+// Flux requires it in the namespace of the HelmRelease.
+func fluxCredentialsSecret(name, creds string) (string, error) {
+	return toYAML(map[string]any{
+		"apiVersion": "v1",
+		"kind":       "Secret",
+		"metadata": map[string]any{
+			"name":      name,
+			"namespace": fluxNamespace,
+		},
+		"type": "Opaque",
+		"stringData": map[string]any{
+			natsCredsKey: creds,
+		},
+	})
+}
+
+func argoCDApplication(pkg *releasesapi.ChartSelection, repoURL, version string, values map[string]any) (string, error) {
+	return toYAML(map[string]any{
+		"apiVersion": "argoproj.io/v1alpha1",
+		"kind":       "Application",
+		"metadata": map[string]any{
+			"name":      pkg.ReleaseName,
+			"namespace": argoCDNamespace,
+		},
+		"spec": map[string]any{
+			"project": "default",
+			"source": map[string]any{
+				"repoURL":        strings.TrimPrefix(repoURL, "oci://"),
+				"chart":          pkg.Name,
+				"targetRevision": version,
+				"helm": map[string]any{
+					"releaseName":  pkg.ReleaseName,
+					"valuesObject": values,
+				},
+			},
+			"destination": map[string]any{
+				"server":    "https://kubernetes.default.svc",
+				"namespace": pkg.Namespace,
+			},
+			"syncPolicy": map[string]any{
+				"automated": map[string]any{
+					"prune":    true,
+					"selfHeal": true,
+				},
+				"syncOptions": []string{"CreateNamespace=true"},
+			},
+		},
+	})
+}
+
+func toYAML(obj map[string]any) (string, error) {
+	data, err := yaml.Marshal(obj)
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
@@ -0,0 +1,235 @@
+/*
+Copyright AppsCode Inc. and Contributors
+
+Licensed under the AppsCode Community License 1.0.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://github.com/appscode/licenses/raw/1.0.0/AppsCode-Community-1.0.0.md
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package link
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path"
+	"strings"
+	"testing"
+
+	kubeops "kubeops.dev/installer/apis/installer/v1alpha1"
+
+	fluxsrc "github.com/fluxcd/source-controller/api/v1"
+	"gomodules.xyz/blobfs"
+	"helm.sh/helm/v3/pkg/chart"
+	core "k8s.io/api/core/v1"
+	"kubepack.dev/kubepack/pkg/lib"
+	"kubepack.dev/lib-helm/pkg/repo"
+	"sigs.k8s.io/yaml"
+	releasesapi "x-helm.dev/apimachinery/apis/releases/v1alpha1"
+)
+
+// testRegistry serves a minimal cluster-connector chart for any chart reference.
+type testRegistry struct{}
+
+var _ repo.IRegistry = testRegistry{}
+
+func (testRegistry) GetChart(releasesapi.ChartSourceRef) (*repo.ChartExtended, error) {
+	return &repo.ChartExtended{Chart: &chart.Chart{
+		Metadata: &chart.Metadata{
+			APIVersion: chart.APIVersionV2,
+			Name:       "cluster-connector",
+			Version:    "v2024.1.31",
+			Type:       "application",
+		},
+		Values: map[string]any{
+			"linkID":       "",
+			"replicaCount": 1,
+			"nats": map[string]any{
+				"addr":         "",
+				"encodedCreds": "",
+			},
+		},
+		Templates: []*chart.File{{
+			Name: "templates/deployment.yaml",
+			Data: []byte(`apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: {{ .Release.Name }}
+  namespace: {{ .Release.Namespace }}
+spec:
+  replicas: {{ .Values.replicaCount }}
+  template:
+    spec:
+      containers:
+      - name: connector
+        args:
+        - --link-id={{ .Values.linkID }}
+        - --nats-addr={{ .Values.nats.addr }}
+`),
+		}},
+	}}, nil
+}
+
+func (testRegistry) GetHelmRepository(releasesapi.ChartSourceRef) (*fluxsrc.HelmRepository, error) {
+	return nil, errors.New("not supported")
+}
+
+func TestGenerateGitOps(t *testing.T) {
+	bs := &lib.BlobStore{
+		Interface: blobfs.New("file://" + t.TempDir()),
+		Host:      "https://links.example.com",
+	}
+	order, err := NewOrder(nil, InstallOptions{Spec: kubeops.ClusterConnectorSpec{
+		LinkID: "link1",
+		Nats:   kubeops.ClusterConnectorNats{Address: "nats://nats.example.com:4222"},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	result, unavailable, err := generateGitOps(bs, testRegistry{}, order)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(unavailable) != 0 {
+		t.Errorf("got unavailable formats %v, expected none", unavailable)
+	}
+
+	manifest := result[FormatManifest]
+	for _, s := range []string{"kind: Namespace", "kind: Deployment", "replicas: 1", "--link-id=link1", "--nats-addr=nats://nats.example.com:4222"} {
+		if !strings.Contains(manifest, s) {
+			t.Errorf("manifest does not contain %q:\n%s", s, manifest)
+		}
+	}
+
+	var kustomization struct {
+		Resources []string `json:"resources"`
+	}
+	if err := yaml.Unmarshal([]byte(result[FormatKustomize]), &kustomization); err != nil {
+		t.Fatal(err)
+	}
+	if len(kustomization.Resources) != 1 || !strings.HasPrefix(kustomization.Resources[0], bs.Host+"/link1/") {
+		t.Fatalf("got kustomization resources %v, expected the manifest bundle", kustomization.Resources)
+	}
+	uploaded, err := bs.ReadFile(context.TODO(), strings.TrimPrefix(kustomization.Resources[0], bs.Host+"/"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(uploaded) != manifest {
+		t.Errorf("uploaded manifest %s does not match the manifest format", path.Base(kustomization.Resources[0]))
+	}
+
+	docs := strings.Split(result[FormatFluxHelmRelease], "---\n")
+	if len(docs) != 2 {
+		t.Fatalf("got %d flux documents, expected HelmRepository and HelmRelease", len(docs))
+	}
+	var helmRelease struct {
+		Kind string `json:"kind"`
+		Spec struct {
+			Chart struct {
+				Spec struct {
+					Version string `json:"version"`
+				} `json:"spec"`
+			} `json:"chart"`
+			Values map[string]any `json:"values"`
+		} `json:"spec"`
+	}
+	if err := yaml.Unmarshal([]byte(docs[1]), &helmRelease); err != nil {
+		t.Fatal(err)
+	}
+	if helmRelease.Kind != "HelmRelease" || helmRelease.Spec.Chart.Spec.Version != "v2024.1.31" {
+		t.Errorf("got %s with chart version %q, expected HelmRelease of the rendered chart", helmRelease.Kind, helmRelease.Spec.Chart.Spec.Version)
+	}
+	if helmRelease.Spec.Values["linkID"] != "link1" {
+		t.Errorf("got HelmRelease values %v, expected the values patch", helmRelease.Spec.Values)
+	}
+
+	var app struct {
+		Spec struct {
+			Source struct {
+				RepoURL string `json:"repoURL"`
+				Helm    struct {
+					ValuesObject map[string]any `json:"valuesObject"`
+				} `json:"helm"`
+			} `json:"source"`
+		} `json:"spec"`
+	}
+	if err := yaml.Unmarshal([]byte(result[FormatArgoCDApplication]), &app); err != nil {
+		t.Fatal(err)
+	}
+	if app.Spec.Source.RepoURL != order.Spec.Packages[0].Chart.SourceRef.Name {
+		t.Errorf("got Application repo url %q, expected %q", app.Spec.Source.RepoURL, order.Spec.Packages[0].Chart.SourceRef.Name)
+	}
+	if nats, _ := app.Spec.Source.Helm.ValuesObject["nats"].(map[string]any); nats["addr"] != "nats://nats.example.com:4222" {
+		t.Errorf("got Application values %v, expected the values patch", app.Spec.Source.Helm.ValuesObject)
+	}
+}
+
+func TestGenerateGitOpsWithCredentials(t *testing.T) {
+	dir := t.TempDir()
+	bs := &lib.BlobStore{
+		Interface: blobfs.New("file://" + dir),
+		Host:      "https://links.example.com",
+	}
+	order, err := NewOrder(nil, InstallOptions{Spec: kubeops.ClusterConnectorSpec{
+		LinkID: "link1",
+		Nats:   kubeops.ClusterConnectorNats{Address: "nats://nats.example.com:4222", EncodedCreds: "c2VjcmV0"},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	result, unavailable, err := generateGitOps(bs, testRegistry{}, order)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for format, s := range result {
+		if format != FormatFluxCredentials && strings.Contains(s, "c2VjcmV0") {
+			t.Errorf("%s format contains the NATS credentials:\n%s", format, s)
+		}
+	}
+	for _, format := range []string{FormatManifest, FormatKustomize, FormatArgoCDApplication} {
+		if _, found := result[format]; found || unavailable[format] == "" {
+			t.Errorf("got %s format, expected it to be unavailable with a reason for links with NATS credentials", format)
+		}
+	}
+	if entries, err := os.ReadDir(dir); err != nil || len(entries) != 0 {
+		t.Errorf("got %d uploaded files, expected none: %v", len(entries), err)
+	}
+
+	docs := strings.Split(result[FormatFluxHelmRelease], "---\n")
+	if len(docs) != 2 {
+		t.Fatalf("got %d flux documents, expected HelmRepository and HelmRelease", len(docs))
+	}
+	var helmRelease struct {
+		Spec struct {
+			ValuesFrom []struct {
+				Kind       string `json:"kind"`
+				Name       string `json:"name"`
+				ValuesKey  string `json:"valuesKey"`
+				TargetPath string `json:"targetPath"`
+			} `json:"valuesFrom"`
+		} `json:"spec"`
+	}
+	if err := yaml.Unmarshal([]byte(docs[1]), &helmRelease); err != nil {
+		t.Fatal(err)
+	}
+	var secret core.Secret
+	if err := yaml.Unmarshal([]byte(result[FormatFluxCredentials]), &secret); err != nil {
+		t.Fatal(err)
+	}
+	if vf := helmRelease.Spec.ValuesFrom; len(vf) != 1 || vf[0].Kind != "Secret" || vf[0].Name != secret.Name || vf[0].TargetPath != "nats.encodedCreds" {
+		t.Fatalf("got valuesFrom %+v, expected the NATS credentials from Secret %s", vf, secret.Name)
+	}
+	if creds := secret.StringData[helmRelease.Spec.ValuesFrom[0].ValuesKey]; creds != "c2VjcmV0" {
+		t.Errorf("got credentials %q in the Secret, expected %q", creds, "c2VjcmV0")
+	}
+}
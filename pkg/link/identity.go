@@ -0,0 +1,104 @@
+/*
+Copyright AppsCode Inc. and Contributors
+
+Licensed under the AppsCode Community License 1.0.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://github.com/appscode/licenses/raw/1.0.0/AppsCode-Community-1.0.0.md
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package link
+
+import (
+	"time"
+
+	"kubeops.dev/cluster-connector/pkg/shared"
+
+	"github.com/pkg/errors"
+)
+
+// Conflicts between the connector a link is bound to and a connector presenting itself for the link.
+var (
+	// ErrCloned is returned if the identity of the bound connector shows up in another cluster,
+	// while the bound connector is still active, eg. after a backup was restored into a new cluster.
+	ErrCloned = errors.New("connector identity was cloned into another cluster")
+	// ErrRebuilt is returned if a connector in another cluster claims the link after the bound
+	// connector went silent, eg. because its cluster was re-created.
+	ErrRebuilt = errors.New("cluster of the link was re-created")
+	// ErrDuplicate is returned if a second connector claims the link while the bound one is active.
+	ErrDuplicate = errors.New("link is claimed by another connector")
+)
+
+// ConflictReason returns the reason of a conflict returned by CheckConnector.
+func ConflictReason(err error) string {
+	switch {
+	case errors.Is(err, ErrCloned):
+		return "Cloned"
+	case errors.Is(err, ErrRebuilt):
+		return "Rebuilt"
+	case errors.Is(err, ErrDuplicate):
+		return "Duplicate"
+	default:
+		return ""
+	}
+}
+
+// CheckConnector checks that the connector presenting itself in req is the one the link is bound to.
+// A bound connector that has not been seen for staleAfter is considered gone. Links that are not
+// bound yet accept any connector.
+func CheckConnector(l *shared.LinkData, req shared.CallbackRequest, now time.Time, staleAfter time.Duration) error {
+	if l.ConnectorPublicKey == "" {
+		return nil
+	}
+
+	lastSeen := l.LastSeenAt
+	if lastSeen == nil {
+		lastSeen = l.UsedAt
+	}
+	gone := lastSeen == nil || now.Sub(*lastSeen) > staleAfter
+
+	sameKey := req.PublicKey == l.ConnectorPublicKey
+	sameCluster := req.ClusterID == l.ClusterID
+	switch {
+	case sameKey && sameCluster:
+		if req.Identity != nil && l.ConnectorIdentity != "" && req.Identity.ID != l.ConnectorIdentity {
+			return errors.Wrapf(ErrDuplicate, "link %s is bound to identity %s, got %s", l.LinkID, l.ConnectorIdentity, req.Identity.ID)
+		}
+		return nil
+	case sameKey:
+		// only a copy of the identity secret of the bound connector has its key
+		if gone {
+			return errors.Wrapf(ErrRebuilt, "identity of cluster %s presented from cluster %s after it went silent", l.ClusterID, req.ClusterID)
+		}
+		return errors.Wrapf(ErrCloned, "identity of cluster %s presented from cluster %s", l.ClusterID, req.ClusterID)
+	case sameCluster:
+		// eg. the connector was installed twice, or its identity secret was deleted
+		return errors.Wrapf(ErrDuplicate, "link %s is bound to a different connector in cluster %s", l.LinkID, l.ClusterID)
+	case gone:
+		return errors.Wrapf(ErrRebuilt, "link %s is bound to cluster %s, claimed by cluster %s", l.LinkID, l.ClusterID, req.ClusterID)
+	default:
+		return errors.Wrapf(ErrDuplicate, "link %s is bound to active cluster %s, claimed by cluster %s", l.LinkID, l.ClusterID, req.ClusterID)
+	}
+}
+
+// NewConflict returns the record of a connector rejected by CheckConnector with err.
+func NewConflict(req shared.CallbackRequest, err error, now time.Time) shared.LinkConflict {
+	c := shared.LinkConflict{
+		Reason:             ConflictReason(err),
+		Message:            err.Error(),
+		ClusterID:          req.ClusterID,
+		ConnectorPublicKey: req.PublicKey,
+		At:                 now,
+	}
+	if req.Identity != nil {
+		c.ConnectorIdentity = req.Identity.ID
+	}
+	return c
+}
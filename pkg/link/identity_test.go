@@ -0,0 +1,77 @@
+/*
+Copyright AppsCode Inc. and Contributors
+
+Licensed under the AppsCode Community License 1.0.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://github.com/appscode/licenses/raw/1.0.0/AppsCode-Community-1.0.0.md
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package link
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"kubeops.dev/cluster-connector/pkg/shared"
+)
+
+func TestCheckConnector(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	recently := now.Add(-time.Minute)
+	longAgo := now.Add(-48 * time.Hour)
+	bound := func(lastSeen *time.Time) *shared.LinkData {
+		return &shared.LinkData{
+			LinkID:             "l1",
+			ClusterID:          "cluster1",
+			ConnectorPublicKey: "key1",
+			ConnectorIdentity:  "id1",
+			UsedAt:             &longAgo,
+			LastSeenAt:         lastSeen,
+		}
+	}
+	req := func(clusterID, key, id, idCluster string) shared.CallbackRequest {
+		return shared.CallbackRequest{
+			LinkID:    "l1",
+			ClusterID: clusterID,
+			PublicKey: key,
+			Identity:  &shared.ConnectorIdentity{ID: id, ClusterID: idCluster},
+		}
+	}
+
+	cases := []struct {
+		name     string
+		link     *shared.LinkData
+		req      shared.CallbackRequest
+		expected error
+		reason   string
+	}{
+		{"unbound", &shared.LinkData{LinkID: "l1"}, req("cluster2", "key2", "id2", "cluster2"), nil, ""},
+		{"bound connector", bound(&recently), req("cluster1", "key1", "id1", "cluster1"), nil, ""},
+		{"bound connector without identity", bound(&recently), shared.CallbackRequest{LinkID: "l1", ClusterID: "cluster1", PublicKey: "key1"}, nil, ""},
+		{"other identity with same key", bound(&recently), req("cluster1", "key1", "id2", "cluster1"), ErrDuplicate, "Duplicate"},
+		{"cloned while active", bound(&recently), req("cluster2", "key1", "id1", "cluster1"), ErrCloned, "Cloned"},
+		{"restored after silence", bound(&longAgo), req("cluster2", "key1", "id1", "cluster1"), ErrRebuilt, "Rebuilt"},
+		{"never seen", bound(nil), req("cluster2", "key1", "id1", "cluster1"), ErrRebuilt, "Rebuilt"},
+		{"second connector in cluster", bound(&recently), req("cluster1", "key2", "id2", "cluster1"), ErrDuplicate, "Duplicate"},
+		{"other cluster while active", bound(&recently), req("cluster2", "key2", "id2", "cluster2"), ErrDuplicate, "Duplicate"},
+		{"reinstalled after silence", bound(&longAgo), req("cluster2", "key2", "id2", "cluster2"), ErrRebuilt, "Rebuilt"},
+	}
+	for _, c := range cases {
+		err := CheckConnector(c.link, c.req, now, time.Hour)
+		if (c.expected == nil && err != nil) || !errors.Is(err, c.expected) {
+			t.Errorf("%s: got %v, expected %v", c.name, err, c.expected)
+		}
+		if reason := ConflictReason(err); reason != c.reason {
+			t.Errorf("%s: got reason %q, expected %q", c.name, reason, c.reason)
+		}
+	}
+}
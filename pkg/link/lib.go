@@ -36,19 +36,20 @@ import (
 	releasesapi "x-helm.dev/apimachinery/apis/releases/v1alpha1"
 )
 
-func Generate(kc client.Client, bs *lib.BlobStore, reg repo.IRegistry, cv kubeops.ClusterConnectorSpec) (*shared.Link, error) {
-	order, err := NewOrder(kc, cv)
+func Generate(kc client.Client, bs *lib.BlobStore, reg repo.IRegistry, opts InstallOptions) (*shared.Link, error) {
+	order, err := NewOrder(kc, opts)
 	if err != nil {
 		return nil, err
 	}
 
-	result, err := GenerateScripts(bs, reg, order)
+	result, unavailable, err := GenerateScripts(bs, reg, order)
 	if err != nil {
 		return nil, err
 	}
 	return &shared.Link{
-		LinkID:  string(order.UID),
-		Scripts: result,
+		LinkID:      string(order.UID),
+		Scripts:     result,
+		Unavailable: unavailable,
 	}, nil
 }
 
@@ -60,7 +61,8 @@ func NewBlobStore() (*lib.BlobStore, error) {
 	}, nil
 }
 
-func NewOrder(kc client.Client, cc kubeops.ClusterConnectorSpec) (*releasesapi.Order, error) {
+func NewOrder(kc client.Client, opts InstallOptions) (*releasesapi.Order, error) {
+	cc := opts.Spec
 	if len(cc.LinkID) == 0 {
 		cc.LinkID = xid.New().String()
 	}
@@ -70,6 +72,11 @@ func NewOrder(kc client.Client, cc kubeops.ClusterConnectorSpec) (*releasesapi.O
 		return nil, err
 	}
 
+	ns := opts.Namespace
+	if ns == "" {
+		ns = hub.BootstrapHelmRepositoryNamespace()
+	}
+
 	return &releasesapi.Order{
 		TypeMeta: metav1.TypeMeta{
 			APIVersion: releasesapi.GroupVersion.String(),
@@ -89,7 +96,7 @@ func NewOrder(kc client.Client, cc kubeops.ClusterConnectorSpec) (*releasesapi.O
 						},
 						Version:     hub.FeatureVersion(kc, shared.ChartClusterConnector),
 						ReleaseName: shared.ChartClusterConnector,
-						Namespace:   hub.BootstrapHelmRepositoryNamespace(),
+						Namespace:   ns,
 						Bundle:      nil,
 						// ValuesFile:  "values.yaml",
 						ValuesPatch: &runtime.RawExtension{Raw: patch},
@@ -120,19 +127,24 @@ func generatePatch(cc kubeops.ClusterConnectorSpec) ([]byte, error) {
 	return json.MarshalIndent(ops, "", "  ")
 }
 
-func GenerateScripts(bs *lib.BlobStore, reg repo.IRegistry, order *releasesapi.Order) (map[string]string, error) {
+// GenerateScripts returns the install formats of the order, and the reason for each format
+// that is not available for it.
+func GenerateScripts(bs *lib.BlobStore, reg repo.IRegistry, order *releasesapi.Order) (map[string]string, map[string]string, error) {
 	scriptsYAML, err := lib.GenerateYAMLScript(bs, reg, *order, lib.DisableAppReleaseCRD, lib.OsIndependentScript)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 
 	scriptsHelm3, err := lib.GenerateHelm3Script(bs, reg, *order, lib.DisableAppReleaseCRD, lib.OsIndependentScript)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 
-	return map[string]string{
-		"yaml":  scriptsYAML[0].Script,
-		"helm3": scriptsHelm3[0].Script,
-	}, nil
+	result, unavailable, err := generateGitOps(bs, reg, order)
+	if err != nil {
+		return nil, nil, err
+	}
+	result[FormatYAML] = scriptsYAML[0].Script
+	result[FormatHelm3] = scriptsHelm3[0].Script
+	return result, unavailable, nil
 }
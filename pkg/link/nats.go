@@ -0,0 +1,312 @@
+/*
+Copyright AppsCode Inc. and Contributors
+
+Licensed under the AppsCode Community License 1.0.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://github.com/appscode/licenses/raw/1.0.0/AppsCode-Community-1.0.0.md
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package link
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"sync"
+	"time"
+
+	"kubeops.dev/cluster-connector/pkg/shared"
+	kubeops "kubeops.dev/installer/apis/installer/v1alpha1"
+
+	"github.com/nats-io/jwt/v2"
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nkeys"
+	"github.com/pkg/errors"
+	"gomodules.xyz/blobfs"
+)
+
+// NatsClaimsUpdateSubject is the system account subject NATS servers receive account JWT updates on.
+const NatsClaimsUpdateSubject = "$SYS.REQ.CLAIMS.UPDATE"
+
+// NatsUser is the NATS user a connector authenticates with.
+type NatsUser struct {
+	// Address of the NATS server.
+	Address string
+	// PublicKey of the user nkey. It identifies the user when it is revoked.
+	PublicKey string
+	// Creds is a NATS credentials file with the JWT and nkey seed of the user.
+	Creds []byte
+}
+
+// Values returns the chart values of the connector for the user.
+func (u NatsUser) Values() kubeops.ClusterConnectorNats {
+	return kubeops.ClusterConnectorNats{
+		Address:      u.Address,
+		EncodedCreds: base64.StdEncoding.EncodeToString(u.Creds),
+	}
+}
+
+// NatsProvisioner mints and revokes the NATS users of links.
+type NatsProvisioner interface {
+	// Provision returns a new NATS user that may only use the subjects of the link.
+	// The user expires with the link at notAfter, unless it is renewed.
+	Provision(ctx context.Context, linkID string, notAfter time.Time) (*NatsUser, error)
+	// Renew returns a user JWT without expiry for the user with the given public key, once the
+	// connector of the link is bound. The connector signs it with the seed of its credentials.
+	Renew(ctx context.Context, linkID, publicKey string) (string, error)
+	// Revoke rejects the user with the given public key from now on.
+	Revoke(ctx context.Context, publicKey string) error
+}
+
+// OperatorProvisioner mints NATS users signed by a local account key, for servers
+// running in operator mode. Users are revoked by issuing a new account JWT, signed
+// by the local operator key, that lists the revoked users.
+type OperatorProvisioner struct {
+	addr     string
+	operator nkeys.KeyPair
+	account  nkeys.KeyPair
+	subjects shared.SubjectOptions
+	fs       blobfs.Interface
+
+	// AccountName is the name of the account in its JWT.
+	AccountName string
+	// AccountClaims are the NATS claims of the account, eg. limits, exports and imports.
+	// They are kept in the account JWT issued on revocation, since it replaces the existing one.
+	// Limits that are not set are unlimited.
+	AccountClaims map[string]any
+	// PublishAccount is called with every account JWT issued on revocation, see NatsAccountPublisher.
+	PublishAccount func(ctx context.Context, accountJWT string) error
+
+	mu          sync.Mutex
+	revocations map[string]int64
+}
+
+var _ NatsProvisioner = &OperatorProvisioner{}
+
+// NewOperatorProvisioner returns a provisioner for users of the account, which must be
+// signed by the operator. The revoked users are persisted in fs, or kept in memory if fs is nil.
+// All users share the account, so the subjects of the edge must be scoped to the link.
+func NewOperatorProvisioner(addr string, operatorSeed, accountSeed []byte, subjects shared.SubjectOptions, fs blobfs.Interface) (*OperatorProvisioner, error) {
+	if err := subjects.ValidateLinkScoped(); err != nil {
+		return nil, errors.Wrap(err, "NATS users of links share an account")
+	}
+	operator, err := keyPairFromSeed(operatorSeed, nkeys.PrefixByteOperator)
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid operator seed")
+	}
+	account, err := keyPairFromSeed(accountSeed, nkeys.PrefixByteAccount)
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid account seed")
+	}
+	return &OperatorProvisioner{
+		addr:        addr,
+		operator:    operator,
+		account:     account,
+		subjects:    subjects,
+		fs:          fs,
+		AccountName: shared.ChartClusterConnector,
+	}, nil
+}
+
+func keyPairFromSeed(seed []byte, prefix nkeys.PrefixByte) (nkeys.KeyPair, error) {
+	pre, _, err := nkeys.DecodeSeed(seed)
+	if err != nil {
+		return nil, err
+	}
+	if pre != prefix {
+		return nil, errors.New("seed is of the wrong type")
+	}
+	return nkeys.FromSeed(seed)
+}
+
+func (p *OperatorProvisioner) Provision(_ context.Context, linkID string, notAfter time.Time) (*NatsUser, error) {
+	user, err := nkeys.CreateUser()
+	if err != nil {
+		return nil, err
+	}
+	userPub, err := user.PublicKey()
+	if err != nil {
+		return nil, err
+	}
+	seed, err := user.Seed()
+	if err != nil {
+		return nil, err
+	}
+	// the credentials are part of the install script, so they must not outlive the link
+	token, err := p.userJWT(linkID, userPub, notAfter)
+	if err != nil {
+		return nil, err
+	}
+	creds, err := jwt.FormatUserConfig(token, seed)
+	if err != nil {
+		return nil, err
+	}
+	return &NatsUser{
+		Address:   p.addr,
+		PublicKey: userPub,
+		Creds:     creds,
+	}, nil
+}
+
+func (p *OperatorProvisioner) Renew(_ context.Context, linkID, publicKey string) (string, error) {
+	if !nkeys.IsValidPublicUserKey(publicKey) {
+		return "", errors.Errorf("invalid NATS user public key %q", publicKey)
+	}
+	return p.userJWT(linkID, publicKey, time.Time{})
+}
+
+// userJWT returns the JWT of a user that may only use the subjects of the link.
+// The JWT does not expire if notAfter is zero.
+func (p *OperatorProvisioner) userJWT(linkID, publicKey string, notAfter time.Time) (string, error) {
+	names, err := p.subjects.NewNames(linkID)
+	if err != nil {
+		return "", err
+	}
+	sub, pub, err := names.EdgePermissions()
+	if err != nil {
+		return "", err
+	}
+
+	uc := jwt.NewUserClaims(publicKey)
+	uc.Name = linkID
+	uc.Pub.Allow.Add(pub...)
+	uc.Sub.Allow.Add(sub...)
+	if !notAfter.IsZero() {
+		uc.Expires = notAfter.Unix()
+	}
+	return uc.Encode(p.account)
+}
+
+func (p *OperatorProvisioner) Revoke(ctx context.Context, publicKey string) error {
+	if !nkeys.IsValidPublicUserKey(publicKey) {
+		return errors.Errorf("invalid NATS user public key %q", publicKey)
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if err := p.loadRevocations(ctx); err != nil {
+		return err
+	}
+	if _, found := p.revocations[publicKey]; !found {
+		p.revocations[publicKey] = time.Now().Unix()
+		if err := p.saveRevocations(ctx); err != nil {
+			return err
+		}
+	}
+
+	token, err := p.accountJWT()
+	if err != nil {
+		return err
+	}
+	if p.PublishAccount == nil {
+		return nil
+	}
+	return p.PublishAccount(ctx, token)
+}
+
+// AccountJWT returns the account JWT with the users revoked so far.
+func (p *OperatorProvisioner) AccountJWT(ctx context.Context) (string, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if err := p.loadRevocations(ctx); err != nil {
+		return "", err
+	}
+	return p.accountJWT()
+}
+
+func (p *OperatorProvisioner) accountJWT() (string, error) {
+	accountPub, err := p.account.PublicKey()
+	if err != nil {
+		return "", err
+	}
+	ac := jwt.NewAccountClaims(accountPub)
+	ac.Name = p.AccountName
+	if len(p.AccountClaims) > 0 {
+		data, err := json.Marshal(p.AccountClaims)
+		if err != nil {
+			return "", err
+		}
+		if err := json.Unmarshal(data, &ac.Account); err != nil {
+			return "", errors.Wrap(err, "invalid NATS account claims")
+		}
+	}
+	ac.Revocations = jwt.RevocationList{}
+	for pub, at := range p.revocations {
+		ac.Revocations[pub] = at
+	}
+	return ac.Encode(p.operator)
+}
+
+const revocationsPath = "nats/revocations.json"
+
+func (p *OperatorProvisioner) loadRevocations(ctx context.Context) error {
+	if p.revocations != nil {
+		return nil
+	}
+	revocations := map[string]int64{}
+	if p.fs != nil {
+		found, err := p.fs.Exists(ctx, revocationsPath)
+		if err != nil {
+			return err
+		}
+		if found {
+			data, err := p.fs.ReadFile(ctx, revocationsPath)
+			if err != nil {
+				return err
+			}
+			if err := json.Unmarshal(data, &revocations); err != nil {
+				return errors.Wrap(err, "failed to decode revoked NATS users")
+			}
+		}
+	}
+	p.revocations = revocations
+	return nil
+}
+
+func (p *OperatorProvisioner) saveRevocations(ctx context.Context) error {
+	if p.fs == nil {
+		return nil
+	}
+	data, err := json.Marshal(p.revocations)
+	if err != nil {
+		return err
+	}
+	return p.fs.WriteFile(ctx, revocationsPath, data)
+}
+
+// NatsAccountPublisher returns a function that pushes account JWTs to the NATS servers.
+// nc must be connected with a user of the system account.
+func NatsAccountPublisher(nc *nats.Conn, timeout time.Duration) func(ctx context.Context, accountJWT string) error {
+	return func(ctx context.Context, accountJWT string) error {
+		ctx, cancel := context.WithTimeout(ctx, timeout)
+		defer cancel()
+
+		msg, err := nc.RequestWithContext(ctx, NatsClaimsUpdateSubject, []byte(accountJWT))
+		if err != nil {
+			return errors.Wrap(err, "failed to update NATS account")
+		}
+		var resp struct {
+			Error *struct {
+				Code        int    `json:"code"`
+				Description string `json:"description"`
+			} `json:"error,omitempty"`
+		}
+		if err := json.Unmarshal(msg.Data, &resp); err != nil {
+			return errors.Wrap(err, "failed to decode NATS account update response")
+		}
+		if resp.Error != nil {
+			return errors.Errorf("failed to update NATS account: %s (code %d)", resp.Error.Description, resp.Error.Code)
+		}
+		return nil
+	}
+}
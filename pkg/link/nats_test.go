@@ -0,0 +1,251 @@
+/*
+Copyright AppsCode Inc. and Contributors
+
+Licensed under the AppsCode Community License 1.0.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://github.com/appscode/licenses/raw/1.0.0/AppsCode-Community-1.0.0.md
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package link
+
+import (
+	"context"
+	"encoding/base64"
+	"slices"
+	"testing"
+	"time"
+
+	"kubeops.dev/cluster-connector/pkg/shared"
+
+	"github.com/nats-io/jwt/v2"
+	"github.com/nats-io/nkeys"
+	"gomodules.xyz/blobfs"
+)
+
+func newTestProvisioner(t *testing.T, fs blobfs.Interface) (p *OperatorProvisioner, operatorPub, accountPub string) {
+	t.Helper()
+
+	operator, _ := nkeys.CreateOperator()
+	account, _ := nkeys.CreateAccount()
+	operatorSeed, _ := operator.Seed()
+	accountSeed, _ := account.Seed()
+	operatorPub, _ = operator.PublicKey()
+	accountPub, _ = account.PublicKey()
+
+	p, err := NewOperatorProvisioner("nats://nats.example.com:4222", operatorSeed, accountSeed, linkScopedSubjects(), fs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return p, operatorPub, accountPub
+}
+
+// linkScopedSubjects returns subject options for edges sharing an account.
+func linkScopedSubjects() shared.SubjectOptions {
+	subjects := *shared.NewSubjectOptions()
+	subjects.ProxyHandlerEdgeSubject = "k8s.proxy.handler.{{ .LinkID }}"
+	subjects.ProxyResponseEdgeSubject = "k8s.proxy.resp.{{ .LinkID }}.{{ .RequestID }}"
+	return subjects
+}
+
+func TestOperatorProvisioner(t *testing.T) {
+	ctx := context.Background()
+	p, operatorPub, accountPub := newTestProvisioner(t, nil)
+
+	if _, err := NewOperatorProvisioner(p.addr, mustSeed(t, p.account), mustSeed(t, p.operator), p.subjects, nil); err == nil {
+		t.Error("expected swapped operator and account seeds to be rejected")
+	}
+	if _, err := NewOperatorProvisioner(p.addr, mustSeed(t, p.operator), mustSeed(t, p.account), *shared.NewSubjectOptions(), nil); err == nil {
+		t.Error("expected subjects shared by all links to be rejected")
+	}
+
+	notAfter := time.Now().Add(time.Hour).Truncate(time.Second)
+	u, err := p.Provision(ctx, "link1", notAfter)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !nkeys.IsValidPublicUserKey(u.PublicKey) {
+		t.Errorf("invalid user public key %s", u.PublicKey)
+	}
+
+	// the credentials must be readable by nats.UserCredentials
+	token, err := nkeys.ParseDecoratedJWT(u.Creds)
+	if err != nil {
+		t.Fatal(err)
+	}
+	kp, err := nkeys.ParseDecoratedUserNKey(u.Creds)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pub, _ := kp.PublicKey(); pub != u.PublicKey {
+		t.Errorf("got seed of %s, expected %s", pub, u.PublicKey)
+	}
+
+	uc := decodeUserClaims(t, token, accountPub, "link1")
+	if uc.Subject != u.PublicKey || uc.Name != "link1" {
+		t.Errorf("got subject %s and name %s, expected %s and link1", uc.Subject, uc.Name, u.PublicKey)
+	}
+	if uc.Expires != notAfter.Unix() {
+		t.Errorf("got expiry %d, expected %d", uc.Expires, notAfter.Unix())
+	}
+
+	// the renewed JWT is bound to the same user, but does not expire with the link
+	renewed, err := p.Renew(ctx, "link1", u.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rc := decodeUserClaims(t, renewed, accountPub, "link1")
+	if rc.Subject != u.PublicKey || rc.Expires != 0 {
+		t.Errorf("got renewed subject %s and expiry %d, expected %s without expiry", rc.Subject, rc.Expires, u.PublicKey)
+	}
+	if _, err := p.Renew(ctx, "link1", accountPub); err == nil {
+		t.Error("expected account key to be rejected on renewal")
+	}
+
+	values := u.Values()
+	if creds, _ := base64.StdEncoding.DecodeString(values.EncodedCreds); string(creds) != string(u.Creds) || values.Address != "nats://nats.example.com:4222" {
+		t.Errorf("got values %+v, expected address and encoded credentials", values)
+	}
+
+	var published []string
+	p.PublishAccount = func(_ context.Context, accountJWT string) error {
+		published = append(published, accountJWT)
+		return nil
+	}
+	if err := p.Revoke(ctx, accountPub); err == nil {
+		t.Error("expected account key to be rejected")
+	}
+	if err := p.Revoke(ctx, u.PublicKey); err != nil {
+		t.Fatal(err)
+	}
+	if len(published) != 1 {
+		t.Fatalf("got %d published account JWTs, expected 1", len(published))
+	}
+	ac := decodeAccountClaims(t, published[0], operatorPub)
+	if ac.Subject != accountPub || !ac.IsClaimRevoked(uc) || !ac.IsClaimRevoked(rc) {
+		t.Errorf("got account claims %+v, expected user %s revoked", ac, u.PublicKey)
+	}
+}
+
+func TestOperatorProvisionerLinksDisjoint(t *testing.T) {
+	ctx := context.Background()
+	p, _, accountPub := newTestProvisioner(t, nil)
+
+	// users share the account, so they must not receive or answer the requests of other links
+	var users []*jwt.UserClaims
+	for _, linkID := range []string{"link1", "link2"} {
+		u, err := p.Provision(ctx, linkID, time.Now().Add(time.Hour))
+		if err != nil {
+			t.Fatal(err)
+		}
+		token, err := nkeys.ParseDecoratedJWT(u.Creds)
+		if err != nil {
+			t.Fatal(err)
+		}
+		users = append(users, decodeUserClaims(t, token, accountPub, linkID))
+	}
+	for _, pair := range [][2]jwt.StringList{
+		{users[0].Pub.Allow, users[1].Pub.Allow},
+		{users[0].Sub.Allow, users[1].Sub.Allow},
+	} {
+		for _, sub := range pair[0] {
+			if pair[1].Contains(sub) {
+				t.Errorf("subject %s is allowed for both links", sub)
+			}
+		}
+	}
+}
+
+func TestOperatorProvisionerRevocationsPersist(t *testing.T) {
+	ctx := context.Background()
+	fs := blobfs.New("file://" + t.TempDir())
+	user, _ := nkeys.CreateUser()
+	userPub, _ := user.PublicKey()
+
+	p, _, _ := newTestProvisioner(t, fs)
+	if err := p.Revoke(ctx, userPub); err != nil {
+		t.Fatal(err)
+	}
+
+	// a restarted hub must keep revoking the user
+	restarted, err := NewOperatorProvisioner(p.addr, mustSeed(t, p.operator), mustSeed(t, p.account), p.subjects, fs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	token, err := restarted.AccountJWT(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	operatorPub, _ := p.operator.PublicKey()
+	ac := decodeAccountClaims(t, token, operatorPub)
+	if _, found := ac.Revocations[userPub]; !found {
+		t.Errorf("got revocations %v after restart, expected %s", ac.Revocations, userPub)
+	}
+}
+
+func TestOperatorProvisionerAccountClaims(t *testing.T) {
+	p, operatorPub, _ := newTestProvisioner(t, nil)
+	p.AccountClaims = map[string]any{
+		"limits": map[string]any{"conn": 10},
+	}
+
+	token, err := p.AccountJWT(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	// limits that are not set stay unlimited
+	ac := decodeAccountClaims(t, token, operatorPub)
+	if ac.Limits.Conn != 10 || ac.Limits.Subs != jwt.NoLimit || !ac.Limits.WildcardExports {
+		t.Errorf("got limits %+v, expected 10 connections and no other limits", ac.Limits)
+	}
+}
+
+// decodeUserClaims verifies a user JWT and the permissions of the edge of the link.
+func decodeUserClaims(t *testing.T, token, issuer, linkID string) *jwt.UserClaims {
+	t.Helper()
+
+	uc, err := jwt.DecodeUserClaims(token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if uc.Issuer != issuer {
+		t.Errorf("got issuer %s, expected %s", uc.Issuer, issuer)
+	}
+	handler := "k8s.proxy.handler." + linkID
+	if pub := []string(uc.Pub.Allow); !slices.Equal(pub, []string{"k8s.proxy.resp." + linkID + ".*"}) {
+		t.Errorf("got publish permissions %v", pub)
+	}
+	if sub := []string(uc.Sub.Allow); !slices.Equal(sub, []string{handler, handler + ".short", handler + ".stream"}) {
+		t.Errorf("got subscribe permissions %v", sub)
+	}
+	return uc
+}
+
+func decodeAccountClaims(t *testing.T, token, issuer string) *jwt.AccountClaims {
+	t.Helper()
+
+	ac, err := jwt.DecodeAccountClaims(token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ac.Issuer != issuer {
+		t.Errorf("got issuer %s, expected %s", ac.Issuer, issuer)
+	}
+	return ac
+}
+
+func mustSeed(t *testing.T, kp nkeys.KeyPair) []byte {
+	t.Helper()
+	seed, err := kp.Seed()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return seed
+}
@@ -0,0 +1,167 @@
+/*
+Copyright AppsCode Inc. and Contributors
+
+Licensed under the AppsCode Community License 1.0.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://github.com/appscode/licenses/raw/1.0.0/AppsCode-Community-1.0.0.md
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package link
+
+import (
+	"net"
+	"strconv"
+
+	"kubeops.dev/cluster-connector/pkg/shared"
+	kubeops "kubeops.dev/installer/apis/installer/v1alpha1"
+
+	core "k8s.io/api/core/v1"
+	metav1validation "k8s.io/apimachinery/pkg/apis/meta/v1/validation"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+)
+
+// MaxReplicas is the maximum number of connector replicas a link can request.
+const MaxReplicas = 10
+
+// InstallOptions customize the connector installed by a link.
+type InstallOptions struct {
+	// Namespace of the connector release. Defaults to the namespace of the bootstrap helm repository.
+	Namespace string
+	Spec      kubeops.ClusterConnectorSpec
+}
+
+// NewInstallOptions validates the connector options of a link request.
+// The link id and NATS settings are left for the hub to fill in.
+func NewInstallOptions(req shared.LinkRequest) (InstallOptions, error) {
+	var errs field.ErrorList
+	if req.Namespace != "" {
+		for _, msg := range validation.IsDNS1123Label(req.Namespace) {
+			errs = append(errs, field.Invalid(field.NewPath("namespace"), req.Namespace, msg))
+		}
+	}
+	if req.RegistryFQDN != "" {
+		errs = append(errs, validateRegistry(req.RegistryFQDN, field.NewPath("registryFQDN"))...)
+	}
+	for i, name := range req.ImagePullSecrets {
+		for _, msg := range validation.IsDNS1123Subdomain(name) {
+			errs = append(errs, field.Invalid(field.NewPath("imagePullSecrets").Index(i), name, msg))
+		}
+	}
+	errs = append(errs, metav1validation.ValidateLabels(req.NodeSelector, field.NewPath("nodeSelector"))...)
+	for i, t := range req.Tolerations {
+		errs = append(errs, validateToleration(t, field.NewPath("tolerations").Index(i))...)
+	}
+	errs = append(errs, validateResources(req.Resources, field.NewPath("resources"))...)
+	for _, msg := range validation.IsInRange(req.Replicas, 0, MaxReplicas) {
+		errs = append(errs, field.Invalid(field.NewPath("replicas"), req.Replicas, msg))
+	}
+	if len(errs) > 0 {
+		return InstallOptions{}, errs.ToAggregate()
+	}
+
+	spec := kubeops.ClusterConnectorSpec{
+		ReplicaCount:     req.Replicas,
+		RegistryFQDN:     req.RegistryFQDN,
+		ImagePullSecrets: req.ImagePullSecrets,
+		NodeSelector:     req.NodeSelector,
+		Tolerations:      req.Tolerations,
+	}
+	spec.Image.Resources = req.Resources
+	opts := InstallOptions{
+		Namespace: req.Namespace,
+		Spec:      spec,
+	}
+	return opts, nil
+}
+
+// validateRegistry accepts a registry host with an optional port, eg. registry.example.com:5000.
+func validateRegistry(registry string, fldPath *field.Path) field.ErrorList {
+	var errs field.ErrorList
+	host := registry
+	if h, port, err := net.SplitHostPort(registry); err == nil {
+		host = h
+		n, err := strconv.Atoi(port)
+		if err != nil {
+			n = -1
+		}
+		for _, msg := range validation.IsValidPortNum(n) {
+			errs = append(errs, field.Invalid(fldPath, registry, msg))
+		}
+	}
+	if net.ParseIP(host) == nil {
+		for _, msg := range validation.IsDNS1123Subdomain(host) {
+			errs = append(errs, field.Invalid(fldPath, registry, msg))
+		}
+	}
+	return errs
+}
+
+func validateToleration(t core.Toleration, fldPath *field.Path) field.ErrorList {
+	var errs field.ErrorList
+	if t.Key != "" {
+		for _, msg := range validation.IsQualifiedName(t.Key) {
+			errs = append(errs, field.Invalid(fldPath.Child("key"), t.Key, msg))
+		}
+	}
+	switch t.Operator {
+	case core.TolerationOpEqual, "":
+		for _, msg := range validation.IsValidLabelValue(t.Value) {
+			errs = append(errs, field.Invalid(fldPath.Child("value"), t.Value, msg))
+		}
+	case core.TolerationOpExists:
+		if t.Value != "" {
+			errs = append(errs, field.Invalid(fldPath.Child("value"), t.Value, "must be empty when operator is Exists"))
+		}
+	default:
+		errs = append(errs, field.NotSupported(fldPath.Child("operator"), t.Operator,
+			[]string{string(core.TolerationOpEqual), string(core.TolerationOpExists)}))
+	}
+	if t.Key == "" && t.Operator != core.TolerationOpExists {
+		errs = append(errs, field.Invalid(fldPath.Child("operator"), t.Operator, "must be Exists when key is empty"))
+	}
+	switch t.Effect {
+	case "", core.TaintEffectNoSchedule, core.TaintEffectPreferNoSchedule, core.TaintEffectNoExecute:
+	default:
+		errs = append(errs, field.NotSupported(fldPath.Child("effect"), t.Effect,
+			[]string{string(core.TaintEffectNoSchedule), string(core.TaintEffectPreferNoSchedule), string(core.TaintEffectNoExecute)}))
+	}
+	if t.TolerationSeconds != nil && t.Effect != core.TaintEffectNoExecute {
+		errs = append(errs, field.Invalid(fldPath.Child("effect"), t.Effect, "must be NoExecute when tolerationSeconds is set"))
+	}
+	return errs
+}
+
+func validateResources(r core.ResourceRequirements, fldPath *field.Path) field.ErrorList {
+	var errs field.ErrorList
+	for _, rl := range []struct {
+		name string
+		list core.ResourceList
+	}{
+		{"limits", r.Limits},
+		{"requests", r.Requests},
+	} {
+		for name, q := range rl.list {
+			if name != core.ResourceCPU && name != core.ResourceMemory && name != core.ResourceEphemeralStorage {
+				errs = append(errs, field.NotSupported(fldPath.Child(rl.name).Key(string(name)), name,
+					[]string{string(core.ResourceCPU), string(core.ResourceMemory), string(core.ResourceEphemeralStorage)}))
+			} else if q.Sign() < 0 {
+				errs = append(errs, field.Invalid(fldPath.Child(rl.name).Key(string(name)), q.String(), "must be greater than or equal to 0"))
+			}
+		}
+	}
+	for name, q := range r.Requests {
+		if limit, found := r.Limits[name]; found && q.Cmp(limit) > 0 {
+			errs = append(errs, field.Invalid(fldPath.Child("requests").Key(string(name)), q.String(), "must be less than or equal to "+string(name)+" limit of "+limit.String()))
+		}
+	}
+	return errs
+}
@@ -0,0 +1,123 @@
+/*
+Copyright AppsCode Inc. and Contributors
+
+Licensed under the AppsCode Community License 1.0.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://github.com/appscode/licenses/raw/1.0.0/AppsCode-Community-1.0.0.md
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package link
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"kubeops.dev/cluster-connector/pkg/shared"
+
+	"gomodules.xyz/jsonpatch/v2"
+	core "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+func TestNewInstallOptions(t *testing.T) {
+	seconds := int64(30)
+	testCases := map[string]struct {
+		req      shared.LinkRequest
+		expected string // substring of the error, empty if valid
+	}{
+		"defaults": {shared.LinkRequest{}, ""},
+		"valid": {shared.LinkRequest{
+			Namespace:        "connector",
+			RegistryFQDN:     "registry.example.com:5000",
+			ImagePullSecrets: []string{"regcred"},
+			NodeSelector:     map[string]string{"kubernetes.io/os": "linux"},
+			Tolerations: []core.Toleration{
+				{Key: "dedicated", Operator: core.TolerationOpEqual, Value: "infra", Effect: core.TaintEffectNoSchedule},
+				{Operator: core.TolerationOpExists, Effect: core.TaintEffectNoExecute, TolerationSeconds: &seconds},
+			},
+			Resources: core.ResourceRequirements{
+				Limits:   core.ResourceList{core.ResourceMemory: resource.MustParse("256Mi")},
+				Requests: core.ResourceList{core.ResourceMemory: resource.MustParse("128Mi")},
+			},
+			Replicas: 2,
+		}, ""},
+		"registry ip":          {shared.LinkRequest{RegistryFQDN: "10.0.0.1:5000"}, ""},
+		"namespace":            {shared.LinkRequest{Namespace: "Kube_System"}, "namespace"},
+		"registry with path":   {shared.LinkRequest{RegistryFQDN: "registry.example.com/appscode"}, "registryFQDN"},
+		"registry port":        {shared.LinkRequest{RegistryFQDN: "registry.example.com:http"}, "registryFQDN"},
+		"pull secret":          {shared.LinkRequest{ImagePullSecrets: []string{"Reg Cred"}}, "imagePullSecrets[0]"},
+		"node selector":        {shared.LinkRequest{NodeSelector: map[string]string{"-os": "linux"}}, "nodeSelector"},
+		"toleration operator":  {shared.LinkRequest{Tolerations: []core.Toleration{{Key: "a", Operator: "In"}}}, "tolerations[0].operator"},
+		"toleration empty key": {shared.LinkRequest{Tolerations: []core.Toleration{{Value: "a"}}}, "tolerations[0].operator"},
+		"toleration seconds":   {shared.LinkRequest{Tolerations: []core.Toleration{{Key: "a", TolerationSeconds: &seconds}}}, "tolerations[0].effect"},
+		"resource name":        {shared.LinkRequest{Resources: core.ResourceRequirements{Limits: core.ResourceList{"nvidia.com/gpu": resource.MustParse("1")}}}, "resources.limits[nvidia.com/gpu]"},
+		"requests over limits": {shared.LinkRequest{Resources: core.ResourceRequirements{
+			Limits:   core.ResourceList{core.ResourceCPU: resource.MustParse("100m")},
+			Requests: core.ResourceList{core.ResourceCPU: resource.MustParse("1")},
+		}}, "resources.requests[cpu]"},
+		"negative replicas": {shared.LinkRequest{Replicas: -1}, "replicas"},
+		"too many replicas": {shared.LinkRequest{Replicas: MaxReplicas + 1}, "replicas"},
+	}
+	for name, tc := range testCases {
+		_, err := NewInstallOptions(tc.req)
+		if tc.expected == "" && err != nil {
+			t.Errorf("%s: unexpected error: %v", name, err)
+		} else if tc.expected != "" && (err == nil || !strings.Contains(err.Error(), tc.expected)) {
+			t.Errorf("%s: got error %v, expected an error for %s", name, err, tc.expected)
+		}
+	}
+}
+
+func TestGeneratePatchInstallOptions(t *testing.T) {
+	opts, err := NewInstallOptions(shared.LinkRequest{
+		RegistryFQDN:     "registry.example.com",
+		ImagePullSecrets: []string{"regcred"},
+		Resources: core.ResourceRequirements{
+			Limits: core.ResourceList{core.ResourceMemory: resource.MustParse("256Mi")},
+		},
+		Replicas: 2,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	opts.Spec.LinkID = "link1"
+
+	patch, err := generatePatch(opts.Spec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var ops []jsonpatch.Operation
+	if err := json.Unmarshal(patch, &ops); err != nil {
+		t.Fatal(err)
+	}
+	got := map[string]any{}
+	for _, op := range ops {
+		got[op.Path] = op.Value
+	}
+
+	expected := map[string]string{
+		"/linkID":                 `"link1"`,
+		"/registryFQDN":           `"registry.example.com"`,
+		"/imagePullSecrets":       `["regcred"]`,
+		"/replicaCount":           `2`,
+		"/image/resources/limits": `{"memory":"256Mi"}`,
+	}
+	for p, v := range expected {
+		data, _ := json.Marshal(got[p])
+		if string(data) != v {
+			t.Errorf("%s: got %s, expected %s", p, data, v)
+		}
+	}
+	if len(ops) != len(expected) {
+		t.Errorf("got %d patch operations %v, expected %d", len(ops), got, len(expected))
+	}
+}
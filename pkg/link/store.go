@@ -0,0 +1,470 @@
+/*
+Copyright AppsCode Inc. and Contributors
+
+Licensed under the AppsCode Community License 1.0.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://github.com/appscode/licenses/raw/1.0.0/AppsCode-Community-1.0.0.md
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package link
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"kubeops.dev/cluster-connector/pkg/shared"
+
+	"github.com/pkg/errors"
+	"gomodules.xyz/blobfs"
+)
+
+var (
+	ErrNotFound = errors.New("link not found")
+	ErrExists   = errors.New("link already exists")
+	ErrExpired  = errors.New("link expired")
+	ErrRevoked  = errors.New("link revoked")
+	ErrUsed     = errors.New("link already used")
+	ErrBound    = errors.New("link is bound to a different cluster")
+)
+
+// LinkStore manages the lifecycle of the links generated by the hub.
+// A link is created when the install scripts are generated, bound to a cluster
+// and marked used by the callback of the connector, and expires or is revoked otherwise.
+type LinkStore interface {
+	Create(ctx context.Context, l *shared.LinkData) error
+	Get(ctx context.Context, linkID string) (*shared.LinkData, error)
+	// MarkUsed fails unless the link is still valid and has not been used before.
+	MarkUsed(ctx context.Context, linkID string, now time.Time) error
+	// BindCluster fails if the link is already bound to a different cluster or connector.
+	BindCluster(ctx context.Context, linkID, clusterID, publicKey string) error
+	// BindConnector binds the unused link to the cluster and connector and marks it used in a single update.
+	// Binding a used link again to the same cluster and connector succeeds, eg. for a repeated callback.
+	BindConnector(ctx context.Context, linkID, clusterID, publicKey string, now time.Time) error
+	// MarkSeen records that the connector bound to the link presented its identity in req, together
+	// with its version and cluster. The identity id is bound to the link if it has none yet.
+	// Seen links are listed by ListClusters.
+	MarkSeen(ctx context.Context, req shared.CallbackRequest, now time.Time) error
+	// RecordConflict remembers the last connector rejected by CheckConnector.
+	RecordConflict(ctx context.Context, linkID string, c shared.LinkConflict) error
+	// Expire makes the link invalid from now on. A link that already expired is left as is.
+	Expire(ctx context.Context, linkID string, now time.Time) error
+	Revoke(ctx context.Context, linkID string, now time.Time) error
+	// ListByUser returns the links generated by the user with the given email, oldest first.
+	ListByUser(ctx context.Context, email string) ([]shared.LinkData, error)
+	// ListClusters returns the links whose connector has been seen, oldest first.
+	ListClusters(ctx context.Context) ([]shared.LinkData, error)
+}
+
+// NewMemoryStore returns a LinkStore that keeps links in memory. Links are lost on restart.
+func NewMemoryStore() LinkStore {
+	return &store{b: &memoryBackend{
+		links:    map[string]shared.LinkData{},
+		users:    map[string][]string{},
+		clusters: map[string]bool{},
+	}}
+}
+
+// NewBlobFSStore returns a LinkStore that persists links as json files in fs.
+// Blob storage offers no conditional writes, so only one process may write to fs at a time.
+func NewBlobFSStore(fs blobfs.Interface) LinkStore {
+	return &store{b: &blobBackend{fs: fs}}
+}
+
+// backend stores the links. Callers hold store.mu.
+type backend interface {
+	load(ctx context.Context, linkID string) (*shared.LinkData, error)
+	save(ctx context.Context, l *shared.LinkData) error
+	exists(ctx context.Context, linkID string) (bool, error)
+	index(ctx context.Context, email, linkID string) error
+	list(ctx context.Context, email string) ([]string, error)
+	// indexCluster adds the link to the index of linked clusters, if it is not indexed yet.
+	indexCluster(ctx context.Context, linkID string) error
+	listClusters(ctx context.Context) ([]string, error)
+}
+
+type store struct {
+	mu sync.Mutex
+	b  backend
+}
+
+var _ LinkStore = &store{}
+
+func (s *store) Create(ctx context.Context, l *shared.LinkData) error {
+	if !validID(l.LinkID) {
+		return errors.Errorf("invalid link id %q", l.LinkID)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	found, err := s.b.exists(ctx, l.LinkID)
+	if err != nil {
+		return err
+	}
+	if found {
+		return errors.Wrap(ErrExists, l.LinkID)
+	}
+	if err := s.b.save(ctx, l); err != nil {
+		return err
+	}
+	return s.b.index(ctx, l.User.Email, l.LinkID)
+}
+
+func (s *store) Get(ctx context.Context, linkID string) (*shared.LinkData, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.b.load(ctx, linkID)
+}
+
+func (s *store) MarkUsed(ctx context.Context, linkID string, now time.Time) error {
+	return s.update(ctx, linkID, func(l *shared.LinkData) error {
+		if err := validate(l, now); err != nil {
+			return err
+		}
+		if l.UsedAt != nil {
+			return errors.Wrap(ErrUsed, linkID)
+		}
+		l.UsedAt = &now
+		return nil
+	})
+}
+
+func (s *store) BindCluster(ctx context.Context, linkID, clusterID, publicKey string) error {
+	return s.update(ctx, linkID, func(l *shared.LinkData) error {
+		if l.RevokedAt != nil {
+			return errors.Wrap(ErrRevoked, linkID)
+		}
+		if (l.ClusterID != "" && l.ClusterID != clusterID) ||
+			(l.ConnectorPublicKey != "" && l.ConnectorPublicKey != publicKey) {
+			return errors.Wrap(ErrBound, linkID)
+		}
+		l.ClusterID = clusterID
+		l.ConnectorPublicKey = publicKey
+		return nil
+	})
+}
+
+func (s *store) BindConnector(ctx context.Context, linkID, clusterID, publicKey string, now time.Time) error {
+	return s.update(ctx, linkID, func(l *shared.LinkData) error {
+		if l.RevokedAt != nil {
+			return errors.Wrap(ErrRevoked, linkID)
+		}
+		if l.UsedAt != nil {
+			if l.ClusterID == clusterID && l.ConnectorPublicKey == publicKey {
+				return nil
+			}
+			return errors.Wrap(ErrUsed, linkID)
+		}
+		if err := validate(l, now); err != nil {
+			return err
+		}
+		if (l.ClusterID != "" && l.ClusterID != clusterID) ||
+			(l.ConnectorPublicKey != "" && l.ConnectorPublicKey != publicKey) {
+			return errors.Wrap(ErrBound, linkID)
+		}
+		l.ClusterID = clusterID
+		l.ConnectorPublicKey = publicKey
+		l.UsedAt = &now
+		return nil
+	})
+}
+
+func (s *store) MarkSeen(ctx context.Context, req shared.CallbackRequest, now time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	l, err := s.b.load(ctx, req.LinkID)
+	if err != nil {
+		return err
+	}
+	if l.RevokedAt != nil {
+		return errors.Wrap(ErrRevoked, req.LinkID)
+	}
+	if l.ConnectorIdentity == "" && req.Identity != nil {
+		l.ConnectorIdentity = req.Identity.ID
+	}
+	if req.Version != "" {
+		l.ConnectorVersion = req.Version
+	}
+	if req.Cluster != nil {
+		l.Cluster = req.Cluster
+	}
+	l.LastSeenAt = &now
+	if err := s.b.save(ctx, l); err != nil {
+		return err
+	}
+	// links bound before the index existed are added when their connector is seen again
+	return s.b.indexCluster(ctx, req.LinkID)
+}
+
+func (s *store) RecordConflict(ctx context.Context, linkID string, c shared.LinkConflict) error {
+	return s.update(ctx, linkID, func(l *shared.LinkData) error {
+		l.LastConflict = &c
+		return nil
+	})
+}
+
+func (s *store) Expire(ctx context.Context, linkID string, now time.Time) error {
+	return s.update(ctx, linkID, func(l *shared.LinkData) error {
+		if l.NotAfter.After(now) {
+			l.NotAfter = now
+		}
+		return nil
+	})
+}
+
+func (s *store) Revoke(ctx context.Context, linkID string, now time.Time) error {
+	return s.update(ctx, linkID, func(l *shared.LinkData) error {
+		if l.RevokedAt == nil {
+			l.RevokedAt = &now
+		}
+		return nil
+	})
+}
+
+func (s *store) ListByUser(ctx context.Context, email string) ([]shared.LinkData, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	ids, err := s.b.list(ctx, email)
+	if err != nil {
+		return nil, err
+	}
+	return s.loadAll(ctx, ids)
+}
+
+// loadAll returns the links with the given ids, oldest first. Callers hold s.mu.
+func (s *store) loadAll(ctx context.Context, ids []string) ([]shared.LinkData, error) {
+	result := make([]shared.LinkData, 0, len(ids))
+	for _, id := range ids {
+		l, err := s.b.load(ctx, id)
+		if errors.Is(err, ErrNotFound) {
+			continue // index entry of a link removed from the storage
+		} else if err != nil {
+			return nil, err
+		}
+		result = append(result, *l)
+	}
+	sort.SliceStable(result, func(i, j int) bool {
+		return result[i].CreatedAt.Before(result[j].CreatedAt)
+	})
+	return result, nil
+}
+
+func (s *store) ListClusters(ctx context.Context) ([]shared.LinkData, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	ids, err := s.b.listClusters(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return s.loadAll(ctx, ids)
+}
+
+func (s *store) update(ctx context.Context, linkID string, fn func(l *shared.LinkData) error) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	l, err := s.b.load(ctx, linkID)
+	if err != nil {
+		return err
+	}
+	if err := fn(l); err != nil {
+		return err
+	}
+	return s.b.save(ctx, l)
+}
+
+// validID rejects ids that could escape the directory of the links, since they are sent by connectors.
+func validID(linkID string) bool {
+	return linkID != "" && linkID != "." && linkID != ".." && !strings.ContainsAny(linkID, "/\\")
+}
+
+// validate checks that the link can still be used to connect a cluster.
+func validate(l *shared.LinkData, now time.Time) error {
+	if l.RevokedAt != nil {
+		return errors.Wrap(ErrRevoked, l.LinkID)
+	}
+	if now.After(l.NotAfter) {
+		return errors.Wrapf(ErrExpired, "%s expired %v ago", l.LinkID, now.Sub(l.NotAfter))
+	}
+	return nil
+}
+
+type memoryBackend struct {
+	links    map[string]shared.LinkData
+	users    map[string][]string
+	clusters map[string]bool
+}
+
+func (m *memoryBackend) load(_ context.Context, linkID string) (*shared.LinkData, error) {
+	l, found := m.links[linkID]
+	if !found {
+		return nil, errors.Wrap(ErrNotFound, linkID)
+	}
+	return &l, nil
+}
+
+func (m *memoryBackend) save(_ context.Context, l *shared.LinkData) error {
+	m.links[l.LinkID] = *l
+	return nil
+}
+
+func (m *memoryBackend) exists(_ context.Context, linkID string) (bool, error) {
+	_, found := m.links[linkID]
+	return found, nil
+}
+
+func (m *memoryBackend) index(_ context.Context, email, linkID string) error {
+	key := strings.ToLower(email)
+	m.users[key] = append(m.users[key], linkID)
+	return nil
+}
+
+func (m *memoryBackend) list(_ context.Context, email string) ([]string, error) {
+	return append([]string(nil), m.users[strings.ToLower(email)]...), nil
+}
+
+func (m *memoryBackend) indexCluster(_ context.Context, linkID string) error {
+	m.clusters[linkID] = true
+	return nil
+}
+
+func (m *memoryBackend) listClusters(_ context.Context) ([]string, error) {
+	ids := make([]string, 0, len(m.clusters))
+	for id := range m.clusters {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids, nil
+}
+
+// blobBackend stores every link in links/<id>.json and the ids of the links
+// generated by a user in users/<sha256 of email>.json, since blobfs can not list files.
+// The ids of the links whose connector has been seen are stored in clusters.json.
+type blobBackend struct {
+	fs blobfs.Interface
+}
+
+func linkPath(linkID string) string {
+	return path.Join("links", linkID+".json")
+}
+
+const clustersPath = "clusters.json"
+
+func userPath(email string) string {
+	h := sha256.Sum256([]byte(strings.ToLower(email)))
+	return path.Join("users", hex.EncodeToString(h[:])+".json")
+}
+
+func (b *blobBackend) load(ctx context.Context, linkID string) (*shared.LinkData, error) {
+	found, err := b.exists(ctx, linkID)
+	if err != nil {
+		return nil, err
+	}
+	if !found {
+		return nil, errors.Wrap(ErrNotFound, linkID)
+	}
+	data, err := b.fs.ReadFile(ctx, linkPath(linkID))
+	if err != nil {
+		return nil, err
+	}
+	var l shared.LinkData
+	if err := json.Unmarshal(data, &l); err != nil {
+		return nil, errors.Wrapf(err, "failed to decode link %s", linkID)
+	}
+	return &l, nil
+}
+
+func (b *blobBackend) save(ctx context.Context, l *shared.LinkData) error {
+	data, err := json.Marshal(l)
+	if err != nil {
+		return err
+	}
+	return b.fs.WriteFile(ctx, linkPath(l.LinkID), data)
+}
+
+func (b *blobBackend) exists(ctx context.Context, linkID string) (bool, error) {
+	if !validID(linkID) {
+		return false, nil
+	}
+	return b.fs.Exists(ctx, linkPath(linkID))
+}
+
+func (b *blobBackend) index(ctx context.Context, email, linkID string) error {
+	ids, err := b.list(ctx, email)
+	if err != nil {
+		return err
+	}
+	data, err := json.Marshal(append(ids, linkID))
+	if err != nil {
+		return err
+	}
+	return b.fs.WriteFile(ctx, userPath(email), data)
+}
+
+func (b *blobBackend) list(ctx context.Context, email string) ([]string, error) {
+	found, err := b.fs.Exists(ctx, userPath(email))
+	if err != nil || !found {
+		return nil, err
+	}
+	data, err := b.fs.ReadFile(ctx, userPath(email))
+	if err != nil {
+		return nil, err
+	}
+	var ids []string
+	if err := json.Unmarshal(data, &ids); err != nil {
+		return nil, errors.Wrapf(err, "failed to decode links of user %s", email)
+	}
+	return ids, nil
+}
+
+func (b *blobBackend) indexCluster(ctx context.Context, linkID string) error {
+	ids, err := b.listClusters(ctx)
+	if err != nil {
+		return err
+	}
+	for _, id := range ids {
+		if id == linkID {
+			return nil
+		}
+	}
+	data, err := json.Marshal(append(ids, linkID))
+	if err != nil {
+		return err
+	}
+	return b.fs.WriteFile(ctx, clustersPath, data)
+}
+
+func (b *blobBackend) listClusters(ctx context.Context) ([]string, error) {
+	found, err := b.fs.Exists(ctx, clustersPath)
+	if err != nil || !found {
+		return nil, err
+	}
+	data, err := b.fs.ReadFile(ctx, clustersPath)
+	if err != nil {
+		return nil, err
+	}
+	var ids []string
+	if err := json.Unmarshal(data, &ids); err != nil {
+		return nil, errors.Wrap(err, "failed to decode linked clusters")
+	}
+	return ids, nil
+}
@@ -0,0 +1,173 @@
+/*
+Copyright AppsCode Inc. and Contributors
+
+Licensed under the AppsCode Community License 1.0.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://github.com/appscode/licenses/raw/1.0.0/AppsCode-Community-1.0.0.md
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package link
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"kubeops.dev/cluster-connector/pkg/shared"
+
+	"gomodules.xyz/blobfs"
+)
+
+func testStores(t *testing.T) map[string]LinkStore {
+	return map[string]LinkStore{
+		"memory": NewMemoryStore(),
+		// mem:// buckets do not outlive a single operation
+		"blobfs": NewBlobFSStore(blobfs.New("file://" + t.TempDir())),
+	}
+}
+
+func TestLinkStoreLifecycle(t *testing.T) {
+	ctx := context.Background()
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	alice := shared.User{Name: "alice", Email: "alice@example.com"}
+
+	for name, s := range testStores(t) {
+		newLink := func(id string, createdAt time.Time) {
+			err := s.Create(ctx, &shared.LinkData{
+				LinkID:    id,
+				User:      alice,
+				CreatedAt: createdAt,
+				NotAfter:  createdAt.Add(shared.ConnectorLinkLifetime),
+			})
+			if err != nil {
+				t.Fatalf("%s: failed to create link %s: %v", name, id, err)
+			}
+		}
+		newLink("l2", now.Add(time.Second))
+		newLink("l1", now)
+		newLink("l3", now)
+
+		if err := s.Create(ctx, &shared.LinkData{LinkID: "l1"}); !errors.Is(err, ErrExists) {
+			t.Errorf("%s: create duplicate: got %v, expected %v", name, err, ErrExists)
+		}
+		if err := s.Create(ctx, &shared.LinkData{LinkID: "../l1"}); err == nil {
+			t.Errorf("%s: create with invalid id: expected error", name)
+		}
+		if _, err := s.Get(ctx, "unknown"); !errors.Is(err, ErrNotFound) {
+			t.Errorf("%s: get unknown: got %v, expected %v", name, err, ErrNotFound)
+		}
+
+		if err := s.BindCluster(ctx, "l1", "cluster1", "key1"); err != nil {
+			t.Errorf("%s: bind: %v", name, err)
+		}
+		if err := s.BindCluster(ctx, "l1", "cluster1", "key1"); err != nil {
+			t.Errorf("%s: bind again: %v", name, err)
+		}
+		if err := s.BindCluster(ctx, "l1", "cluster1", "key2"); !errors.Is(err, ErrBound) {
+			t.Errorf("%s: bind other connector: got %v, expected %v", name, err, ErrBound)
+		}
+		if err := s.MarkUsed(ctx, "l1", now.Add(time.Minute)); err != nil {
+			t.Errorf("%s: mark used: %v", name, err)
+		}
+		if err := s.MarkUsed(ctx, "l1", now.Add(time.Minute)); !errors.Is(err, ErrUsed) {
+			t.Errorf("%s: mark used again: got %v, expected %v", name, err, ErrUsed)
+		}
+
+		seen := shared.CallbackRequest{
+			LinkID:   "l1",
+			Identity: &shared.ConnectorIdentity{ID: "id1"},
+			Version:  "v0.1.0",
+			Cluster:  &shared.ClusterInfo{Name: "prod"},
+		}
+		if err := s.MarkSeen(ctx, seen, now.Add(time.Hour)); err != nil {
+			t.Errorf("%s: mark seen: %v", name, err)
+		}
+		seen.Identity.ID = "id2"
+		seen.Version = "v0.2.0"
+		if err := s.MarkSeen(ctx, seen, now.Add(2*time.Hour)); err != nil {
+			t.Errorf("%s: mark seen again: %v", name, err)
+		}
+		if err := s.RecordConflict(ctx, "l1", shared.LinkConflict{Reason: "Cloned", ClusterID: "cluster2", At: now}); err != nil {
+			t.Errorf("%s: record conflict: %v", name, err)
+		}
+
+		if err := s.Expire(ctx, "l2", now); err != nil {
+			t.Errorf("%s: expire: %v", name, err)
+		}
+		if err := s.BindConnector(ctx, "l2", "cluster2", "key2", now.Add(time.Minute)); !errors.Is(err, ErrExpired) {
+			t.Errorf("%s: bind expired: got %v, expected %v", name, err, ErrExpired)
+		}
+		if err := s.MarkUsed(ctx, "l2", now.Add(time.Minute)); !errors.Is(err, ErrExpired) {
+			t.Errorf("%s: use expired: got %v, expected %v", name, err, ErrExpired)
+		}
+
+		newLink("l4", now)
+		if err := s.BindConnector(ctx, "l4", "cluster4", "key4", now); err != nil {
+			t.Errorf("%s: bind connector: %v", name, err)
+		}
+		if err := s.BindConnector(ctx, "l4", "cluster4", "key4", now.Add(time.Minute)); err != nil {
+			t.Errorf("%s: bind connector again: %v", name, err)
+		}
+		if err := s.BindConnector(ctx, "l4", "cluster4", "key5", now); !errors.Is(err, ErrUsed) {
+			t.Errorf("%s: bind other connector: got %v, expected %v", name, err, ErrUsed)
+		}
+		if l, err := s.Get(ctx, "l4"); err != nil || l.UsedAt == nil || !l.UsedAt.Equal(now) {
+			t.Errorf("%s: got link %+v (%v), expected it used at %v", name, l, err, now)
+		}
+
+		if err := s.Revoke(ctx, "l3", now); err != nil {
+			t.Errorf("%s: revoke: %v", name, err)
+		}
+		if err := s.MarkUsed(ctx, "l3", now); !errors.Is(err, ErrRevoked) {
+			t.Errorf("%s: use revoked: got %v, expected %v", name, err, ErrRevoked)
+		}
+
+		l, err := s.Get(ctx, "l1")
+		if err != nil {
+			t.Fatalf("%s: get: %v", name, err)
+		}
+		if l.ClusterID != "cluster1" || l.ConnectorPublicKey != "key1" || l.UsedAt == nil || !l.UsedAt.Equal(now.Add(time.Minute)) {
+			t.Errorf("%s: got link %+v, expected it bound to cluster1 and used", name, l)
+		}
+		if l.ConnectorIdentity != "id1" || l.LastSeenAt == nil || !l.LastSeenAt.Equal(now.Add(2*time.Hour)) {
+			t.Errorf("%s: got identity %s last seen at %v, expected id1 seen at %v", name, l.ConnectorIdentity, l.LastSeenAt, now.Add(2*time.Hour))
+		}
+		if l.LastConflict == nil || l.LastConflict.ClusterID != "cluster2" {
+			t.Errorf("%s: got conflict %+v, expected one of cluster2", name, l.LastConflict)
+		}
+		if l.ConnectorVersion != "v0.2.0" || l.Cluster == nil || l.Cluster.Name != "prod" {
+			t.Errorf("%s: got connector version %s and cluster %+v, expected v0.2.0 in prod", name, l.ConnectorVersion, l.Cluster)
+		}
+		clusters, err := s.ListClusters(ctx)
+		if err != nil {
+			t.Fatalf("%s: list clusters: %v", name, err)
+		}
+		if len(clusters) != 1 || clusters[0].LinkID != "l1" {
+			t.Errorf("%s: got %d clusters, expected l1", name, len(clusters))
+		}
+
+		links, err := s.ListByUser(ctx, "Alice@example.com")
+		if err != nil {
+			t.Fatalf("%s: list: %v", name, err)
+		}
+		var ids []string
+		for _, l := range links {
+			ids = append(ids, l.LinkID)
+		}
+		if len(ids) != 4 || ids[0] != "l1" || ids[1] != "l3" || ids[2] != "l4" || ids[3] != "l2" {
+			t.Errorf("%s: got links %v, expected [l1 l3 l4 l2]", name, ids)
+		}
+		if links, _ := s.ListByUser(ctx, "bob@example.com"); len(links) != 0 {
+			t.Errorf("%s: got %d links for bob, expected none", name, len(links))
+		}
+	}
+}
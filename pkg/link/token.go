@@ -0,0 +1,140 @@
+/*
+Copyright AppsCode Inc. and Contributors
+
+Licensed under the AppsCode Community License 1.0.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://github.com/appscode/licenses/raw/1.0.0/AppsCode-Community-1.0.0.md
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package link
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"math"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// MinTokenKeySize is the minimum size of the key used to sign link tokens.
+const MinTokenKeySize = 32
+
+const (
+	tokenNonceSize = 16
+	// tokenSigSize truncates the HMAC-SHA256 of a token to 128 bits.
+	tokenSigSize     = 16
+	tokenPayloadSize = 8 + tokenNonceSize
+)
+
+var ErrInvalidToken = errors.New("invalid link token")
+
+// TokenClaims are signed into a link token.
+type TokenClaims struct {
+	Issuer    string
+	ExpiresAt int64
+	// Nonce makes every token unique, even if issued at the same time.
+	Nonce string
+}
+
+func (c TokenClaims) NotAfter() time.Time {
+	return time.Unix(c.ExpiresAt, 0)
+}
+
+// TokenSigner issues and verifies link tokens.
+//
+// A token is used as the link id, so it is passed to the connector by the install scripts
+// and ends up in blob paths, NATS subjects, metric labels and spans. It therefore carries no
+// data about the user: it is the url safe base64 encoding of the expiry and a random nonce,
+// followed by their HMAC-SHA256 with the issuer, which contains neither '.' nor '/'.
+// Tokens are verified without a lookup. Since they are valid until they expire,
+// the hub must mark the link used in its LinkStore to make them single use.
+type TokenSigner struct {
+	issuer   string
+	key      []byte
+	lifetime time.Duration
+}
+
+func NewTokenSigner(issuer string, key []byte, lifetime time.Duration) (*TokenSigner, error) {
+	if issuer == "" {
+		return nil, errors.New("missing token issuer")
+	}
+	if len(key) < MinTokenKeySize {
+		return nil, errors.Errorf("token signing key must be at least %d bytes, found %d", MinTokenKeySize, len(key))
+	}
+	if lifetime <= 0 {
+		return nil, errors.Errorf("invalid token lifetime %v", lifetime)
+	}
+	return &TokenSigner{issuer: issuer, key: key, lifetime: lifetime}, nil
+}
+
+func (s *TokenSigner) Lifetime() time.Duration {
+	return s.lifetime
+}
+
+// Issue returns a new token, valid for the lifetime of the signer.
+func (s *TokenSigner) Issue(now time.Time) (string, *TokenClaims, error) {
+	payload := make([]byte, tokenPayloadSize)
+	binary.BigEndian.PutUint64(payload, uint64(now.Add(s.lifetime).Unix()))
+	if _, err := rand.Read(payload[8:]); err != nil {
+		return "", nil, err
+	}
+	claims, err := s.claims(payload)
+	if err != nil {
+		return "", nil, err
+	}
+	return base64.RawURLEncoding.EncodeToString(append(payload, s.sign(payload)...)), claims, nil
+}
+
+// Verify checks the signature, issuer and expiry of the token and returns its claims.
+func (s *TokenSigner) Verify(token string, now time.Time) (*TokenClaims, error) {
+	data, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil || len(data) != tokenPayloadSize+tokenSigSize {
+		return nil, ErrInvalidToken
+	}
+	// the issuer is part of the signature, so tokens of other issuers fail here
+	payload, sig := data[:tokenPayloadSize], data[tokenPayloadSize:]
+	if !hmac.Equal(sig, s.sign(payload)) {
+		return nil, ErrInvalidToken
+	}
+
+	claims, err := s.claims(payload)
+	if err != nil {
+		return nil, ErrInvalidToken
+	}
+	if notAfter := claims.NotAfter(); now.After(notAfter) {
+		return nil, errors.Wrapf(ErrExpired, "token expired %v ago", now.Sub(notAfter))
+	}
+	return claims, nil
+}
+
+func (s *TokenSigner) claims(payload []byte) (*TokenClaims, error) {
+	exp := binary.BigEndian.Uint64(payload)
+	if exp > math.MaxInt64 {
+		return nil, ErrInvalidToken
+	}
+	return &TokenClaims{
+		Issuer:    s.issuer,
+		ExpiresAt: int64(exp),
+		Nonce:     base64.RawURLEncoding.EncodeToString(payload[8:]),
+	}, nil
+}
+
+func (s *TokenSigner) sign(payload []byte) []byte {
+	mac := hmac.New(sha256.New, s.key)
+	mac.Write([]byte(s.issuer))
+	mac.Write([]byte{0})
+	mac.Write(payload)
+	return mac.Sum(nil)[:tokenSigSize]
+}
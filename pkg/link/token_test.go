@@ -0,0 +1,94 @@
+/*
+Copyright AppsCode Inc. and Contributors
+
+Licensed under the AppsCode Community License 1.0.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://github.com/appscode/licenses/raw/1.0.0/AppsCode-Community-1.0.0.md
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package link
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTokenSigner(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	key := bytes.Repeat([]byte("k"), MinTokenKeySize)
+	s, err := NewTokenSigner("hub", key, 10*time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := NewTokenSigner("other", key, 10*time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherKey, err := NewTokenSigner("hub", bytes.Repeat([]byte("x"), MinTokenKeySize), 10*time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	token, claims, err := s.Issue(now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.ContainsAny(token, "./*> ") {
+		t.Errorf("token %q can not be used in a NATS subject", token)
+	}
+	if len(token) > 64 {
+		t.Errorf("got token of %d characters, expected a short opaque id", len(token))
+	}
+	if token2, _, _ := s.Issue(now); token2 == token {
+		t.Errorf("got the same token twice, expected a new nonce")
+	}
+
+	// the first characters encode the expiry, so tamper with the nonce
+	tampered := []byte(token)
+	if tampered[20] == 'A' {
+		tampered[20] = 'B'
+	} else {
+		tampered[20] = 'A'
+	}
+
+	testCases := map[string]struct {
+		signer   *TokenSigner
+		token    string
+		now      time.Time
+		expected error
+	}{
+		"valid":         {s, token, now.Add(10 * time.Minute), nil},
+		"expired":       {s, token, now.Add(11 * time.Minute), ErrExpired},
+		"other issuer":  {other, token, now, ErrInvalidToken},
+		"other key":     {otherKey, token, now, ErrInvalidToken},
+		"tampered":      {s, string(tampered), now, ErrInvalidToken},
+		"truncated":     {s, token[:10], now, ErrInvalidToken},
+		"not base64":    {s, "not a token", now, ErrInvalidToken},
+		"plain link id": {s, "cmp2kq4b0u8s73f1m5a0", now, ErrInvalidToken},
+	}
+	for name, tc := range testCases {
+		got, err := tc.signer.Verify(tc.token, tc.now)
+		if !errors.Is(err, tc.expected) {
+			t.Errorf("%s: got error %v, expected %v", name, err, tc.expected)
+			continue
+		}
+		if err == nil && *got != *claims {
+			t.Errorf("%s: got claims %+v, expected %+v", name, got, claims)
+		}
+	}
+
+	if _, err := NewTokenSigner("hub", key[:MinTokenKeySize-1], time.Minute); err == nil {
+		t.Errorf("expected short key to be rejected")
+	}
+}
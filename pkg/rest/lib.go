@@ -42,6 +42,15 @@ func GetNoCopyConfig(copy *rest.Config, nc *nats.Conn, names shared.SubjectNames
 	return copy, err
 }
 
+// GetForLink proxies the rest config over NATS using the subjects configured by opts for the given link.
+func GetForLink(config *rest.Config, nc *nats.Conn, linkID string, opts shared.SubjectOptions) (*rest.Config, error) {
+	names, err := opts.NewNames(linkID)
+	if err != nil {
+		return nil, err
+	}
+	return GetForRestConfig(config, nc, names)
+}
+
 func GetForKubeConfig(kubeconfigBytes []byte, contextName string, nc *nats.Conn, names shared.SubjectNames) (*rest.Config, error) {
 	kubeconfig, err := clientcmd.Load(kubeconfigBytes)
 	if err != nil {
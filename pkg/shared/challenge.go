@@ -0,0 +1,100 @@
+/*
+Copyright AppsCode Inc. and Contributors
+
+Licensed under the AppsCode Community License 1.0.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://github.com/appscode/licenses/raw/1.0.0/AppsCode-Community-1.0.0.md
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package shared
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+
+	"github.com/pkg/errors"
+)
+
+// Before the hub binds a link to a cluster, it challenges the connector that sent the callback.
+// The hub publishes a nonce in the HeaderKeyChallenge header to the proxy handler subject of
+// the link. The connector reads the cluster id from the api server of the cluster it runs in
+// and replies with a ChallengeResponse signed with its key. So only a connector that holds
+// the NATS credentials of the link and the key sent in the callback can complete the link,
+// and the hub does not need a kubeconfig of the cluster.
+const (
+	HeaderKeyChallenge = "X-Connector-Challenge"
+
+	challengeNonceSize = 32
+)
+
+// ChallengeResponse is the reply of a connector to an ownership challenge.
+type ChallengeResponse struct {
+	LinkID    string `json:"linkID"`
+	ClusterID string `json:"clusterID"`
+	Nonce     string `json:"nonce"`
+	// Signature of link id, cluster id and nonce, see SignChallenge.
+	Signature string `json:"signature,omitempty"`
+	// Error is set if the connector failed to answer the challenge.
+	Error string `json:"error,omitempty"`
+}
+
+// NewChallengeNonce returns a random nonce for an ownership challenge.
+func NewChallengeNonce() (string, error) {
+	nonce := make([]byte, challengeNonceSize)
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(nonce), nil
+}
+
+// SignChallenge sets the signature of the response.
+func SignChallenge(resp *ChallengeResponse, key ed25519.PrivateKey) {
+	resp.Signature = base64.StdEncoding.EncodeToString(ed25519.Sign(key, challengePayload(*resp)))
+}
+
+// VerifyChallenge checks that resp answers the challenge with nonce for the link and cluster,
+// and is signed with the private key of pub.
+func VerifyChallenge(resp ChallengeResponse, pub ed25519.PublicKey, linkID, clusterID, nonce string) error {
+	if resp.Error != "" {
+		return fmt.Errorf("connector failed to answer the challenge: %s", resp.Error)
+	}
+	if resp.Nonce != nonce {
+		return errors.New("challenge response does not match the nonce")
+	}
+	if resp.LinkID != linkID {
+		return fmt.Errorf("challenge response is for link %s, expected %s", resp.LinkID, linkID)
+	}
+	if resp.ClusterID != clusterID {
+		return fmt.Errorf("connector runs in cluster %s, but claimed cluster %s", resp.ClusterID, clusterID)
+	}
+	sig, err := base64.StdEncoding.DecodeString(resp.Signature)
+	if err != nil || len(sig) == 0 {
+		return errors.New("missing or invalid challenge signature")
+	}
+	if !ed25519.Verify(pub, challengePayload(resp), sig) {
+		return errors.New("invalid challenge signature")
+	}
+	return nil
+}
+
+func challengePayload(resp ChallengeResponse) []byte {
+	var buf bytes.Buffer
+	buf.WriteString("challenge\n")
+	buf.WriteString(resp.LinkID)
+	buf.WriteByte('\n')
+	buf.WriteString(resp.ClusterID)
+	buf.WriteByte('\n')
+	buf.WriteString(resp.Nonce)
+	return buf.Bytes()
+}
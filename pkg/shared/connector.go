@@ -32,10 +32,18 @@ const (
 	ConnectorAPIPathPrefix   = "/api/v1/connector"
 	ConnectorLinkAPIPath     = "/link"
 	ConnectorCallbackAPIPath = "/link/callback"
+	ConnectorConnectAPIPath  = "/link/connect"
+	ConnectorRevokeAPIPath   = "/link/revoke"
+	ConnectorUnlinkAPIPath   = "/link/unlink"
+	ConnectorDryRunAPIPath   = "/link/dry-run"
+	ConnectorClustersAPIPath = "/clusters"
 )
 
 const (
 	ConnectorLinkLifetime = 10 * time.Minute
+	// ChallengeTimeout is how long the hub waits for the connector to answer a challenge.
+	// It is well below the timeout of the callback, so that the hub gives up before the connector retries.
+	ChallengeTimeout = 5 * time.Second
 )
 
 var ChartClusterConnector = "cluster-connector"
@@ -96,10 +104,22 @@ func (n SameAccountNames) ProxyResponseSubjects() (hubSub, edgeSub string) {
 }
 
 func ConnectorCallbackEndpoint(baseURL string) string {
+	return connectorEndpoint(baseURL, ConnectorCallbackAPIPath)
+}
+
+func ConnectorConnectEndpoint(baseURL string) string {
+	return connectorEndpoint(baseURL, ConnectorConnectAPIPath)
+}
+
+func ConnectorUnlinkEndpoint(baseURL string) string {
+	return connectorEndpoint(baseURL, ConnectorUnlinkAPIPath)
+}
+
+func connectorEndpoint(baseURL, apiPath string) string {
 	u, err := info.APIServerAddress(baseURL)
 	if err != nil {
 		panic(errors.Wrapf(err, "invalid url: %s", baseURL))
 	}
-	u.Path = path.Join(u.Path, ConnectorAPIPathPrefix, ConnectorCallbackAPIPath)
+	u.Path = path.Join(u.Path, ConnectorAPIPathPrefix, apiPath)
 	return u.String()
 }
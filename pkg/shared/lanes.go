@@ -0,0 +1,59 @@
+/*
+Copyright AppsCode Inc. and Contributors
+
+Licensed under the AppsCode Community License 1.0.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://github.com/appscode/licenses/raw/1.0.0/AppsCode-Community-1.0.0.md
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package shared
+
+// Priority lanes of proxy requests. Short requests and long running requests
+// (watch, log follow, exec etc.) are published to separate subjects, so that
+// the edge can subscribe each lane with its own concurrency budget.
+const (
+	LaneShort  = "short"
+	LaneStream = "stream"
+)
+
+// Lanes lists the priority lanes subscribed by the edge.
+var Lanes = []string{LaneShort, LaneStream}
+
+// LaneSubject returns the subject of a lane for a proxy handler subject.
+func LaneSubject(sub, lane string) string {
+	return sub + "." + lane
+}
+
+// LaneNames is implemented by SubjectNames which publish proxy requests to priority lanes.
+// Lanes require connectors that subscribe them and NATS permissions for the lane subjects,
+// eg. k8s.proxy.handler.<link-id>.> instead of k8s.proxy.handler.<link-id>.
+type LaneNames interface {
+	UseLanes() bool
+}
+
+// UseLanes returns true if the hub should publish proxy requests to priority lanes.
+func UseLanes(names SubjectNames) bool {
+	ln, ok := names.(LaneNames)
+	return ok && ln.UseLanes()
+}
+
+type laneNames struct {
+	SubjectNames
+}
+
+func (laneNames) UseLanes() bool {
+	return true
+}
+
+// WithLanes returns SubjectNames that publish proxy requests to priority lanes.
+func WithLanes(names SubjectNames) SubjectNames {
+	return laneNames{SubjectNames: names}
+}
@@ -0,0 +1,127 @@
+/*
+Copyright AppsCode Inc. and Contributors
+
+Licensed under the AppsCode Community License 1.0.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://github.com/appscode/licenses/raw/1.0.0/AppsCode-Community-1.0.0.md
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package shared
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Requests sent by the connector to the hub (callback, heartbeats) are signed with the
+// ed25519 key of the connector. The hub binds a link to the public key sent in the callback
+// and verifies later requests of the connector against it.
+const (
+	HeaderKeyConnectorTimestamp = "X-Connector-Timestamp"
+	HeaderKeyConnectorSignature = "X-Connector-Signature"
+
+	// MaxSignatureAge is the maximum clock difference accepted between connector and hub.
+	MaxSignatureAge = 5 * time.Minute
+)
+
+// EncodePublicKey returns the string form of a connector public key used in requests.
+func EncodePublicKey(pub ed25519.PublicKey) string {
+	return base64.StdEncoding.EncodeToString(pub)
+}
+
+// ParsePublicKey parses a public key encoded by EncodePublicKey.
+func ParsePublicKey(s string) (ed25519.PublicKey, error) {
+	data, err := base64.StdEncoding.DecodeString(s)
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid public key")
+	}
+	if len(data) != ed25519.PublicKeySize {
+		return nil, fmt.Errorf("invalid public key size %d", len(data))
+	}
+	return ed25519.PublicKey(data), nil
+}
+
+// SignRequest signs the method, path, body and current time of req.
+// body must be the exact bytes sent as the request body.
+func SignRequest(req *http.Request, body []byte, key ed25519.PrivateKey, now time.Time) {
+	ts := strconv.FormatInt(now.Unix(), 10)
+	sig := ed25519.Sign(key, signaturePayload(req.Method, req.URL.EscapedPath(), ts, body))
+	req.Header.Set(HeaderKeyConnectorTimestamp, ts)
+	req.Header.Set(HeaderKeyConnectorSignature, base64.StdEncoding.EncodeToString(sig))
+}
+
+// VerifyRequest checks that req was signed by SignRequest with the private key of pub
+// no more than MaxSignatureAge ago.
+func VerifyRequest(req *http.Request, body []byte, pub ed25519.PublicKey, now time.Time) error {
+	ts := req.Header.Get(HeaderKeyConnectorTimestamp)
+	if ts == "" {
+		return errors.New("missing " + HeaderKeyConnectorTimestamp + " header")
+	}
+	unix, err := strconv.ParseInt(ts, 10, 64)
+	if err != nil {
+		return errors.Wrap(err, "invalid "+HeaderKeyConnectorTimestamp+" header")
+	}
+	if d := now.Sub(time.Unix(unix, 0)); d > MaxSignatureAge || d < -MaxSignatureAge {
+		return fmt.Errorf("request signed at %s is outside the accepted time window", time.Unix(unix, 0).UTC().Format(time.RFC3339))
+	}
+
+	sig, err := base64.StdEncoding.DecodeString(req.Header.Get(HeaderKeyConnectorSignature))
+	if err != nil || len(sig) == 0 {
+		return errors.New("missing or invalid " + HeaderKeyConnectorSignature + " header")
+	}
+	if !ed25519.Verify(pub, signaturePayload(req.Method, req.URL.EscapedPath(), ts, body), sig) {
+		return errors.New("invalid request signature")
+	}
+	return nil
+}
+
+func signaturePayload(method, path, ts string, body []byte) []byte {
+	sum := sha256.Sum256(body)
+	var buf bytes.Buffer
+	buf.WriteString(method)
+	buf.WriteByte('\n')
+	buf.WriteString(path)
+	buf.WriteByte('\n')
+	buf.WriteString(ts)
+	buf.WriteByte('\n')
+	buf.WriteString(hex.EncodeToString(sum[:]))
+	return buf.Bytes()
+}
+
+// VerifyCallback decodes a signed CallbackRequest and verifies its signature against the public key it carries.
+// The hub must additionally check that the public key matches the one bound to the link, if any.
+func VerifyCallback(req *http.Request, body []byte, now time.Time) (*CallbackRequest, ed25519.PublicKey, error) {
+	var in CallbackRequest
+	if err := json.Unmarshal(body, &in); err != nil {
+		return nil, nil, errors.Wrap(err, "invalid callback request")
+	}
+	if in.PublicKey == "" {
+		return nil, nil, errors.New("callback request is missing the connector public key")
+	}
+	pub, err := ParsePublicKey(in.PublicKey)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := VerifyRequest(req, body, pub, now); err != nil {
+		return nil, nil, err
+	}
+	return &in, pub, nil
+}
@@ -0,0 +1,123 @@
+/*
+Copyright AppsCode Inc. and Contributors
+
+Licensed under the AppsCode Community License 1.0.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://github.com/appscode/licenses/raw/1.0.0/AppsCode-Community-1.0.0.md
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package shared
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestVerifyCallback(t *testing.T) {
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	body, _ := json.Marshal(CallbackRequest{
+		LinkID:    "link1",
+		ClusterID: "cluster1",
+		PublicKey: EncodePublicKey(pub),
+	})
+	newRequest := func() *http.Request {
+		return httptest.NewRequest(http.MethodPost, "/api/v1/connector/link/callback", nil)
+	}
+
+	req := newRequest()
+	SignRequest(req, body, priv, now)
+	in, got, err := VerifyCallback(req, body, now.Add(time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if in.LinkID != "link1" || !got.Equal(pub) {
+		t.Errorf("unexpected callback %+v", in)
+	}
+
+	// claiming the link with a different cluster id invalidates the signature
+	forged, _ := json.Marshal(CallbackRequest{
+		LinkID:    "link1",
+		ClusterID: "cluster2",
+		PublicKey: EncodePublicKey(pub),
+	})
+	if _, _, err := VerifyCallback(req, forged, now); err == nil {
+		t.Error("expected modified body to fail verification")
+	}
+
+	// replayed request
+	if _, _, err := VerifyCallback(req, body, now.Add(MaxSignatureAge+time.Minute)); err == nil {
+		t.Error("expected old signature to fail verification")
+	}
+
+	// request signed by another key
+	_, other, _ := ed25519.GenerateKey(rand.Reader)
+	req = newRequest()
+	SignRequest(req, body, other, now)
+	if _, _, err := VerifyCallback(req, body, now); err == nil {
+		t.Error("expected signature of another key to fail verification")
+	}
+
+	// unsigned request
+	if _, _, err := VerifyCallback(newRequest(), body, now); err == nil {
+		t.Error("expected unsigned request to fail verification")
+	}
+}
+
+func TestVerifyChallenge(t *testing.T) {
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, _, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	nonce, err := NewChallengeNonce()
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp := ChallengeResponse{LinkID: "link1", ClusterID: "cluster1", Nonce: nonce}
+	SignChallenge(&resp, priv)
+
+	forged := resp
+	forged.ClusterID = "cluster2"
+	failed := ChallengeResponse{LinkID: "link1", Nonce: nonce, Error: "forbidden"}
+
+	testCases := map[string]struct {
+		resp      ChallengeResponse
+		pub       ed25519.PublicKey
+		clusterID string
+		nonce     string
+		valid     bool
+	}{
+		"valid":         {resp, pub, "cluster1", nonce, true},
+		"other key":     {resp, other, "cluster1", nonce, false},
+		"other nonce":   {resp, pub, "cluster1", "nonce", false},
+		"other cluster": {resp, pub, "cluster2", nonce, false},
+		"forged":        {forged, pub, "cluster2", nonce, false},
+		"error":         {failed, pub, "", nonce, false},
+	}
+	for name, tc := range testCases {
+		err := VerifyChallenge(tc.resp, tc.pub, "link1", tc.clusterID, tc.nonce)
+		if (err == nil) != tc.valid {
+			t.Errorf("%s: got error %v, expected valid: %t", name, err, tc.valid)
+		}
+	}
+}
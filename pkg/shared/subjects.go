@@ -0,0 +1,237 @@
+/*
+Copyright AppsCode Inc. and Contributors
+
+Licensed under the AppsCode Community License 1.0.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://github.com/appscode/licenses/raw/1.0.0/AppsCode-Community-1.0.0.md
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package shared
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"text/template"
+
+	"github.com/rs/xid"
+	"github.com/spf13/pflag"
+)
+
+// Default subject templates. They produce the same subjects as CrossAccountNames.
+const (
+	DefaultProxyHandlerHubSubject   = "k8s.proxy.handler.{{ .LinkID }}"
+	DefaultProxyHandlerEdgeSubject  = "k8s.proxy.handler"
+	DefaultProxyResponseHubSubject  = "k8s.proxy.resp.{{ .LinkID }}.{{ .RequestID }}"
+	DefaultProxyResponseEdgeSubject = "k8s.proxy.resp.{{ .RequestID }}"
+)
+
+// SubjectData is passed to the subject templates.
+type SubjectData struct {
+	Tenant      string
+	Environment string
+	LinkID      string
+	RequestID   string
+}
+
+// SubjectOptions configures the NATS subjects used to proxy requests between hub and edge.
+// Tenant and Environment allow several hubs or connector products to share one NATS deployment.
+type SubjectOptions struct {
+	Tenant      string
+	Environment string
+
+	ProxyHandlerHubSubject   string
+	ProxyHandlerEdgeSubject  string
+	ProxyResponseHubSubject  string
+	ProxyResponseEdgeSubject string
+
+	// Lanes publishes proxy requests to the priority lanes of the handler subject. Only used by the hub.
+	Lanes bool
+}
+
+func NewSubjectOptions() *SubjectOptions {
+	return &SubjectOptions{
+		ProxyHandlerHubSubject:   DefaultProxyHandlerHubSubject,
+		ProxyHandlerEdgeSubject:  DefaultProxyHandlerEdgeSubject,
+		ProxyResponseHubSubject:  DefaultProxyResponseHubSubject,
+		ProxyResponseEdgeSubject: DefaultProxyResponseEdgeSubject,
+	}
+}
+
+func (o *SubjectOptions) AddFlags(fs *pflag.FlagSet) {
+	fs.StringVar(&o.Tenant, "subject-tenant", o.Tenant, "Tenant name available as {{ .Tenant }} in subject templates")
+	fs.StringVar(&o.Environment, "subject-environment", o.Environment, "Environment name (eg, staging, prod) available as {{ .Environment }} in subject templates")
+	fs.StringVar(&o.ProxyHandlerHubSubject, "proxy-handler-hub-subject", o.ProxyHandlerHubSubject, "Template for the subject hub publishes proxy requests to")
+	fs.StringVar(&o.ProxyHandlerEdgeSubject, "proxy-handler-edge-subject", o.ProxyHandlerEdgeSubject, "Template for the subject edge receives proxy requests on")
+	fs.StringVar(&o.ProxyResponseHubSubject, "proxy-response-hub-subject", o.ProxyResponseHubSubject, "Template for the subject hub receives proxy responses on")
+	fs.StringVar(&o.ProxyResponseEdgeSubject, "proxy-response-edge-subject", o.ProxyResponseEdgeSubject, "Template for the subject edge publishes proxy responses to")
+	fs.BoolVar(&o.Lanes, "proxy-handler-lanes", o.Lanes, "If true, hub publishes short and long running proxy requests to the .short and .stream subjects of the proxy handler subject. Connectors always subscribe the lanes.")
+}
+
+// NewNames returns the SubjectNames for the given link.
+func (o SubjectOptions) NewNames(linkID string) (*TemplateNames, error) {
+	n := &TemplateNames{
+		Data: SubjectData{
+			Tenant:      o.Tenant,
+			Environment: o.Environment,
+			LinkID:      linkID,
+		},
+		Lanes: o.Lanes,
+	}
+
+	var err error
+	for _, t := range []struct {
+		name string
+		text string
+		tpl  **template.Template
+	}{
+		{"proxy-handler-hub", o.ProxyHandlerHubSubject, &n.handlerHub},
+		{"proxy-handler-edge", o.ProxyHandlerEdgeSubject, &n.handlerEdge},
+		{"proxy-response-hub", o.ProxyResponseHubSubject, &n.responseHub},
+		{"proxy-response-edge", o.ProxyResponseEdgeSubject, &n.responseEdge},
+	} {
+		*t.tpl, err = template.New(t.name).Parse(t.text)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s subject template: %w", t.name, err)
+		}
+	}
+
+	// validate templates once, so that rendering can not fail later
+	data := n.Data
+	data.RequestID = xid.New().String()
+	for _, tpl := range []*template.Template{n.handlerHub, n.handlerEdge, n.responseHub, n.responseEdge} {
+		sub, err := render(tpl, data)
+		if err != nil {
+			return nil, fmt.Errorf("failed to render %s subject template: %w", tpl.Name(), err)
+		}
+		if err := ValidateSubject(sub); err != nil {
+			return nil, fmt.Errorf("invalid %s subject %q: %w", tpl.Name(), sub, err)
+		}
+	}
+	other := data
+	other.RequestID = xid.New().String()
+	if mustRender(n.responseHub, data) == mustRender(n.responseHub, other) {
+		return nil, fmt.Errorf("proxy-response-hub subject template must use {{ .RequestID }}")
+	}
+	return n, nil
+}
+
+// ValidateLinkScoped checks that the subjects of the edge differ for every link. The edges of links
+// that share a NATS account, eg. with users provisioned per link, could otherwise receive and answer
+// the requests of other links. The default subjects rely on cross account imports instead.
+func (o SubjectOptions) ValidateLinkScoped() error {
+	names, err := o.NewNames("link1")
+	if err != nil {
+		return err
+	}
+	other, err := o.NewNames("link2")
+	if err != nil {
+		return err
+	}
+	_, edge := names.ProxyHandlerSubjects()
+	_, otherEdge := other.ProxyHandlerSubjects()
+	if edge == otherEdge {
+		return fmt.Errorf("proxy-handler-edge subject template must use {{ .LinkID }}")
+	}
+	data, otherData := names.Data, other.Data
+	data.RequestID, otherData.RequestID = "req", "req"
+	if mustRender(names.responseEdge, data) == mustRender(other.responseEdge, otherData) {
+		return fmt.Errorf("proxy-response-edge subject template must use {{ .LinkID }}")
+	}
+	return nil
+}
+
+// ValidateSubject checks that a subject is a valid NATS subject without wildcards.
+func ValidateSubject(sub string) error {
+	if sub == "" {
+		return fmt.Errorf("empty subject")
+	}
+	if strings.ContainsAny(sub, " \t\r\n*>") {
+		return fmt.Errorf("subject must not contain whitespace or wildcards")
+	}
+	for _, token := range strings.Split(sub, ".") {
+		if token == "" {
+			return fmt.Errorf("subject must not contain empty tokens")
+		}
+	}
+	return nil
+}
+
+// TemplateNames is a SubjectNames implementation driven by templates.
+type TemplateNames struct {
+	Data  SubjectData
+	Lanes bool
+
+	handlerHub   *template.Template
+	handlerEdge  *template.Template
+	responseHub  *template.Template
+	responseEdge *template.Template
+}
+
+var (
+	_ SubjectNames = &TemplateNames{}
+	_ LaneNames    = &TemplateNames{}
+)
+
+func (n *TemplateNames) GetLinkID() string {
+	return n.Data.LinkID
+}
+
+func (n *TemplateNames) ProxyHandlerSubjects() (hubSub, edgeSub string) {
+	return mustRender(n.handlerHub, n.Data), mustRender(n.handlerEdge, n.Data)
+}
+
+func (n *TemplateNames) UseLanes() bool {
+	return n.Lanes
+}
+
+func (n *TemplateNames) ProxyResponseSubjects() (hubSub, edgeSub string) {
+	data := n.Data
+	data.RequestID = xid.New().String()
+	return mustRender(n.responseHub, data), mustRender(n.responseEdge, data)
+}
+
+// EdgePermissions returns the subjects the edge of the link subscribes to and publishes to.
+// Proxy responses are published to a subject per request, so the request id is replaced with a wildcard.
+func (n *TemplateNames) EdgePermissions() (sub, pub []string, err error) {
+	_, handler := n.ProxyHandlerSubjects()
+	sub = []string{handler}
+	for _, lane := range Lanes {
+		sub = append(sub, LaneSubject(handler, lane))
+	}
+
+	data := n.Data
+	data.RequestID = "*"
+	resp := mustRender(n.responseEdge, data)
+	for _, token := range strings.Split(resp, ".") {
+		if strings.Contains(token, "*") && token != "*" {
+			return nil, nil, fmt.Errorf("proxy-response-edge subject template must use {{ .RequestID }} as a whole token")
+		}
+	}
+	return sub, []string{resp}, nil
+}
+
+func mustRender(tpl *template.Template, data SubjectData) string {
+	sub, err := render(tpl, data)
+	if err != nil {
+		// templates are validated in NewNames
+		panic(err)
+	}
+	return sub
+}
+
+func render(tpl *template.Template, data SubjectData) (string, error) {
+	var buf bytes.Buffer
+	if err := tpl.Execute(&buf, data); err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(buf.String()), nil
+}
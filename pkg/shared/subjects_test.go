@@ -0,0 +1,157 @@
+/*
+Copyright AppsCode Inc. and Contributors
+
+Licensed under the AppsCode Community License 1.0.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://github.com/appscode/licenses/raw/1.0.0/AppsCode-Community-1.0.0.md
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package shared
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestTemplateNamesDefaults(t *testing.T) {
+	names, err := NewSubjectOptions().NewNames("link1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cross := CrossAccountNames{LinkID: "link1"}
+
+	hub, edge := names.ProxyHandlerSubjects()
+	wantHub, wantEdge := cross.ProxyHandlerSubjects()
+	if hub != wantHub || edge != wantEdge {
+		t.Errorf("handler subjects: got (%s, %s), expected (%s, %s)", hub, edge, wantHub, wantEdge)
+	}
+
+	hub, edge = names.ProxyResponseSubjects()
+	if !strings.HasPrefix(hub, "k8s.proxy.resp.link1.") || !strings.HasPrefix(edge, "k8s.proxy.resp.") {
+		t.Errorf("unexpected response subjects: (%s, %s)", hub, edge)
+	}
+	if uid := strings.TrimPrefix(hub, "k8s.proxy.resp.link1."); edge != "k8s.proxy.resp."+uid {
+		t.Errorf("response subjects use different request ids: (%s, %s)", hub, edge)
+	}
+}
+
+func TestTemplateNamesTenant(t *testing.T) {
+	opts := NewSubjectOptions()
+	opts.Tenant = "acme"
+	opts.Environment = "prod"
+	opts.ProxyHandlerHubSubject = "{{ .Environment }}.{{ .Tenant }}.k8s.proxy.handler.{{ .LinkID }}"
+	opts.ProxyHandlerEdgeSubject = "{{ .Environment }}.k8s.proxy.handler"
+
+	names, err := opts.NewNames("link1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	hub, edge := names.ProxyHandlerSubjects()
+	if hub != "prod.acme.k8s.proxy.handler.link1" || edge != "prod.k8s.proxy.handler" {
+		t.Errorf("unexpected handler subjects: (%s, %s)", hub, edge)
+	}
+}
+
+func TestTemplateNamesInvalid(t *testing.T) {
+	testCases := map[string]func(o *SubjectOptions){
+		"parse error":           func(o *SubjectOptions) { o.ProxyHandlerHubSubject = "k8s.{{ .LinkID" },
+		"unknown field":         func(o *SubjectOptions) { o.ProxyHandlerHubSubject = "k8s.{{ .Cluster }}" },
+		"empty token":           func(o *SubjectOptions) { o.ProxyHandlerHubSubject = "{{ .Tenant }}.k8s.proxy.handler" },
+		"wildcard":              func(o *SubjectOptions) { o.ProxyHandlerEdgeSubject = "k8s.proxy.handler.>" },
+		"missing request id":    func(o *SubjectOptions) { o.ProxyResponseHubSubject = "k8s.proxy.resp.{{ .LinkID }}" },
+		"whitespace in subject": func(o *SubjectOptions) { o.ProxyResponseEdgeSubject = "k8s.proxy resp.{{ .RequestID }}" },
+	}
+	for name, mutate := range testCases {
+		t.Run(name, func(t *testing.T) {
+			opts := NewSubjectOptions()
+			mutate(opts)
+			if _, err := opts.NewNames("link1"); err == nil {
+				t.Fatal("unexpected non-error")
+			}
+		})
+	}
+}
+
+func TestUseLanes(t *testing.T) {
+	cross := CrossAccountNames{LinkID: "link1"}
+	if UseLanes(cross) {
+		t.Error("expected lanes to be disabled by default")
+	}
+	if !UseLanes(WithLanes(cross)) {
+		t.Error("expected lanes to be enabled")
+	}
+	if hub, _ := WithLanes(cross).ProxyHandlerSubjects(); LaneSubject(hub, LaneStream) != "k8s.proxy.handler.link1.stream" {
+		t.Errorf("unexpected lane subject %s", LaneSubject(hub, LaneStream))
+	}
+
+	opts := NewSubjectOptions()
+	opts.Lanes = true
+	names, err := opts.NewNames("link1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !UseLanes(names) {
+		t.Error("expected lanes to be enabled by the subject options")
+	}
+}
+
+func TestEdgePermissions(t *testing.T) {
+	names, err := NewSubjectOptions().NewNames("link1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sub, pub, err := names.EdgePermissions()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(sub, ",") != "k8s.proxy.handler,k8s.proxy.handler.short,k8s.proxy.handler.stream" {
+		t.Errorf("unexpected subscribe permissions %v", sub)
+	}
+	if strings.Join(pub, ",") != "k8s.proxy.resp.*" {
+		t.Errorf("unexpected publish permissions %v", pub)
+	}
+
+	opts := NewSubjectOptions()
+	opts.ProxyResponseEdgeSubject = "k8s.proxy.resp-{{ .RequestID }}"
+	names, err = opts.NewNames("link1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := names.EdgePermissions(); err == nil {
+		t.Error("expected partial request id token to be rejected")
+	}
+}
+
+func TestValidateLinkScoped(t *testing.T) {
+	testCases := map[string]struct {
+		mutate func(o *SubjectOptions)
+		valid  bool
+	}{
+		"defaults": {mutate: func(o *SubjectOptions) {}},
+		"shared response subject": {mutate: func(o *SubjectOptions) {
+			o.ProxyHandlerEdgeSubject = "k8s.proxy.handler.{{ .LinkID }}"
+		}},
+		"shared handler subject": {mutate: func(o *SubjectOptions) {
+			o.ProxyResponseEdgeSubject = "k8s.proxy.resp.{{ .LinkID }}.{{ .RequestID }}"
+		}},
+		"link scoped": {mutate: func(o *SubjectOptions) {
+			o.ProxyHandlerEdgeSubject = "k8s.proxy.handler.{{ .LinkID }}"
+			o.ProxyResponseEdgeSubject = "k8s.proxy.resp.{{ .LinkID }}.{{ .RequestID }}"
+		}, valid: true},
+	}
+	for name, tc := range testCases {
+		opts := NewSubjectOptions()
+		tc.mutate(opts)
+		if err := opts.ValidateLinkScoped(); (err == nil) != tc.valid {
+			t.Errorf("%s: got error %v, expected valid %t", name, err, tc.valid)
+		}
+	}
+}
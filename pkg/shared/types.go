@@ -16,18 +16,59 @@ limitations under the License.
 
 package shared
 
-import "time"
+import (
+	"time"
+
+	core "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
 
 type Link struct {
 	LinkID  string            `json:"linkID"`
 	Scripts map[string]string `json:"scripts"`
+	// Unavailable are the install formats that were not generated for the link, with the reason.
+	Unavailable map[string]string `json:"unavailable,omitempty"`
 }
 
 type LinkData struct {
-	LinkID     string
-	ClusterID  string
-	NotAfter   time.Time
-	KubeConfig string
+	LinkID string `json:"linkID"`
+	// User who generated the link.
+	User User `json:"user"`
+	// Tenant of the user, it selects the NATS connection and subjects used for the cluster. Empty is the default tenant.
+	Tenant    string    `json:"tenant,omitempty"`
+	ClusterID string    `json:"clusterID,omitempty"`
+	CreatedAt time.Time `json:"createdAt"`
+	NotAfter  time.Time `json:"notAfter"`
+	// Deprecated: clusters are verified by the ownership challenge of the connector, see HeaderKeyChallenge.
+	KubeConfig string `json:"kubeConfig,omitempty"`
+	// ConnectorPublicKey is bound to the link by the first verified callback.
+	ConnectorPublicKey string `json:"connectorPublicKey,omitempty"`
+	// UsedAt is set by the first successful callback. A used link can not be used again.
+	UsedAt *time.Time `json:"usedAt,omitempty"`
+	// RevokedAt is set when the link is revoked.
+	RevokedAt *time.Time `json:"revokedAt,omitempty"`
+	// NatsUser is the public nkey of the NATS user provisioned for the connector, if any.
+	NatsUser string `json:"natsUser,omitempty"`
+	// ConnectorIdentity is the id of the identity the connector presented in the first callback.
+	ConnectorIdentity string `json:"connectorIdentity,omitempty"`
+	// LastSeenAt is updated whenever the connector presents its identity.
+	LastSeenAt *time.Time `json:"lastSeenAt,omitempty"`
+	// ConnectorVersion and Cluster are reported by the connector whenever it presents its identity.
+	ConnectorVersion string       `json:"connectorVersion,omitempty"`
+	Cluster          *ClusterInfo `json:"cluster,omitempty"`
+	// LastConflict is the last connector that presented an identity the link is not bound to.
+	LastConflict *LinkConflict `json:"lastConflict,omitempty"`
+}
+
+// LinkConflict describes a connector rejected because the link is bound to a different one.
+type LinkConflict struct {
+	// Reason is one of Cloned, Rebuilt or Duplicate.
+	Reason             string    `json:"reason"`
+	Message            string    `json:"message"`
+	ClusterID          string    `json:"clusterID"`
+	ConnectorPublicKey string    `json:"connectorPublicKey"`
+	ConnectorIdentity  string    `json:"connectorIdentity,omitempty"`
+	At                 time.Time `json:"at"`
 }
 
 type User struct {
@@ -53,10 +94,70 @@ type ChartValues struct {
 }
 
 type LinkRequest struct {
-	KubeConfig string `json:"kubeConfig"`
+	// Namespace of the connector. Defaults to the namespace of the bootstrap helm repository.
+	Namespace string `json:"namespace,omitempty"`
+	// RegistryFQDN overrides the registry the connector image is pulled from, eg. for air-gapped clusters.
+	RegistryFQDN     string                    `json:"registryFQDN,omitempty"`
+	ImagePullSecrets []string                  `json:"imagePullSecrets,omitempty"`
+	NodeSelector     map[string]string         `json:"nodeSelector,omitempty"`
+	Tolerations      []core.Toleration         `json:"tolerations,omitempty"`
+	Resources        core.ResourceRequirements `json:"resources,omitempty"`
+	// Replicas of the connector. Defaults to the chart default.
+	Replicas int `json:"replicas,omitempty"`
+	// Current are the objects of a previous install in the cluster, eg. read with kubectl get -o json.
+	// They are optional and only compared with the objects of a dry run.
+	Current []unstructured.Unstructured `json:"current,omitempty"`
 }
 
 type CallbackRequest struct {
 	LinkID    string `json:"linkID"`
 	ClusterID string `json:"clusterID"`
+	// PublicKey is the ed25519 public key of the connector, see EncodePublicKey.
+	// The request is signed with the matching private key, see SignRequest.
+	PublicKey string `json:"publicKey,omitempty"`
+	// Identity is the persisted identity of the connector. It is also presented on every start
+	// of a registered connector, see ConnectorConnectAPIPath.
+	Identity *ConnectorIdentity `json:"identity,omitempty"`
+	// Version of the connector.
+	Version string `json:"version,omitempty"`
+	// Cluster describes the cluster the connector runs in.
+	Cluster *ClusterInfo `json:"cluster,omitempty"`
+}
+
+// CallbackResponse is returned to a connector once its link is bound to it, see ConnectorCallbackAPIPath
+// and ConnectorConnectAPIPath.
+type CallbackResponse struct {
+	// NatsJWT is the NATS user JWT of the connector, renewed so that it does not expire with the link.
+	// It is signed with the seed of the NATS credentials of the connector. Empty if the hub does not
+	// provision NATS users.
+	NatsJWT string `json:"natsJWT,omitempty"`
+}
+
+// ClusterInfo is the metadata of a linked cluster, as detected by its connector.
+type ClusterInfo struct {
+	Name        string `json:"name,omitempty"`
+	DisplayName string `json:"displayName,omitempty"`
+	// Provider is the hosting provider, eg. AWS or Google, see kmodules.xyz/client-go/api/v1.HostingProvider.
+	Provider          string `json:"provider,omitempty"`
+	KubernetesVersion string `json:"kubernetesVersion,omitempty"`
+}
+
+// ConnectorIdentity is generated by a connector on first start and stored in its cluster next to its key.
+type ConnectorIdentity struct {
+	ID string `json:"id"`
+	// ClusterID is the cluster the identity was generated in. It differs from the cluster id of
+	// the request if the identity was copied to another cluster, eg. by restoring a backup.
+	ClusterID string `json:"clusterID"`
+}
+
+// UnlinkRequest is sent by a connector to deregister from the hub. It is signed with
+// the connector key bound to the link by the callback.
+type UnlinkRequest struct {
+	LinkID    string `json:"linkID"`
+	ClusterID string `json:"clusterID"`
+}
+
+// RevokeRequest is sent by a user to disconnect the cluster of a link.
+type RevokeRequest struct {
+	LinkID string `json:"linkID"`
 }
@@ -40,6 +40,8 @@ type tlsTransportCache struct {
 var tlsCache = &tlsTransportCache{transports: make(map[tlsCacheKey]http.RoundTripper)}
 
 type tlsCacheKey struct {
+	// connections of a ConnectionPool are replaced when they fail
+	conn               *nats.Conn
 	insecure           bool
 	caData             string
 	certData           string
@@ -48,6 +50,8 @@ type tlsCacheKey struct {
 	keyFile            string
 	serverName         string
 	linkID             string
+	handlerSubject     string
+	lanes              bool
 	nextProtos         string
 	disableCompression bool
 }
@@ -57,7 +61,7 @@ func (t tlsCacheKey) String() string {
 	if len(t.keyData) > 0 {
 		keyText = "<redacted>"
 	}
-	return fmt.Sprintf("insecure:%v, caData:%#v, certData:%#v, keyData:%s, serverName:%s, disableCompression:%t", t.insecure, t.caData, t.certData, keyText, t.serverName, t.disableCompression)
+	return fmt.Sprintf("insecure:%v, caData:%#v, certData:%#v, keyData:%s, serverName:%s, handlerSubject:%s, lanes:%t, disableCompression:%t", t.insecure, t.caData, t.certData, keyText, t.serverName, t.handlerSubject, t.lanes, t.disableCompression)
 }
 
 func (c *tlsTransportCache) get(config *transport.Config, nc *nats.Conn, names shared.SubjectNames, timeout time.Duration) (http.RoundTripper, error) {
@@ -65,6 +69,7 @@ func (c *tlsTransportCache) get(config *transport.Config, nc *nats.Conn, names s
 	if err != nil {
 		return nil, err
 	}
+	key.conn = nc
 
 	if canCache {
 		// Ensure we only create a single transport for the given TLS options
@@ -115,11 +120,16 @@ func tlsConfigKey(c *transport.Config, names shared.SubjectNames) (tlsCacheKey,
 		return tlsCacheKey{}, false, nil
 	}
 
+	// subjects may differ for the same link, eg, across tenants or environments
+	handlerSubject, _ := names.ProxyHandlerSubjects()
+
 	k := tlsCacheKey{
 		insecure:           c.TLS.Insecure,
 		caData:             string(c.TLS.CAData),
 		serverName:         c.TLS.ServerName,
 		linkID:             names.GetLinkID(),
+		handlerSubject:     handlerSubject,
+		lanes:              shared.UseLanes(names),
 		nextProtos:         strings.Join(c.TLS.NextProtos, ","),
 		disableCompression: c.DisableCompression,
 	}
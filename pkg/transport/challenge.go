@@ -0,0 +1,71 @@
+/*
+Copyright AppsCode Inc. and Contributors
+
+Licensed under the AppsCode Community License 1.0.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://github.com/appscode/licenses/raw/1.0.0/AppsCode-Community-1.0.0.md
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package transport
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"kubeops.dev/cluster-connector/pkg/shared"
+
+	"github.com/nats-io/nats.go"
+)
+
+// Challenge sends an ownership challenge with nonce to the connector of the link and returns its response.
+// The challenge is published to the proxy handler subject, which connectors always subscribe,
+// and answered on a proxy response subject, so it needs no NATS permissions beyond proxying.
+// The caller must verify the response, see shared.VerifyChallenge. Waiting for the response
+// stops after timeout or once ctx is done.
+func Challenge(ctx context.Context, nc *nats.Conn, names shared.SubjectNames, nonce string, timeout time.Duration) (*shared.ChallengeResponse, error) {
+	if IsLinkRevoked(names.GetLinkID()) {
+		return nil, ErrLinkRevoked
+	}
+
+	hubReqSub, _ := names.ProxyHandlerSubjects()
+	hubRespSub, edgeRespSub := names.ProxyResponseSubjects()
+
+	sub, err := nc.SubscribeSync(hubRespSub)
+	if err != nil {
+		return nil, err
+	}
+	defer sub.Unsubscribe() // nolint:errcheck
+
+	h := nats.Header{}
+	h.Set(HeaderKeyPublishedAt, time.Now().UTC().Format(time.RFC3339Nano))
+	h.Set(shared.HeaderKeyChallenge, nonce)
+	if err := nc.PublishMsg(&nats.Msg{
+		Subject: hubReqSub,
+		Reply:   edgeRespSub,
+		Header:  h,
+	}); err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	msg, err := sub.NextMsgWithContext(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("connector did not answer the challenge: %w", err)
+	}
+	var resp shared.ChallengeResponse
+	if err := json.Unmarshal(msg.Data, &resp); err != nil {
+		return nil, fmt.Errorf("invalid challenge response: %w", err)
+	}
+	return &resp, nil
+}
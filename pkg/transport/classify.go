@@ -0,0 +1,72 @@
+/*
+Copyright AppsCode Inc. and Contributors
+
+Licensed under the AppsCode Community License 1.0.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://github.com/appscode/licenses/raw/1.0.0/AppsCode-Community-1.0.0.md
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package transport
+
+import (
+	"net/http"
+	"strings"
+
+	"kubeops.dev/cluster-connector/pkg/shared"
+
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/apiserver/pkg/endpoints/request"
+)
+
+// Request classes used to schedule proxied requests on the edge. Each class has its own priority lane.
+const (
+	// RequestClassShort requests are answered as soon as the upstream responds, eg. get, list, create.
+	RequestClassShort = shared.LaneShort
+	// RequestClassStream requests hold the connection open, eg. watch, log follow, exec.
+	RequestClassStream = shared.LaneStream
+
+	HeaderKeyRequestClass = "Request-Class"
+)
+
+var (
+	requestInfoFactory = &request.RequestInfoFactory{
+		APIPrefixes:          sets.NewString("api", "apis"),
+		GrouplessAPIPrefixes: sets.NewString("api"),
+	}
+	// same as the long running subresources of kube-apiserver, except log which only streams with follow=true
+	streamSubresources = sets.NewString("attach", "exec", "portforward", "proxy")
+)
+
+// RequestClass returns the class of a request sent to the api server of the edge cluster.
+func RequestClass(req *http.Request) string {
+	if req == nil || req.URL == nil {
+		return RequestClassShort
+	}
+	if req.Header.Get("Upgrade") != "" {
+		return RequestClassStream // SPDY or websocket
+	}
+	q := req.URL.Query()
+	if isTrue(q.Get("watch")) || isTrue(q.Get("follow")) {
+		return RequestClassStream
+	}
+	info, err := requestInfoFactory.NewRequestInfo(req)
+	if err != nil || !info.IsResourceRequest {
+		return RequestClassShort
+	}
+	if info.Verb == "watch" || info.Verb == "proxy" || streamSubresources.Has(info.Subresource) {
+		return RequestClassStream
+	}
+	return RequestClassShort
+}
+
+func isTrue(v string) bool {
+	return v == "1" || strings.EqualFold(v, "true")
+}
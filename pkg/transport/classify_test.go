@@ -0,0 +1,51 @@
+/*
+Copyright AppsCode Inc. and Contributors
+
+Licensed under the AppsCode Community License 1.0.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://github.com/appscode/licenses/raw/1.0.0/AppsCode-Community-1.0.0.md
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package transport
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRequestClass(t *testing.T) {
+	testCases := map[string]string{
+		"/api/v1/namespaces/default/pods":                        RequestClassShort,
+		"/api/v1/namespaces/default/pods?watch=true":             RequestClassStream,
+		"/api/v1/watch/namespaces/default/pods":                  RequestClassStream,
+		"/apis/apps/v1/deployments?watch=1&resourceVersion=10":   RequestClassStream,
+		"/api/v1/namespaces/default/pods/nginx/log":              RequestClassShort,
+		"/api/v1/namespaces/default/pods/nginx/log?follow=true":  RequestClassStream,
+		"/api/v1/namespaces/default/pods/nginx/exec?command=sh":  RequestClassStream,
+		"/api/v1/namespaces/default/pods/nginx/portforward":      RequestClassStream,
+		"/api/v1/namespaces/default/services/http:web:80/proxy/": RequestClassStream,
+		"/version":             RequestClassShort,
+		"/apis/apps/v1?x=true": RequestClassShort,
+	}
+	for path, expected := range testCases {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		if got := RequestClass(req); got != expected {
+			t.Errorf("%s: got class %q, expected %q", path, got, expected)
+		}
+	}
+
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/namespaces/default/pods/nginx/attach", nil)
+	req.Header.Set("Upgrade", "SPDY/3.1")
+	if got := RequestClass(req); got != RequestClassStream {
+		t.Errorf("upgrade request: got class %q, expected %q", got, RequestClassStream)
+	}
+}
@@ -0,0 +1,186 @@
+/*
+Copyright AppsCode Inc. and Contributors
+
+Licensed under the AppsCode Community License 1.0.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://github.com/appscode/licenses/raw/1.0.0/AppsCode-Community-1.0.0.md
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package transport
+
+import (
+	"context"
+	"errors"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Error categories reported to ClientMetrics
+const (
+	ErrorCategoryNoResponders = "no_responders"
+	ErrorCategoryTimeout      = "timeout"
+	ErrorCategoryDecode       = "decode"
+	ErrorCategoryUpstream     = "upstream"
+	ErrorCategoryNats         = "nats"
+)
+
+// ClientMetrics observes the requests proxied by NatsTransport on the hub.
+type ClientMetrics interface {
+	// RequestStarted is called before a request is published to the edge.
+	RequestStarted(linkID string)
+	// FirstByte is called when the first response chunk is received.
+	FirstByte(linkID string, ttfb time.Duration)
+	// RequestDone is called once the response stream ends. category is empty for successful requests.
+	RequestDone(linkID string, duration time.Duration, category string)
+}
+
+var (
+	clientMetricsMu sync.RWMutex
+	clientMetrics   ClientMetrics
+)
+
+// SetClientMetrics sets the ClientMetrics used by NatsTransports that do not set their own.
+func SetClientMetrics(m ClientMetrics) {
+	clientMetricsMu.Lock()
+	defer clientMetricsMu.Unlock()
+	clientMetrics = m
+}
+
+func defaultClientMetrics() ClientMetrics {
+	clientMetricsMu.RLock()
+	defer clientMetricsMu.RUnlock()
+	return clientMetrics
+}
+
+// LinkMetricsDeleter is implemented by ClientMetrics that keep series per link.
+// The series of a link are deleted once it is revoked, see RevokeLink.
+type LinkMetricsDeleter interface {
+	DeleteLink(linkID string)
+}
+
+// PrometheusClientMetrics is a ClientMetrics implementation backed by Prometheus collectors.
+type PrometheusClientMetrics struct {
+	requests      *prometheus.CounterVec
+	errors        *prometheus.CounterVec
+	ttfb          *prometheus.HistogramVec
+	duration      *prometheus.HistogramVec
+	activeStreams *prometheus.GaugeVec
+}
+
+var (
+	_ ClientMetrics      = &PrometheusClientMetrics{}
+	_ LinkMetricsDeleter = &PrometheusClientMetrics{}
+)
+
+// NewPrometheusClientMetrics creates the hub side collectors and registers them with reg.
+func NewPrometheusClientMetrics(reg prometheus.Registerer) (*PrometheusClientMetrics, error) {
+	const (
+		namespace = "cluster_connector"
+		subsystem = "client"
+	)
+	m := &PrometheusClientMetrics{
+		requests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: subsystem,
+			Name:      "requests_total",
+			Help:      "Number of requests proxied over NATS by link.",
+		}, []string{"link"}),
+		errors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: subsystem,
+			Name:      "errors_total",
+			Help:      "Number of failed requests proxied over NATS by link and error category.",
+		}, []string{"link", "category"}),
+		ttfb: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Subsystem: subsystem,
+			Name:      "time_to_first_byte_seconds",
+			Help:      "Time until the first response chunk was received from the edge.",
+			Buckets:   []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60},
+		}, []string{"link"}),
+		duration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Subsystem: subsystem,
+			Name:      "request_duration_seconds",
+			Help:      "Time until the response stream from the edge ended.",
+			Buckets:   []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60, 300, 1800},
+		}, []string{"link"}),
+		activeStreams: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Subsystem: subsystem,
+			Name:      "active_streams",
+			Help:      "Response streams currently open by link.",
+		}, []string{"link"}),
+	}
+	for _, c := range []prometheus.Collector{m.requests, m.errors, m.ttfb, m.duration, m.activeStreams} {
+		if err := reg.Register(c); err != nil {
+			return nil, err
+		}
+	}
+	return m, nil
+}
+
+func (m *PrometheusClientMetrics) RequestStarted(linkID string) {
+	m.requests.WithLabelValues(linkID).Inc()
+	m.activeStreams.WithLabelValues(linkID).Inc()
+}
+
+func (m *PrometheusClientMetrics) FirstByte(linkID string, ttfb time.Duration) {
+	m.ttfb.WithLabelValues(linkID).Observe(ttfb.Seconds())
+}
+
+func (m *PrometheusClientMetrics) RequestDone(linkID string, duration time.Duration, category string) {
+	m.activeStreams.WithLabelValues(linkID).Dec()
+	m.duration.WithLabelValues(linkID).Observe(duration.Seconds())
+	if category != "" {
+		m.errors.WithLabelValues(linkID, category).Inc()
+	}
+}
+
+func (m *PrometheusClientMetrics) DeleteLink(linkID string) {
+	labels := prometheus.Labels{"link": linkID}
+	m.requests.DeletePartialMatch(labels)
+	m.errors.DeletePartialMatch(labels)
+	m.ttfb.DeletePartialMatch(labels)
+	m.duration.DeletePartialMatch(labels)
+	m.activeStreams.DeletePartialMatch(labels)
+}
+
+// upstreamError is reported by the edge in the Done header.
+type upstreamError struct {
+	msg string
+}
+
+func (e *upstreamError) Error() string {
+	return e.msg
+}
+
+// errorCategory returns the ClientMetrics error category for an error returned while proxying a request.
+// A request cancelled by the client, eg. by closing the body of a watch, is not an error.
+func errorCategory(err error) string {
+	var ue *upstreamError
+	switch {
+	case err == nil, errors.Is(err, context.Canceled), errors.Is(err, io.ErrClosedPipe):
+		return ""
+	case errors.Is(err, nats.ErrNoResponders):
+		return ErrorCategoryNoResponders
+	case errors.Is(err, nats.ErrTimeout), errors.Is(err, context.DeadlineExceeded):
+		return ErrorCategoryTimeout
+	case errors.As(err, &ue):
+		return ErrorCategoryUpstream
+	default:
+		return ErrorCategoryNats
+	}
+}
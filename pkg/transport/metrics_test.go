@@ -0,0 +1,115 @@
+/*
+Copyright AppsCode Inc. and Contributors
+
+Licensed under the AppsCode Community License 1.0.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://github.com/appscode/licenses/raw/1.0.0/AppsCode-Community-1.0.0.md
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package transport
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+type fakeClientMetrics struct {
+	started    int
+	firstBytes int
+	categories []string
+}
+
+func (m *fakeClientMetrics) RequestStarted(string)           { m.started++ }
+func (m *fakeClientMetrics) FirstByte(string, time.Duration) { m.firstBytes++ }
+func (m *fakeClientMetrics) RequestDone(_ string, _ time.Duration, category string) {
+	m.categories = append(m.categories, category)
+}
+
+func TestErrorCategory(t *testing.T) {
+	testCases := map[string]struct {
+		err      error
+		expected string
+	}{
+		"success":       {nil, ""},
+		"no responders": {nats.ErrNoResponders, ErrorCategoryNoResponders},
+		"timeout":       {nats.ErrTimeout, ErrorCategoryTimeout},
+		"deadline":      {context.DeadlineExceeded, ErrorCategoryTimeout},
+		"cancelled":     {context.Canceled, ""},
+		"client closed": {io.ErrClosedPipe, ""},
+		"upstream":      {&upstreamError{msg: "connection refused"}, ErrorCategoryUpstream},
+		"wrapped":       {fmt.Errorf("proxy: %w", nats.ErrTimeout), ErrorCategoryTimeout},
+		"nats":          {nats.ErrConnectionClosed, ErrorCategoryNats},
+		"other":         {errors.New("boom"), ErrorCategoryNats},
+	}
+	for name, tc := range testCases {
+		if got := errorCategory(tc.err); got != tc.expected {
+			t.Errorf("%s: got category %q, expected %q", name, got, tc.expected)
+		}
+	}
+}
+
+func TestRequestObserver(t *testing.T) {
+	m := &fakeClientMetrics{}
+	obs := newRequestObserver(m, "link1")
+	obs.firstByte()
+	obs.done(ErrorCategoryTimeout)
+	obs.done(ErrorCategoryDecode)
+
+	if m.started != 1 || m.firstBytes != 1 {
+		t.Errorf("got started=%d firstBytes=%d, expected 1 each", m.started, m.firstBytes)
+	}
+	if len(m.categories) != 1 || m.categories[0] != ErrorCategoryTimeout {
+		t.Errorf("got categories %v, expected only the first result to be reported", m.categories)
+	}
+
+	// nil metrics must be safe to use
+	obs = newRequestObserver(nil, "link1")
+	obs.firstByte()
+	obs.done("")
+}
+
+func TestDeleteLinkMetrics(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	m, err := NewPrometheusClientMetrics(reg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, linkID := range []string{"link1", "link2"} {
+		m.RequestStarted(linkID)
+		m.FirstByte(linkID, time.Millisecond)
+		m.RequestDone(linkID, time.Second, ErrorCategoryTimeout)
+	}
+	m.DeleteLink("link1")
+
+	families, err := reg.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, f := range families {
+		for _, metric := range f.GetMetric() {
+			for _, l := range metric.GetLabel() {
+				if l.GetName() == "link" && l.GetValue() != "link2" {
+					t.Errorf("%s: got series of link %q, expected only link2", f.GetName(), l.GetValue())
+				}
+			}
+		}
+		if len(f.GetMetric()) != 1 {
+			t.Errorf("%s: got %d series, expected 1", f.GetName(), len(f.GetMetric()))
+		}
+	}
+}
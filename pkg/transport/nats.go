@@ -21,77 +21,72 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
-	"errors"
 	"fmt"
 	"io"
 	"net/http"
-	"os"
 	"sync"
 	"time"
 
 	"kubeops.dev/cluster-connector/pkg/shared"
 
 	"github.com/nats-io/nats.go"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
 	"k8s.io/klog/v2"
 )
 
 const (
-	natsConnectionTimeout       = 350 * time.Millisecond
-	natsConnectionRetryInterval = 100 * time.Millisecond
+	natsConnectionTimeout          = 30 * time.Second
+	natsConnectionRetryInterval    = 100 * time.Millisecond
+	natsConnectionRetryMaxInterval = 10 * time.Second
+	natsConnectionJitter           = 0.2
 
-	HeaderKeyDone = "Done"
+	HeaderKeyDone        = "Done"
+	HeaderKeyPublishedAt = "Published-At"
 )
 
 // NewConnection creates a new NATS connection
 func NewConnection(addr, credFile string) (nc *nats.Conn, err error) {
-	hostname, _ := os.Hostname()
-	opts := []nats.Option{
-		nats.Name(fmt.Sprintf("scanner-backend.%s", hostname)),
-		nats.MaxReconnects(-1),
-		nats.ErrorHandler(errorHandler),
-		nats.ReconnectHandler(reconnectHandler),
-		nats.DisconnectErrHandler(disconnectHandler),
-		// nats.UseOldRequestStyle(),
-	}
-
-	if _, err := os.Stat(credFile); os.IsNotExist(err) {
-		var username, password string
-		if v, ok := os.LookupEnv("NATS_USERNAME"); ok {
-			username = v
-		}
-		if v, ok := os.LookupEnv("NATS_PASSWORD"); ok {
-			password = v
-		}
-		if username != "" && password != "" {
-			opts = append(opts, nats.UserInfo(username, password))
-		}
-	} else {
-		opts = append(opts, nats.UserCredentials(credFile))
+	opts := NewConnectionOptions()
+	opts.Addr = addr
+	opts.CredFile = credFile
+	opts.ConnectTimeout = natsConnectionTimeout
+	return opts.Connect(context.Background())
+}
+
+// Connect creates a new NATS connection using the options.
+// Initial connections can error due to DNS lookups, slow CNI etc. So, failed attempts are retried with
+// exponential backoff until the ConnectTimeout expires or the context is done.
+func (o *ConnectionOptions) Connect(ctx context.Context) (*nats.Conn, error) {
+	opts, err := o.NatsOptions()
+	if err != nil {
+		return nil, err
 	}
 
-	//if os.Getenv("NATS_CERTIFICATE") != "" && os.Getenv("NATS_KEY") != "" {
-	//	opts = append(opts, nats.ClientCert(os.Getenv("NATS_CERTIFICATE"), os.Getenv("NATS_KEY")))
-	//}
-	//
-	//if os.Getenv("NATS_CA") != "" {
-	//	opts = append(opts, nats.RootCAs(os.Getenv("NATS_CA")))
-	//}
+	if o.ConnectTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, o.ConnectTimeout)
+		defer cancel()
+	}
+
+	backoff := o.ConnectBackoff()
+	for attempt := 1; ; attempt++ {
+		nc, err := nats.Connect(o.Addr, opts...)
+		if err == nil {
+			return nc, nil
+		}
 
-	// initial connections can error due to DNS lookups etc, just retry, eventually with backoff
-	ctx, cancel := context.WithTimeout(context.Background(), natsConnectionTimeout)
-	defer cancel()
+		delay := backoff.Step()
+		klog.V(3).InfoS("failed to connect to nats", "addr", o.Addr, "attempt", attempt, "retryAfter", delay, "error", err)
 
-	ticker := time.NewTicker(natsConnectionRetryInterval)
-	for {
+		t := time.NewTimer(delay)
 		select {
-		case <-ticker.C:
-			nc, err := nats.Connect(addr, opts...)
-			if err == nil {
-				return nc, nil
-			}
-			klog.V(5).InfoS("failed to connect to event receiver", "error", err)
+		case <-t.C:
 		case <-ctx.Done():
-			return nil, ctx.Err()
+			t.Stop()
+			return nil, fmt.Errorf("failed to connect to nats after %d attempts: %w", attempt, err)
 		}
 	}
 }
@@ -127,6 +122,10 @@ type NatsTransport struct {
 	// server.
 	DisableCompression bool
 	TLS                *PersistableTLSConfig
+	// Metrics observes proxied requests. If nil, the ClientMetrics set via SetClientMetrics is used.
+	Metrics ClientMetrics
+	// TracerProvider records the hub side span of proxied requests. If nil, the global TracerProvider is used.
+	TracerProvider trace.TracerProvider
 }
 
 var pool = sync.Pool{
@@ -189,49 +188,120 @@ func (rt *NatsTransport) RoundTrip(r *http.Request) (*http.Response, error) {
 		return nil, err
 	}
 
-	return Proxy(r, rt.Conn, rt.Names, buf.Bytes(), timeout)
+	return proxy(r, rt.Conn, rt.Names, buf.Bytes(), timeout, rt.metrics(), rt.tracer())
+}
+
+func (rt *NatsTransport) metrics() ClientMetrics {
+	if rt.Metrics != nil {
+		return rt.Metrics
+	}
+	return defaultClientMetrics()
 }
 
 // SEE: https://github.com/nats-io/nats.docs/blob/master/using-nats/developing-with-nats/sending/replyto.md#including-a-reply-subject
 func Proxy(req *http.Request, nc *nats.Conn, names shared.SubjectNames, data []byte, timeout time.Duration) (*http.Response, error) {
+	return proxy(req, nc, names, data, timeout, defaultClientMetrics(), otel.Tracer(tracerName))
+}
+
+func proxy(req *http.Request, nc *nats.Conn, names shared.SubjectNames, data []byte, timeout time.Duration, metrics ClientMetrics, tracer trace.Tracer) (*http.Response, error) {
+	if IsLinkRevoked(names.GetLinkID()) {
+		return nil, ErrLinkRevoked
+	}
+
+	obs := newRequestObserver(metrics, names.GetLinkID())
+
+	class := RequestClass(req)
+	hubReqSub, _ := names.ProxyHandlerSubjects()
+	if shared.UseLanes(names) {
+		hubReqSub = shared.LaneSubject(hubReqSub, class)
+	}
 	hubRespSub, edgeRespSub := names.ProxyResponseSubjects()
 
+	ctx, span := tracer.Start(req.Context(), "cluster-connector.proxy",
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			attribute.String("cluster_connector.link_id", names.GetLinkID()),
+			attribute.String("messaging.system", "nats"),
+			attribute.String("messaging.destination.name", hubReqSub),
+			attribute.String("http.request.method", req.Method),
+			attribute.String("cluster_connector.request_class", class),
+		),
+	)
+	// finish reports the first result of the request, which may come from either goroutine
+	var once sync.Once
+	finish := func(err error, category string) {
+		once.Do(func() {
+			obs.done(category)
+			if category != "" {
+				span.RecordError(err)
+				span.SetStatus(codes.Error, err.Error())
+				span.SetAttributes(attribute.String("error.type", category))
+			}
+			span.End()
+		})
+	}
+
 	// Listen for a single response
 	sub, err := nc.SubscribeSync(hubRespSub)
 	if err != nil {
+		finish(err, ErrorCategoryNats)
 		return nil, err
 	}
+	done := trackRequest(nc)
 
 	// Send the request.
 	// If processing is synchronous, use Proxy() which returns the response message.
-	hubReqSub, _ := names.ProxyHandlerSubjects()
-	if err := nc.PublishRequest(hubReqSub, edgeRespSub, data); err != nil {
+	h := nats.Header{}
+	h.Set(HeaderKeyPublishedAt, time.Now().UTC().Format(time.RFC3339Nano))
+	h.Set(HeaderKeyRequestClass, class)
+	InjectTraceContext(ctx, h)
+	if err := nc.PublishMsg(&nats.Msg{
+		Subject: hubReqSub,
+		Reply:   edgeRespSub,
+		Header:  h,
+		Data:    data,
+	}); err != nil {
+		_ = sub.Unsubscribe()
+		done()
+		finish(err, ErrorCategoryNats)
 		return nil, err
 	}
 
 	r, w := io.Pipe()
 	go func() {
 		var e2 error
+		first := true
 
 		defer func() {
+			// record the result before the reader can observe it
+			finish(e2, errorCategory(e2))
 			if e2 != nil {
 				_ = w.CloseWithError(e2)
 			} else {
 				_ = w.Close() // nolint:errcheck
 			}
 			_ = sub.Unsubscribe()
+			done()
 		}()
 
 		for {
+			// the edge starts the response within timeout, see R.Timeout. Once it started,
+			// the response may stay idle as long as the request, eg. for watches.
+			waitCtx, cancel := ctx, context.CancelFunc(func() {})
+			if first {
+				waitCtx, cancel = context.WithTimeout(ctx, timeout)
+			}
 			var msg *nats.Msg
-			msg, e2 = sub.NextMsg(timeout)
+			msg, e2 = sub.NextMsgWithContext(waitCtx)
+			cancel()
 			if e2 != nil {
-				if e2 == nats.ErrTimeout {
-					e2 = nil
-					continue // ignore ErrTimeout
-				}
 				break
 			}
+			if first {
+				first = false
+				obs.firstByte()
+				span.AddEvent("first byte")
+			}
 
 			_, e2 = w.Write(msg.Data)
 			if e2 != nil {
@@ -239,12 +309,53 @@ func Proxy(req *http.Request, nc *nats.Conn, names shared.SubjectNames, data []b
 			}
 			if results, ok := msg.Header[HeaderKeyDone]; ok {
 				if results[0] != "" {
-					e2 = errors.New(results[0])
+					e2 = &upstreamError{msg: results[0]}
 				}
 				break
 			}
 		}
 	}()
 
-	return http.ReadResponse(bufio.NewReader(r), req)
+	resp, err := http.ReadResponse(bufio.NewReader(r), req)
+	if err != nil {
+		finish(err, ErrorCategoryDecode)
+		_ = r.CloseWithError(err)
+		_ = sub.Unsubscribe()
+		return nil, err
+	}
+	return resp, nil
+}
+
+type requestObserver struct {
+	metrics ClientMetrics
+	linkID  string
+	start   time.Time
+	once    sync.Once
+}
+
+func newRequestObserver(metrics ClientMetrics, linkID string) *requestObserver {
+	o := &requestObserver{
+		metrics: metrics,
+		linkID:  linkID,
+		start:   time.Now(),
+	}
+	if metrics != nil {
+		metrics.RequestStarted(linkID)
+	}
+	return o
+}
+
+// firstByte and done don't record requests of revoked links, their series are deleted by RevokeLink.
+func (o *requestObserver) firstByte() {
+	if o.metrics != nil && !IsLinkRevoked(o.linkID) {
+		o.metrics.FirstByte(o.linkID, time.Since(o.start))
+	}
+}
+
+func (o *requestObserver) done(category string) {
+	if o.metrics != nil && !IsLinkRevoked(o.linkID) {
+		o.once.Do(func() {
+			o.metrics.RequestDone(o.linkID, time.Since(o.start), category)
+		})
+	}
 }
@@ -0,0 +1,283 @@
+/*
+Copyright AppsCode Inc. and Contributors
+
+Licensed under the AppsCode Community License 1.0.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://github.com/appscode/licenses/raw/1.0.0/AppsCode-Community-1.0.0.md
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package transport
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nkeys"
+	"github.com/spf13/pflag"
+	"k8s.io/apimachinery/pkg/util/wait"
+)
+
+// ConnectionOptions holds the options used to connect to a NATS server.
+// Defaults are read from the environment, so that secrets need not be passed as flags.
+type ConnectionOptions struct {
+	Addr string
+	Name string
+
+	// Authentication. The first configured method is used in the following order.
+	CredFile     string // chained JWT + seed file
+	JWTFile      string // user JWT, used with SeedFile
+	SeedFile     string
+	NkeySeedFile string
+	Token        string
+	Username     string
+	Password     string
+	// UserJWT, if set, returns a user JWT that replaces the one in CredFile, eg. after the hub renewed it.
+	// It is called on every connection attempt, the seed is still read from CredFile. If it returns
+	// an empty JWT, the one in CredFile is used.
+	UserJWT func() string
+
+	// TLS
+	CAFile        string
+	CertFile      string
+	KeyFile       string
+	TLSServerName string
+
+	PingInterval     time.Duration
+	ReconnectBufSize int
+
+	// Initial connection is retried with exponential backoff until ConnectTimeout expires.
+	// A zero ConnectTimeout retries until the context passed to Connect is done.
+	ConnectTimeout          time.Duration
+	ConnectRetryInterval    time.Duration
+	ConnectRetryMaxInterval time.Duration
+	ConnectJitter           float64
+
+	// CredentialReloadInterval is how often the credential and TLS files are checked for changes.
+	// Zero disables reloading.
+	CredentialReloadInterval time.Duration
+	// CredentialReloadDrainTimeout is how long requests received on the connection with the
+	// old credentials may run after a reload, before that connection is closed.
+	CredentialReloadDrainTimeout time.Duration
+}
+
+func NewConnectionOptions() *ConnectionOptions {
+	return &ConnectionOptions{
+		Addr:             os.Getenv("NATS_ADDR"),
+		Name:             os.Getenv("NATS_CONNECTION_NAME"),
+		CredFile:         os.Getenv("NATS_CREDENTIAL_FILE"),
+		JWTFile:          os.Getenv("NATS_JWT_FILE"),
+		SeedFile:         os.Getenv("NATS_SEED_FILE"),
+		NkeySeedFile:     os.Getenv("NATS_NKEY_SEED_FILE"),
+		Token:            os.Getenv("NATS_TOKEN"),
+		Username:         os.Getenv("NATS_USERNAME"),
+		Password:         os.Getenv("NATS_PASSWORD"),
+		CAFile:           os.Getenv("NATS_CA"),
+		CertFile:         os.Getenv("NATS_CERTIFICATE"),
+		KeyFile:          os.Getenv("NATS_KEY"),
+		TLSServerName:    os.Getenv("NATS_TLS_SERVER_NAME"),
+		PingInterval:     nats.DefaultPingInterval,
+		ReconnectBufSize: nats.DefaultReconnectBufSize,
+
+		ConnectRetryInterval:    natsConnectionRetryInterval,
+		ConnectRetryMaxInterval: natsConnectionRetryMaxInterval,
+		ConnectJitter:           natsConnectionJitter,
+
+		CredentialReloadInterval:     30 * time.Second,
+		CredentialReloadDrainTimeout: 10 * time.Minute,
+	}
+}
+
+func (o *ConnectionOptions) AddFlags(fs *pflag.FlagSet) {
+	fs.StringVar(&o.Addr, "nats-addr", o.Addr, "The NATS server address (only used for development).")
+	fs.StringVar(&o.Name, "nats-connection-name", o.Name, "Name of the NATS connection")
+	fs.StringVar(&o.CredFile, "nats-credential-file", o.CredFile, "PATH to NATS credential file")
+	fs.StringVar(&o.JWTFile, "nats-jwt-file", o.JWTFile, "PATH to NATS user JWT file, used with --nats-seed-file")
+	fs.StringVar(&o.SeedFile, "nats-seed-file", o.SeedFile, "PATH to NATS user seed file, used with --nats-jwt-file")
+	fs.StringVar(&o.NkeySeedFile, "nats-nkey-seed-file", o.NkeySeedFile, "PATH to NATS nkey seed file")
+	fs.StringVar(&o.Token, "nats-token", o.Token, "Token used to authenticate with NATS server (defaults to NATS_TOKEN)")
+	fs.StringVar(&o.Username, "nats-username", o.Username, "Username used to authenticate with NATS server, used with --nats-password (defaults to NATS_USERNAME)")
+	fs.StringVar(&o.Password, "nats-password", o.Password, "Password used to authenticate with NATS server, used with --nats-username (defaults to NATS_PASSWORD)")
+	fs.StringVar(&o.CAFile, "nats-ca-file", o.CAFile, "PATH to CA certificate file used to verify NATS server")
+	fs.StringVar(&o.CertFile, "nats-cert-file", o.CertFile, "PATH to client certificate file used for NATS mTLS")
+	fs.StringVar(&o.KeyFile, "nats-key-file", o.KeyFile, "PATH to client key file used for NATS mTLS")
+	fs.StringVar(&o.TLSServerName, "nats-tls-server-name", o.TLSServerName, "Server name used to verify NATS server certificate")
+	fs.DurationVar(&o.PingInterval, "nats-ping-interval", o.PingInterval, "Interval between pings sent to NATS server")
+	fs.IntVar(&o.ReconnectBufSize, "nats-reconnect-buf-size", o.ReconnectBufSize, "Size of the buffer used to hold published messages while reconnecting to NATS")
+	fs.DurationVar(&o.ConnectTimeout, "nats-connect-timeout", o.ConnectTimeout, "Maximum duration to retry the initial NATS connection. Zero means retry until shutdown.")
+	fs.DurationVar(&o.ConnectRetryInterval, "nats-connect-retry-interval", o.ConnectRetryInterval, "Initial interval between NATS connection attempts")
+	fs.DurationVar(&o.ConnectRetryMaxInterval, "nats-connect-retry-max-interval", o.ConnectRetryMaxInterval, "Maximum interval between NATS connection attempts")
+	fs.Float64Var(&o.ConnectJitter, "nats-connect-jitter", o.ConnectJitter, "Jitter factor added to the interval between NATS connection attempts")
+	fs.DurationVar(&o.CredentialReloadInterval, "nats-credential-reload-interval", o.CredentialReloadInterval, "Interval between checks of the NATS credential and TLS files for changes. A change reconnects with the new files without dropping in-flight requests. Zero disables reloading.")
+	fs.DurationVar(&o.CredentialReloadDrainTimeout, "nats-credential-reload-drain-timeout", o.CredentialReloadDrainTimeout, "How long requests received before a credential reload may run before the old NATS connection is closed")
+}
+
+func (o *ConnectionOptions) Validate() error {
+	var errs []error
+	if o.Addr == "" {
+		errs = append(errs, errors.New("missing NATS server address"))
+	}
+	if (o.JWTFile == "") != (o.SeedFile == "") {
+		errs = append(errs, errors.New("NATS jwt file and seed file must be set together"))
+	}
+	if (o.CertFile == "") != (o.KeyFile == "") {
+		errs = append(errs, errors.New("NATS client certificate and key file must be set together"))
+	}
+	if (o.Username == "") != (o.Password == "") {
+		errs = append(errs, errors.New("NATS username and password must be set together"))
+	}
+	if o.ConnectTimeout < 0 {
+		errs = append(errs, errors.New("NATS connect timeout must not be negative"))
+	}
+	if o.ConnectRetryInterval <= 0 || o.ConnectRetryMaxInterval < o.ConnectRetryInterval {
+		errs = append(errs, errors.New("NATS connect retry interval must be positive and not exceed the max interval"))
+	}
+	if o.ConnectJitter < 0 {
+		errs = append(errs, errors.New("NATS connect jitter must not be negative"))
+	}
+	if o.CredentialReloadInterval < 0 || o.CredentialReloadDrainTimeout < 0 {
+		errs = append(errs, errors.New("NATS credential reload interval and drain timeout must not be negative"))
+	}
+	return errors.Join(errs...)
+}
+
+// NatsOptions returns the nats.Option list for these ConnectionOptions.
+func (o *ConnectionOptions) NatsOptions() ([]nats.Option, error) {
+	if err := o.Validate(); err != nil {
+		return nil, err
+	}
+
+	name := o.Name
+	if name == "" {
+		hostname, _ := os.Hostname()
+		name = fmt.Sprintf("scanner-backend.%s", hostname)
+	}
+	opts := []nats.Option{
+		nats.Name(name),
+		nats.MaxReconnects(-1),
+		nats.ErrorHandler(errorHandler),
+		nats.ReconnectHandler(reconnectHandler),
+		nats.DisconnectErrHandler(disconnectHandler),
+		nats.PingInterval(o.PingInterval),
+		nats.ReconnectBufSize(o.ReconnectBufSize),
+		// nats.UseOldRequestStyle(),
+	}
+
+	switch {
+	case o.CredFile != "" && fileExists(o.CredFile) && o.UserJWT != nil:
+		opts = append(opts, nats.UserJWT(o.userJWT, o.signNonce))
+	case o.CredFile != "" && fileExists(o.CredFile):
+		opts = append(opts, nats.UserCredentials(o.CredFile))
+	case o.JWTFile != "":
+		opts = append(opts, nats.UserCredentials(o.JWTFile, o.SeedFile))
+	case o.NkeySeedFile != "":
+		opt, err := nats.NkeyOptionFromSeed(o.NkeySeedFile)
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, opt)
+	case o.Token != "":
+		opts = append(opts, nats.Token(o.Token))
+	case o.Username != "":
+		opts = append(opts, nats.UserInfo(o.Username, o.Password))
+	}
+
+	if o.TLSServerName != "" {
+		opts = append(opts, nats.Secure(&tls.Config{
+			MinVersion: tls.VersionTLS12,
+			ServerName: o.TLSServerName,
+		}))
+	}
+	if o.CAFile != "" {
+		opts = append(opts, nats.RootCAs(o.CAFile))
+	}
+	if o.CertFile != "" {
+		opts = append(opts, nats.ClientCert(o.CertFile, o.KeyFile))
+	}
+	return opts, nil
+}
+
+func (o *ConnectionOptions) userJWT() (string, error) {
+	if token := o.UserJWT(); token != "" {
+		return token, nil
+	}
+	data, err := os.ReadFile(o.CredFile)
+	if err != nil {
+		return "", err
+	}
+	return nkeys.ParseDecoratedJWT(data)
+}
+
+func (o *ConnectionOptions) signNonce(nonce []byte) ([]byte, error) {
+	data, err := os.ReadFile(o.CredFile)
+	if err != nil {
+		return nil, err
+	}
+	kp, err := nkeys.ParseDecoratedUserNKey(data)
+	if err != nil {
+		return nil, err
+	}
+	defer kp.Wipe()
+	return kp.Sign(nonce)
+}
+
+// ConnectBackoff returns the backoff used to retry the initial connection.
+func (o *ConnectionOptions) ConnectBackoff() wait.Backoff {
+	return wait.Backoff{
+		Duration: o.ConnectRetryInterval,
+		Factor:   2,
+		Jitter:   o.ConnectJitter,
+		Steps:    math.MaxInt32,
+		Cap:      o.ConnectRetryMaxInterval,
+	}
+}
+
+// CredentialFiles returns the files the connection is authenticated with.
+func (o *ConnectionOptions) CredentialFiles() []string {
+	var files []string
+	for _, f := range []string{o.CredFile, o.JWTFile, o.SeedFile, o.NkeySeedFile, o.CAFile, o.CertFile, o.KeyFile} {
+		if f != "" {
+			files = append(files, f)
+		}
+	}
+	return files
+}
+
+// CredentialsChecksum returns a checksum of the content of the CredentialFiles and the UserJWT.
+// A missing file is part of the checksum, so that creating it is detected as a change.
+func (o *ConnectionOptions) CredentialsChecksum() (string, error) {
+	h := sha256.New()
+	if o.UserJWT != nil {
+		_, _ = fmt.Fprintf(h, "jwt:%x\n", sha256.Sum256([]byte(o.UserJWT())))
+	}
+	for _, f := range o.CredentialFiles() {
+		data, err := os.ReadFile(f)
+		if os.IsNotExist(err) {
+			data = nil
+		} else if err != nil {
+			return "", err
+		}
+		sum := sha256.Sum256(data)
+		_, _ = fmt.Fprintf(h, "%s=%t:%x\n", f, err == nil, sum)
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+func fileExists(filename string) bool {
+	_, err := os.Stat(filename)
+	return err == nil
+}
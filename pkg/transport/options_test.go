@@ -0,0 +1,214 @@
+/*
+Copyright AppsCode Inc. and Contributors
+
+Licensed under the AppsCode Community License 1.0.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://github.com/appscode/licenses/raw/1.0.0/AppsCode-Community-1.0.0.md
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package transport
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats.go"
+)
+
+func applyOptions(t *testing.T, o *ConnectionOptions) nats.Options {
+	t.Helper()
+
+	opts, err := o.NatsOptions()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	no := nats.GetDefaultOptions()
+	for _, opt := range opts {
+		if err := opt(&no); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	return no
+}
+
+func writeFile(t *testing.T, name, data string) string {
+	t.Helper()
+
+	filename := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(filename, []byte(data), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return filename
+}
+
+func TestConnectionOptionsTLS(t *testing.T) {
+	o := NewConnectionOptions()
+	o.Addr = "nats://localhost:4222"
+	o.Name = "test"
+	o.CAFile = writeFile(t, "ca.crt", rootCACert)
+	o.CertFile = writeFile(t, "tls.crt", certData)
+	o.KeyFile = writeFile(t, "tls.key", keyData)
+	o.TLSServerName = "nats.example.com"
+	o.PingInterval = 10 * time.Second
+	o.ReconnectBufSize = 1024
+	no := applyOptions(t, o)
+
+	if !no.Secure || no.TLSConfig == nil {
+		t.Fatal("expected secure connection")
+	}
+	if no.TLSConfig.ServerName != o.TLSServerName {
+		t.Errorf("got server name %q, expected %q", no.TLSConfig.ServerName, o.TLSServerName)
+	}
+	if no.RootCAsCB == nil || no.TLSCertCB == nil {
+		t.Error("expected root CAs and client certificate to be configured")
+	}
+	if no.Name != "test" || no.PingInterval != 10*time.Second || no.ReconnectBufSize != 1024 {
+		t.Errorf("unexpected connection settings: name=%s ping=%v bufSize=%d", no.Name, no.PingInterval, no.ReconnectBufSize)
+	}
+}
+
+func TestConnectionOptionsAuth(t *testing.T) {
+	o := NewConnectionOptions()
+	o.Addr = "nats://localhost:4222"
+	o.CredFile = "/missing/creds"
+	o.Token = "s3cr3t"
+	o.Username = "user"
+	o.Password = "pass"
+	no := applyOptions(t, o)
+	if no.Token != "s3cr3t" || no.User != "" {
+		t.Errorf("expected token auth to take precedence, got token=%q user=%q", no.Token, no.User)
+	}
+
+	o.Token = ""
+	no = applyOptions(t, o)
+	if no.User != "user" || no.Password != "pass" {
+		t.Errorf("expected user/password auth, got user=%q", no.User)
+	}
+}
+
+func TestConnectionOptionsValidate(t *testing.T) {
+	testCases := map[string]func(o *ConnectionOptions){
+		"missing addr":       func(o *ConnectionOptions) { o.Addr = "" },
+		"jwt without seed":   func(o *ConnectionOptions) { o.JWTFile = "user.jwt" },
+		"cert without key":   func(o *ConnectionOptions) { o.CertFile = "tls.crt" },
+		"user without pass":  func(o *ConnectionOptions) { o.Username = "user" },
+		"negative timeout":   func(o *ConnectionOptions) { o.ConnectTimeout = -time.Second },
+		"zero retry":         func(o *ConnectionOptions) { o.ConnectRetryInterval = 0 },
+		"max below interval": func(o *ConnectionOptions) { o.ConnectRetryMaxInterval = time.Millisecond },
+	}
+	for name, mutate := range testCases {
+		t.Run(name, func(t *testing.T) {
+			o := &ConnectionOptions{
+				Addr:                    "nats://localhost:4222",
+				ConnectRetryInterval:    time.Second,
+				ConnectRetryMaxInterval: time.Minute,
+			}
+			if err := o.Validate(); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			mutate(o)
+			if err := o.Validate(); err == nil {
+				t.Fatal("unexpected non-error")
+			}
+		})
+	}
+}
+
+func TestConnectionOptionsBackoff(t *testing.T) {
+	o := NewConnectionOptions()
+	o.ConnectRetryInterval = 100 * time.Millisecond
+	o.ConnectRetryMaxInterval = time.Second
+	o.ConnectJitter = 0
+
+	backoff := o.ConnectBackoff()
+	var got []time.Duration
+	for i := 0; i < 6; i++ {
+		got = append(got, backoff.Step())
+	}
+	expected := []time.Duration{
+		100 * time.Millisecond,
+		200 * time.Millisecond,
+		400 * time.Millisecond,
+		800 * time.Millisecond,
+		time.Second,
+		time.Second,
+	}
+	for i := range expected {
+		if got[i] != expected[i] {
+			t.Fatalf("got backoff %v, expected %v", got, expected)
+		}
+	}
+}
+
+func TestConnectTimeout(t *testing.T) {
+	o := NewConnectionOptions()
+	o.Addr = "nats://127.0.0.1:1"
+	o.ConnectTimeout = 300 * time.Millisecond
+	o.ConnectRetryInterval = 10 * time.Millisecond
+
+	start := time.Now()
+	nc, err := o.Connect(context.Background())
+	if err == nil {
+		nc.Close()
+		t.Fatal("unexpected non-error")
+	}
+	if d := time.Since(start); d < o.ConnectTimeout {
+		t.Errorf("gave up after %v, expected retries for at least %v", d, o.ConnectTimeout)
+	}
+}
+
+func TestCredentialsChecksum(t *testing.T) {
+	o := NewConnectionOptions()
+	o.CredFile = writeFile(t, "user.creds", "creds 1")
+	o.CAFile = filepath.Join(t.TempDir(), "ca.crt") // not mounted yet
+
+	checksum := func() string {
+		t.Helper()
+		sum, err := o.CredentialsChecksum()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return sum
+	}
+
+	initial := checksum()
+	if got := checksum(); got != initial {
+		t.Errorf("got checksum %s for unchanged files, expected %s", got, initial)
+	}
+
+	if err := os.WriteFile(o.CredFile, []byte("creds 2"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	rotated := checksum()
+	if rotated == initial {
+		t.Error("expected checksum to change after the credentials were rotated")
+	}
+
+	if err := os.WriteFile(o.CAFile, nil, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	created := checksum()
+	if created == rotated {
+		t.Error("expected checksum to change after an empty file was created")
+	}
+
+	// a renewed JWT reconnects like rotated credentials
+	var token string
+	o.UserJWT = func() string { return token }
+	withJWT := checksum()
+	token = "renewed"
+	if got := checksum(); got == withJWT || got == created {
+		t.Error("expected checksum to change after the user JWT was renewed")
+	}
+}
@@ -0,0 +1,308 @@
+/*
+Copyright AppsCode Inc. and Contributors
+
+Licensed under the AppsCode Community License 1.0.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://github.com/appscode/licenses/raw/1.0.0/AppsCode-Community-1.0.0.md
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package transport
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"k8s.io/klog/v2"
+)
+
+// ErrPoolClosed is returned for connections requested from a closed ConnectionPool.
+var ErrPoolClosed = errors.New("connection pool closed")
+
+const (
+	poolHealthCheckInterval = 30 * time.Second
+	poolHealthCheckTimeout  = 5 * time.Second
+	// poolMaxHealthCheckFailures is how many consecutive health checks a connection may fail before it is replaced.
+	poolMaxHealthCheckFailures = 3
+)
+
+// inFlight counts the proxied requests by connection, see trackRequest.
+var inFlight sync.Map // *nats.Conn -> *atomic.Int64
+
+// trackRequest counts a request proxied over nc until done is called, so that
+// a ConnectionPool does not close the connection of a streaming response as idle.
+func trackRequest(nc *nats.Conn) (done func()) {
+	v, _ := inFlight.LoadOrStore(nc, new(atomic.Int64))
+	n := v.(*atomic.Int64)
+	n.Add(1)
+	return func() { n.Add(-1) }
+}
+
+// requestsInFlight returns the number of requests proxied over nc that have not completed.
+func requestsInFlight(nc *nats.Conn) int64 {
+	v, found := inFlight.Load(nc)
+	if !found {
+		return 0
+	}
+	return v.(*atomic.Int64).Load()
+}
+
+// ConnectionPool lazily opens and caches one NATS connection per account, eg. the account of a tenant.
+// Connections are health checked while the pool is started. Closed and unhealthy connections are
+// replaced on next use, idle ones are closed.
+type ConnectionPool struct {
+	options func(account string) (*ConnectionOptions, error)
+
+	// HealthCheckInterval is how often connections are checked with a round trip to the server.
+	HealthCheckInterval time.Duration
+	HealthCheckTimeout  time.Duration
+	// IdleTimeout is how long a connection may not be used before it is closed. Zero keeps idle connections.
+	IdleTimeout time.Duration
+
+	// ctx is passed to the connection attempts, it is cancelled by Close
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	mu     sync.RWMutex
+	conns  map[string]*poolConn
+	closed bool
+}
+
+type poolConn struct {
+	// ready is closed when the connection attempt completes, nc and err are set before
+	ready chan struct{}
+	nc    *nats.Conn
+	err   error
+
+	// lastUsed is the unix nano time the connection was last returned by Get
+	lastUsed atomic.Int64
+	// failures is only used by the health check loop
+	failures int
+}
+
+// NewConnectionPool returns a pool that connects to an account with the options returned by options.
+// Connection attempts are retried until ConnectTimeout expires, see ConnectionOptions.Connect.
+func NewConnectionPool(options func(account string) (*ConnectionOptions, error)) *ConnectionPool {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &ConnectionPool{
+		options:             options,
+		HealthCheckInterval: poolHealthCheckInterval,
+		HealthCheckTimeout:  poolHealthCheckTimeout,
+		ctx:                 ctx,
+		cancel:              cancel,
+		conns:               map[string]*poolConn{},
+	}
+}
+
+// Get returns the connection of the account, opening it if needed. Concurrent callers share
+// a single connection attempt. A failed attempt is returned to its waiting callers and retried by
+// the next call. If ctx is done before the connection is open, ctx.Err() is returned.
+func (p *ConnectionPool) Get(ctx context.Context, account string) (*nats.Conn, error) {
+	for {
+		pc, err := p.get(account)
+		if err != nil {
+			return nil, err
+		}
+		select {
+		case <-pc.ready:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+		if pc.err != nil {
+			return nil, pc.err
+		}
+		if pc.nc.IsClosed() {
+			// eg. closed by the server, do not wait for the health check to replace it
+			p.remove(account, pc, "closed")
+			continue
+		}
+		pc.lastUsed.Store(time.Now().UnixNano())
+		return pc.nc, nil
+	}
+}
+
+// get returns the connection of the account, starting a connection attempt if there is none.
+func (p *ConnectionPool) get(account string) (*poolConn, error) {
+	p.mu.RLock()
+	pc, found := p.conns[account]
+	p.mu.RUnlock()
+	if found {
+		return pc, nil
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.closed {
+		return nil, ErrPoolClosed
+	}
+	if pc, found = p.conns[account]; !found {
+		pc = &poolConn{ready: make(chan struct{})}
+		p.conns[account] = pc
+		go p.connect(account, pc)
+	}
+	return pc, nil
+}
+
+func (p *ConnectionPool) connect(account string, pc *poolConn) {
+	nc, err := func() (*nats.Conn, error) {
+		opts, err := p.options(account)
+		if err != nil {
+			return nil, err
+		}
+		return opts.Connect(p.ctx)
+	}()
+
+	p.mu.Lock()
+	if err == nil && p.closed {
+		nc.Close()
+		nc, err = nil, ErrPoolClosed
+	}
+	if err != nil && p.conns[account] == pc {
+		delete(p.conns, account)
+	}
+	pc.nc, pc.err = nc, err
+	pc.lastUsed.Store(time.Now().UnixNano())
+	close(pc.ready)
+	p.mu.Unlock()
+
+	if err != nil {
+		klog.ErrorS(err, "failed to connect to nats", "account", account)
+	} else {
+		klog.V(3).InfoS("connected to nats", "account", account, "url", nc.ConnectedUrl())
+	}
+}
+
+// Start checks the health of the connections until ctx is done. It must not be called more than once.
+// It does not close the connections, see Close.
+func (p *ConnectionPool) Start(ctx context.Context) error {
+	ticker := time.NewTicker(p.HealthCheckInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+			p.checkHealth(time.Now())
+		}
+	}
+}
+
+// checkHealth removes closed, idle and unhealthy connections. Removed connections are drained,
+// so that requests still using them can complete. Connections with requests in flight are in use.
+func (p *ConnectionPool) checkHealth(now time.Time) {
+	type entry struct {
+		account string
+		pc      *poolConn
+	}
+	var entries []entry
+	p.mu.RLock()
+	for account, pc := range p.conns {
+		select {
+		case <-pc.ready:
+			if pc.err == nil {
+				entries = append(entries, entry{account, pc})
+			}
+		default: // still connecting
+		}
+	}
+	p.mu.RUnlock()
+
+	for _, e := range entries {
+		nc := e.pc.nc
+		if requestsInFlight(nc) > 0 {
+			// eg. a watch, draining the connection would end its stream
+			e.pc.lastUsed.Store(now.UnixNano())
+		}
+		var reason string
+		switch {
+		case nc.IsClosed():
+			reason = "closed"
+		case p.IdleTimeout > 0 && now.Sub(time.Unix(0, e.pc.lastUsed.Load())) > p.IdleTimeout:
+			reason = "idle"
+		case !nc.IsConnected():
+			// the client reconnects on its own, give it a few checks
+			e.pc.failures++
+		default:
+			if err := nc.FlushTimeout(p.HealthCheckTimeout); err != nil {
+				klog.V(3).InfoS("nats health check failed", "account", e.account, "error", err)
+				e.pc.failures++
+			} else {
+				e.pc.failures = 0
+			}
+		}
+		if reason == "" && e.pc.failures >= poolMaxHealthCheckFailures {
+			reason = "unhealthy"
+		}
+		if reason != "" {
+			p.remove(e.account, e.pc, reason)
+		}
+	}
+}
+
+// remove closes the connection, unless it was replaced already.
+func (p *ConnectionPool) remove(account string, pc *poolConn, reason string) {
+	p.mu.Lock()
+	if p.conns[account] != pc {
+		p.mu.Unlock()
+		return
+	}
+	delete(p.conns, account)
+	p.mu.Unlock()
+
+	klog.V(3).InfoS("closing nats connection", "account", account, "reason", reason)
+	tlsCache.purgeConn(pc.nc)
+	inFlight.Delete(pc.nc)
+	if err := pc.nc.Drain(); err != nil {
+		pc.nc.Close()
+	}
+}
+
+// Close cancels pending connection attempts and drains the open connections. Get fails afterwards.
+func (p *ConnectionPool) Close() error {
+	p.mu.Lock()
+	p.closed = true
+	conns := p.conns
+	p.conns = map[string]*poolConn{}
+	p.mu.Unlock()
+	p.cancel()
+
+	var errs []error
+	for _, pc := range conns {
+		<-pc.ready
+		if pc.err != nil {
+			continue
+		}
+		tlsCache.purgeConn(pc.nc)
+		inFlight.Delete(pc.nc)
+		if err := pc.nc.Drain(); err != nil && !errors.Is(err, nats.ErrConnectionClosed) {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// purgeConn removes the transports using nc from the cache and returns the number of transports removed.
+func (c *tlsTransportCache) purgeConn(nc *nats.Conn) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	n := 0
+	for k := range c.transports {
+		if k.conn == nc {
+			delete(c.transports, k)
+			n++
+		}
+	}
+	return n
+}
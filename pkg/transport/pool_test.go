@@ -0,0 +1,233 @@
+/*
+Copyright AppsCode Inc. and Contributors
+
+Licensed under the AppsCode Community License 1.0.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://github.com/appscode/licenses/raw/1.0.0/AppsCode-Community-1.0.0.md
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package transport
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// fakeServer speaks enough of the NATS protocol to connect and flush.
+type fakeServer struct {
+	ln      net.Listener
+	accepts atomic.Int32
+
+	mu    sync.Mutex
+	conns []net.Conn
+}
+
+func newFakeServer(t *testing.T) *fakeServer {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &fakeServer{ln: ln}
+	t.Cleanup(s.close)
+	go func() {
+		for {
+			c, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			s.accepts.Add(1)
+			s.mu.Lock()
+			s.conns = append(s.conns, c)
+			s.mu.Unlock()
+			go s.serve(c)
+		}
+	}()
+	return s
+}
+
+func (s *fakeServer) addr() string {
+	return "nats://" + s.ln.Addr().String()
+}
+
+func (s *fakeServer) serve(c net.Conn) {
+	defer c.Close() // nolint:errcheck
+	_, _ = fmt.Fprintf(c, "INFO {\"server_id\":\"fake\",\"version\":\"2.10.0\",\"proto\":1,\"headers\":true,\"max_payload\":1048576}\r\n")
+	r := bufio.NewReader(c)
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		if strings.HasPrefix(line, "PING") {
+			_, _ = c.Write([]byte("PONG\r\n"))
+		}
+	}
+}
+
+// dropConns closes the connections of the clients.
+func (s *fakeServer) dropConns() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, c := range s.conns {
+		_ = c.Close()
+	}
+	s.conns = nil
+}
+
+func (s *fakeServer) close() {
+	_ = s.ln.Close()
+	s.dropConns()
+}
+
+func newTestPool(addrs map[string]string) *ConnectionPool {
+	return NewConnectionPool(func(account string) (*ConnectionOptions, error) {
+		addr, found := addrs[account]
+		if !found {
+			return nil, errors.New("unknown account")
+		}
+		o := NewConnectionOptions()
+		o.Addr = addr
+		o.CredFile, o.JWTFile, o.SeedFile, o.NkeySeedFile, o.Token, o.Username, o.Password = "", "", "", "", "", "", ""
+		o.ConnectTimeout = time.Second
+		o.ConnectRetryInterval = 10 * time.Millisecond
+		return o, nil
+	})
+}
+
+func TestConnectionPoolGet(t *testing.T) {
+	a, b := newFakeServer(t), newFakeServer(t)
+	p := newTestPool(map[string]string{"a": a.addr(), "b": b.addr(), "down": "nats://127.0.0.1:1"})
+	defer p.Close() // nolint:errcheck
+
+	// concurrent callers share one connection per account
+	ctx := context.Background()
+	var wg sync.WaitGroup
+	conns := make([]any, 50)
+	for i := range conns {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			nc, err := p.Get(ctx, "a")
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			conns[i] = nc
+		}(i)
+	}
+	wg.Wait()
+	for i := range conns {
+		if conns[i] != conns[0] {
+			t.Fatalf("got different connections for the same account")
+		}
+	}
+	if n := a.accepts.Load(); n != 1 {
+		t.Errorf("got %d connections to account a, expected 1", n)
+	}
+
+	nb, err := p.Get(ctx, "b")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if any(nb) == conns[0] {
+		t.Error("got the same connection for different accounts")
+	}
+
+	if _, err := p.Get(ctx, "unknown"); err == nil {
+		t.Error("expected unknown account to fail")
+	}
+	short, cancel := context.WithTimeout(ctx, 50*time.Millisecond)
+	defer cancel()
+	if _, err := p.Get(short, "down"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("got %v, expected %v", err, context.DeadlineExceeded)
+	}
+}
+
+func TestConnectionPoolHealthCheck(t *testing.T) {
+	s := newFakeServer(t)
+	p := newTestPool(map[string]string{"a": s.addr()})
+	p.HealthCheckTimeout = 100 * time.Millisecond
+	p.IdleTimeout = time.Minute
+	defer p.Close() // nolint:errcheck
+
+	ctx := context.Background()
+	nc, err := p.Get(ctx, "a")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// healthy connections are kept
+	p.checkHealth(time.Now())
+	if got, _ := p.Get(ctx, "a"); got != nc {
+		t.Error("expected healthy connection to be kept")
+	}
+
+	// closed connections are replaced on next use
+	nc.Close()
+	replaced, err := p.Get(ctx, "a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if replaced == nc || replaced.IsClosed() {
+		t.Error("expected closed connection to be replaced")
+	}
+
+	// connections with requests in flight are in use
+	done := trackRequest(replaced)
+	p.checkHealth(time.Now().Add(2 * time.Minute))
+	if got, _ := p.Get(ctx, "a"); got != replaced {
+		t.Error("expected connection with a request in flight to be kept")
+	}
+	done()
+
+	// idle connections are closed
+	p.checkHealth(time.Now().Add(4 * time.Minute))
+	p.mu.RLock()
+	n := len(p.conns)
+	p.mu.RUnlock()
+	if n != 0 {
+		t.Errorf("got %d connections after idle timeout, expected 0", n)
+	}
+	if n := s.accepts.Load(); n != 2 {
+		t.Errorf("got %d connections, expected 2", n)
+	}
+}
+
+func TestConnectionPoolClose(t *testing.T) {
+	s := newFakeServer(t)
+	p := newTestPool(map[string]string{"a": s.addr()})
+
+	nc, err := p.Get(context.Background(), "a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := p.Get(context.Background(), "a"); !errors.Is(err, ErrPoolClosed) {
+		t.Errorf("got %v, expected %v", err, ErrPoolClosed)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for !nc.IsClosed() && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if !nc.IsClosed() {
+		t.Error("expected connection to be drained")
+	}
+}
@@ -0,0 +1,61 @@
+/*
+Copyright AppsCode Inc. and Contributors
+
+Licensed under the AppsCode Community License 1.0.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://github.com/appscode/licenses/raw/1.0.0/AppsCode-Community-1.0.0.md
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package transport
+
+import (
+	"errors"
+	"sync"
+)
+
+// ErrLinkRevoked is returned for requests proxied to a revoked link.
+var ErrLinkRevoked = errors.New("link revoked")
+
+// revokedLinks holds the ids of the links revoked by this process.
+// Link ids are never reused, so entries are not removed.
+var revokedLinks sync.Map
+
+// RevokeLink stops proxying requests to the subjects of the link, including requests
+// made with transports and clients created before, and purges the cached transports of the link.
+// The metric series of the link are deleted from the ClientMetrics set via SetClientMetrics.
+func RevokeLink(linkID string) {
+	revokedLinks.Store(linkID, struct{}{})
+	tlsCache.purge(linkID)
+	if d, ok := defaultClientMetrics().(LinkMetricsDeleter); ok {
+		d.DeleteLink(linkID)
+	}
+}
+
+// IsLinkRevoked returns true if RevokeLink was called for the link.
+func IsLinkRevoked(linkID string) bool {
+	_, found := revokedLinks.Load(linkID)
+	return found
+}
+
+// purge removes the transports of the link from the cache and returns the number of transports removed.
+func (c *tlsTransportCache) purge(linkID string) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	n := 0
+	for k := range c.transports {
+		if k.linkID == linkID {
+			delete(c.transports, k)
+			n++
+		}
+	}
+	return n
+}
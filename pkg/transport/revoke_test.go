@@ -0,0 +1,62 @@
+/*
+Copyright AppsCode Inc. and Contributors
+
+Licensed under the AppsCode Community License 1.0.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://github.com/appscode/licenses/raw/1.0.0/AppsCode-Community-1.0.0.md
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package transport
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"kubeops.dev/cluster-connector/pkg/shared"
+
+	"k8s.io/client-go/transport"
+)
+
+func TestRevokeLink(t *testing.T) {
+	revoked := shared.CrossAccountNames{LinkID: "revoke-test-1"}
+	other := shared.CrossAccountNames{LinkID: "revoke-test-2"}
+
+	rt, err := tlsCache.get(&transport.Config{}, nil, revoked, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tlsCache.get(&transport.Config{DisableCompression: true}, nil, revoked, time.Second); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tlsCache.get(&transport.Config{}, nil, other, time.Second); err != nil {
+		t.Fatal(err)
+	}
+
+	RevokeLink(revoked.LinkID)
+
+	if n := tlsCache.purge(revoked.LinkID); n != 0 {
+		t.Errorf("got %d cached transports of the revoked link, expected none", n)
+	}
+	if n := tlsCache.purge(other.LinkID); n != 1 {
+		t.Errorf("got %d cached transports of the other link, expected 1", n)
+	}
+	if !IsLinkRevoked(revoked.LinkID) || IsLinkRevoked(other.LinkID) {
+		t.Errorf("expected only %s to be revoked", revoked.LinkID)
+	}
+
+	// transports created before the link was revoked stop proxying
+	req, _ := http.NewRequest(http.MethodGet, "https://kubernetes/api", nil)
+	if _, err := rt.RoundTrip(req); !errors.Is(err, ErrLinkRevoked) {
+		t.Errorf("got error %v, expected %v", err, ErrLinkRevoked)
+	}
+}
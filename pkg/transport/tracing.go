@@ -0,0 +1,54 @@
+/*
+Copyright AppsCode Inc. and Contributors
+
+Licensed under the AppsCode Community License 1.0.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://github.com/appscode/licenses/raw/1.0.0/AppsCode-Community-1.0.0.md
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package transport
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/nats-io/nats.go"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+	"k8s.io/component-base/tracing"
+)
+
+const tracerName = "kubeops.dev/cluster-connector/pkg/transport"
+
+// Trace context always crosses the NATS hop in W3C format, independent of the global propagator.
+var propagator = tracing.Propagators()
+
+// InjectTraceContext writes the W3C trace context of ctx into the NATS message headers.
+func InjectTraceContext(ctx context.Context, h nats.Header) {
+	propagator.Inject(ctx, propagation.HeaderCarrier(http.Header(h)))
+}
+
+// ExtractTraceContext returns a copy of ctx with the W3C trace context read from the NATS message headers.
+func ExtractTraceContext(ctx context.Context, h nats.Header) context.Context {
+	if h == nil {
+		return ctx
+	}
+	return propagator.Extract(ctx, propagation.HeaderCarrier(http.Header(h)))
+}
+
+func (rt *NatsTransport) tracer() trace.Tracer {
+	tp := rt.TracerProvider
+	if tp == nil {
+		tp = otel.GetTracerProvider()
+	}
+	return tp.Tracer(tracerName)
+}
@@ -0,0 +1,49 @@
+/*
+Copyright AppsCode Inc. and Contributors
+
+Licensed under the AppsCode Community License 1.0.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://github.com/appscode/licenses/raw/1.0.0/AppsCode-Community-1.0.0.md
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package transport
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/nats-io/nats.go"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func TestTraceContextRoundTrip(t *testing.T) {
+	tp := sdktrace.NewTracerProvider()
+	ctx, span := tp.Tracer("test").Start(context.Background(), "hub")
+	defer span.End()
+
+	h := nats.Header{}
+	InjectTraceContext(ctx, h)
+	if http.Header(h).Get("traceparent") == "" {
+		t.Fatalf("traceparent header not set: %v", h)
+	}
+
+	sc := trace.SpanContextFromContext(ExtractTraceContext(context.Background(), h))
+	if !sc.IsRemote() || sc.TraceID() != span.SpanContext().TraceID() || sc.SpanID() != span.SpanContext().SpanID() {
+		t.Errorf("extracted span context %+v, expected remote parent %+v", sc, span.SpanContext())
+	}
+
+	// messages from older hubs do not carry headers
+	if sc := trace.SpanContextFromContext(ExtractTraceContext(context.Background(), nil)); sc.IsValid() {
+		t.Errorf("expected no span context, got %+v", sc)
+	}
+}